@@ -13,6 +13,8 @@ import (
 	"overlord/lib/log"
 	"overlord/lib/prom"
 	"overlord/proxy"
+
+	"github.com/pkg/errors"
 )
 
 const (
@@ -70,8 +72,7 @@ func main() {
 		os.Exit(0)
 	}
 	if check {
-		parseConfig()
-		os.Exit(0)
+		os.Exit(checkConfig())
 	}
 	c, ccs := parseConfig()
 	if initLog(c) {
@@ -84,8 +85,9 @@ func main() {
 	}
 	defer p.Close()
 	p.Serve(ccs)
-	// pprof
+	// pprof & admin
 	if c.Pprof != "" {
+		p.RegisterAdmin(http.DefaultServeMux)
 		go http.ListenAndServe(c.Pprof, nil)
 		if c.Proxy.UseMetrics {
 			prom.Init()
@@ -94,7 +96,7 @@ func main() {
 		}
 	}
 	// hanlde signal
-	signalHandler()
+	signalHandler(p)
 }
 
 func initLog(c *proxy.Config) bool {
@@ -139,37 +141,99 @@ func parseConfig() (c *proxy.Config, ccs []*proxy.ClusterConfig) {
 		c.LogVL = logVl
 	}
 	// high priority end
-	checks := map[string]struct{}{}
+	var err error
+	if ccs, err = parseClusters(); err != nil {
+		panic(err)
+	}
+	return
+}
+
+// parseClusters load all the cluster files, the problems of all files are returned by proxy.ConfigErrors.
+func parseClusters() (ccs []*proxy.ClusterConfig, err error) {
+	var es proxy.ConfigErrors
 	for _, cluster := range clusters {
 		cs := &proxy.ClusterConfigs{}
-		if err := cs.LoadFromFile(cluster); err != nil {
-			panic(err)
+		if err = cs.LoadFromFile(cluster); err != nil {
+			es = appendErrors(es, err, cluster)
 		}
-		for _, cc := range cs.Clusters {
-			if _, ok := checks[cc.Name]; ok {
-				panic("the same cluster name cannot be repeated")
-			}
-			checks[cc.Name] = struct{}{}
+		// NOTE: check the conflicts with the clusters of previous files.
+		if err = proxy.ValidateConflicts(cs.Clusters, ccs); err != nil {
+			es = appendErrors(es, err, cluster)
 		}
 		ccs = append(ccs, cs.Clusters...)
 	}
+	if err = proxy.ValidateBackups(ccs); err != nil {
+		es = appendErrors(es, err, "")
+	}
+	err = es.Err()
 	return
 }
 
-func signalHandler() {
+// checkConfig print all the problems of config files, returns the exit code.
+func checkConfig() int {
+	var es proxy.ConfigErrors
+	if config != "" {
+		c := &proxy.Config{}
+		if err := c.LoadFromFile(config); err != nil {
+			es = appendErrors(es, err, config)
+		}
+	}
+	if _, err := parseClusters(); err != nil {
+		es = appendErrors(es, err, "")
+	}
+	if len(es) == 0 {
+		fmt.Println("overlord proxy config check ok")
+		return 0
+	}
+	for _, e := range es {
+		fmt.Fprintln(os.Stderr, e)
+	}
+	return 1
+}
+
+func appendErrors(es proxy.ConfigErrors, err error, file string) proxy.ConfigErrors {
+	ces, ok := err.(proxy.ConfigErrors)
+	if !ok {
+		ces = proxy.ConfigErrors{err}
+	}
+	for _, e := range ces {
+		if file != "" {
+			e = errors.Wrap(e, file)
+		}
+		es = append(es, e)
+	}
+	return es
+}
+
+func signalHandler(p *proxy.Proxy) {
 	var ch = make(chan os.Signal, 1)
 	signal.Notify(ch, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT)
+	log.Infof("overlord proxy version[%s] already started", VERSION)
 	for {
-		log.Infof("overlord proxy version[%s] already started", VERSION)
 		si := <-ch
-		log.Infof("overlord proxy version[%s] signal(%s) stop the process", VERSION, si.String())
 		switch si {
 		case syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT:
+			log.Infof("overlord proxy version[%s] signal(%s) stop the process", VERSION, si.String())
+			_ = p.Close() // NOTE: stop accepting and drain the in-flight requests
 			log.Infof("overlord proxy version[%s] already exited", VERSION)
 			return
 		case syscall.SIGHUP:
+			log.Infof("overlord proxy version[%s] signal(%s) reload the cluster configs", VERSION, si.String())
+			reload(p)
 		default:
 			return
 		}
 	}
 }
+
+// reload re-read all the cluster config files and apply into proxy.
+func reload(p *proxy.Proxy) {
+	ccs, err := parseClusters()
+	if err != nil {
+		log.Errorf("overlord proxy reload parse cluster configs error:%v", err)
+		return
+	}
+	if err = p.Reload(ccs); err != nil {
+		log.Errorf("overlord proxy reload error:%v", err)
+	}
+}
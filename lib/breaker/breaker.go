@@ -0,0 +1,148 @@
+package breaker
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// State is the state of breaker.
+type State int32
+
+// breaker states.
+const (
+	StateClosed State = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// Config is the config of breaker.
+type Config struct {
+	// ErrorRate is the percent of failed requests in window to open the breaker.
+	ErrorRate int
+	// Latency is the duration over which the requests are counted as failed, 0 means the latency not counted.
+	Latency time.Duration
+	// MinRequests is the min requests in window to judge the error rate.
+	MinRequests int
+	// Window is the duration of counting requests.
+	Window time.Duration
+	// OpenTimeout is the duration of breaker keeping open before probing.
+	OpenTimeout time.Duration
+}
+
+// Breaker is the circuit breaker driven by the error rate of requests in window.
+// The breaker opens when the error rate exceeded and all the requests are failed fast,
+// after open timeout it turns half-open and allows one probe at a time,
+// the probe succeeded closes the breaker and the failed opens again.
+type Breaker struct {
+	cfg      *Config
+	onChange func(from, to State)
+	now      func() time.Time
+
+	state int32 // NOTE: atomic for State without lock
+
+	lock    sync.Mutex
+	start   time.Time // NOTE: the window start when closed, or the time opened
+	total   int
+	fails   int
+	probeAt time.Time // NOTE: zero means no probe in flight
+}
+
+// New new a closed breaker, onChange is called on every state change if not nil.
+func New(cfg *Config, onChange func(from, to State)) *Breaker {
+	return newBreaker(cfg, onChange, time.Now)
+}
+
+func newBreaker(cfg *Config, onChange func(from, to State), now func() time.Time) *Breaker {
+	return &Breaker{cfg: cfg, onChange: onChange, now: now, start: now()}
+}
+
+// State returns the current state.
+func (b *Breaker) State() State {
+	return State(atomic.LoadInt32(&b.state))
+}
+
+// Allow check the request whether can be sent, the request allowed must be marked by Mark.
+func (b *Breaker) Allow() bool {
+	if b.State() == StateClosed {
+		return true
+	}
+	b.lock.Lock()
+	now := b.now()
+	from, allow := b.State(), false
+	switch from {
+	case StateClosed:
+		allow = true
+	case StateOpen:
+		if now.Sub(b.start) >= b.cfg.OpenTimeout {
+			b.setState(StateHalfOpen, now)
+			b.probeAt = now
+			allow = true
+		}
+	case StateHalfOpen:
+		// NOTE: the probe lost like the conn closed, allows another.
+		if b.probeAt.IsZero() || now.Sub(b.probeAt) >= b.cfg.OpenTimeout {
+			b.probeAt = now
+			allow = true
+		}
+	}
+	to := b.State()
+	b.lock.Unlock()
+	b.changed(from, to)
+	return allow
+}
+
+// Mark record the result and the latency of request.
+func (b *Breaker) Mark(err error, latency time.Duration) {
+	failed := err != nil || (b.cfg.Latency > 0 && latency > b.cfg.Latency)
+	b.lock.Lock()
+	now := b.now()
+	from := b.State()
+	switch from {
+	case StateClosed:
+		if now.Sub(b.start) >= b.cfg.Window {
+			b.start, b.total, b.fails = now, 0, 0
+		}
+		b.total++
+		if failed {
+			b.fails++
+		}
+		if b.total >= b.cfg.MinRequests && b.fails*100 >= b.cfg.ErrorRate*b.total {
+			b.setState(StateOpen, now)
+		}
+	case StateHalfOpen:
+		if failed {
+			b.setState(StateOpen, now)
+		} else {
+			b.setState(StateClosed, now)
+		}
+	}
+	to := b.State()
+	b.lock.Unlock()
+	b.changed(from, to)
+}
+
+// setState must be called with lock held, the counts and probe are reset.
+func (b *Breaker) setState(s State, now time.Time) {
+	atomic.StoreInt32(&b.state, int32(s))
+	b.start, b.total, b.fails = now, 0, 0
+	b.probeAt = time.Time{}
+}
+
+func (b *Breaker) changed(from, to State) {
+	if from != to && b.onChange != nil {
+		b.onChange(from, to)
+	}
+}
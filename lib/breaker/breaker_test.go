@@ -0,0 +1,74 @@
+package breaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errMock = errors.New("mock error")
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func newTestBreaker() (*Breaker, *fakeClock, *[]State) {
+	c := &fakeClock{t: time.Now()}
+	var changes []State
+	cfg := &Config{ErrorRate: 50, Latency: 100 * time.Millisecond, MinRequests: 4, Window: time.Second, OpenTimeout: time.Second}
+	b := newBreaker(cfg, func(from, to State) { changes = append(changes, to) }, c.now)
+	return b, c, &changes
+}
+
+func TestBreakerOpenByErrorRate(t *testing.T) {
+	b, c, changes := newTestBreaker()
+	b.Mark(errMock, 0)
+	b.Mark(errMock, 0)
+	b.Mark(nil, time.Millisecond)
+	assert.Equal(t, StateClosed, b.State(), "not enough requests")
+	b.Mark(nil, time.Second) // NOTE: slow is failed
+	assert.Equal(t, StateOpen, b.State())
+	assert.False(t, b.Allow())
+
+	// NOTE: the window expired and counts reset.
+	b, c, _ = newTestBreaker()
+	b.Mark(errMock, 0)
+	b.Mark(errMock, 0)
+	c.t = c.t.Add(time.Second)
+	b.Mark(nil, 0)
+	b.Mark(nil, 0)
+	b.Mark(errMock, 0)
+	b.Mark(nil, 0)
+	assert.Equal(t, StateClosed, b.State())
+	assert.Equal(t, []State{StateOpen}, *changes)
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	b, c, changes := newTestBreaker()
+	for i := 0; i < 4; i++ {
+		b.Mark(errMock, 0)
+	}
+	assert.False(t, b.Allow())
+	c.t = c.t.Add(time.Second)
+	assert.True(t, b.Allow(), "probe after open timeout")
+	assert.Equal(t, StateHalfOpen, b.State())
+	assert.False(t, b.Allow(), "only one probe at a time")
+	b.Mark(errMock, 0)
+	assert.Equal(t, StateOpen, b.State(), "probe failed and open again")
+	assert.False(t, b.Allow())
+
+	c.t = c.t.Add(time.Second)
+	assert.True(t, b.Allow())
+	c.t = c.t.Add(time.Second)
+	assert.True(t, b.Allow(), "probe lost and allows another")
+	b.Mark(nil, time.Millisecond)
+	assert.Equal(t, StateClosed, b.State())
+	assert.True(t, b.Allow())
+	assert.Equal(t, []State{StateOpen, StateHalfOpen, StateOpen, StateHalfOpen, StateClosed}, *changes)
+}
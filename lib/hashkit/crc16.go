@@ -46,3 +46,13 @@ func Crc16(key []byte) (crc uint16) {
 	}
 	return
 }
+
+// hashCrc16 is the hash_crc16 of twemproxy, the crc is kept in uint32 and never masked into 16 bits,
+// so it differs from Crc16 of redis cluster slots.
+func hashCrc16(key []byte) uint {
+	var crc uint32
+	for _, c := range key {
+		crc = (crc << 8) ^ uint32(crc16tab[byte(crc>>8)^c])
+	}
+	return uint(crc)
+}
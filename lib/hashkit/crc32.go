@@ -0,0 +1,15 @@
+package hashkit
+
+import (
+	"hash/crc32"
+)
+
+// hashCrc32 is the crc32 of twemproxy which only keep 15 bits same as libmemcached.
+func hashCrc32(key []byte) uint {
+	return uint(crc32.ChecksumIEEE(key)>>16) & 0x7fff
+}
+
+// hashCrc32a is the complete crc32 IEEE checksum.
+func hashCrc32a(key []byte) uint {
+	return uint(crc32.ChecksumIEEE(key))
+}
@@ -10,11 +10,52 @@ func fnv1a64(key []byte) (value uint) {
 	return uint(s.Sum64())
 }
 
+// NOTE: twemproxy cast the signed char into uint32/uint64, so the byte must be sign extended,
+// but fnv1a64 of overlord never did, which is kept for the placement of existing deployments.
+
+func fnv1a64Twemproxy(key []byte) uint {
+	hash := uint32(offset64 & 0xffffffff)
+	for _, c := range key {
+		hash ^= uint32(int8(c))
+		hash *= uint32(prime64 & 0x0000ffff)
+	}
+	return uint(hash)
+}
+
+func fnv164(key []byte) uint {
+	var hash uint64 = offset64
+	for _, c := range key {
+		hash *= prime64
+		hash ^= uint64(int8(c))
+	}
+	return uint(uint32(hash))
+}
+
+func fnv132(key []byte) uint {
+	var hash uint32 = offset32
+	for _, c := range key {
+		hash *= prime32
+		hash ^= uint32(int8(c))
+	}
+	return uint(hash)
+}
+
+func fnv1a32(key []byte) uint {
+	var hash uint32 = offset32
+	for _, c := range key {
+		hash ^= uint32(int8(c))
+		hash *= prime32
+	}
+	return uint(hash)
+}
+
 type (
 	sum64a uint64
 )
 
 const (
+	prime32  = 16777619
+	offset32 = 2166136261
 	prime64  = 1099511628211
 	offset64 = 14695981039346656037
 )
@@ -1,18 +1,108 @@
 package hashkit
 
-// constants defines
+import (
+	"errors"
+)
+
+// hash methods same as twemproxy.
 const (
-	HashMethodFnv1a = "fnv1a_64"
+	HashMethodOneAtATime = "one_at_a_time"
+	HashMethodMD5        = "md5"
+	HashMethodCrc16      = "crc16"
+	HashMethodCrc32      = "crc32"
+	HashMethodCrc32a     = "crc32a"
+	HashMethodFnv164     = "fnv1_64"
+	HashMethodFnv1a      = "fnv1a_64"
+	HashMethodFnv132     = "fnv1_32"
+	HashMethodFnv1a32    = "fnv1a_32"
+	HashMethodHsieh      = "hsieh"
+	HashMethodMurmur     = "murmur"
+	HashMethodJenkins    = "jenkins"
+)
+
+// hash distributions same as twemproxy.
+const (
+	DistributionKetama = "ketama"
+	DistributionModula = "modula"
+	DistributionRandom = "random"
+)
+
+// errors
+var (
+	ErrUnknownHashMethod   = errors.New("unknown hash method")
+	ErrUnknownDistribution = errors.New("unknown hash distribution")
 )
 
+var (
+	hashMethods = map[string]func([]byte) uint{
+		HashMethodOneAtATime: hashOneAtATime,
+		HashMethodMD5:        hashMD5,
+		HashMethodCrc16:      hashCrc16,
+		HashMethodCrc32:      hashCrc32,
+		HashMethodCrc32a:     hashCrc32a,
+		HashMethodFnv164:     fnv164,
+		HashMethodFnv1a:      fnv1a64Twemproxy,
+		HashMethodFnv132:     fnv132,
+		HashMethodFnv1a32:    fnv1a32,
+		HashMethodHsieh:      hashHsieh,
+		HashMethodMurmur:     hashMurmur,
+		HashMethodJenkins:    hashJenkins,
+	}
+	distributions = map[string]struct{}{
+		DistributionKetama: struct{}{},
+		DistributionModula: struct{}{},
+		DistributionRandom: struct{}{},
+	}
+)
+
+// CheckHashMethod check the hash method whether supported, empty means the default fnv1a_64.
+func CheckHashMethod(method string) error {
+	if method == "" {
+		return nil
+	}
+	if _, ok := hashMethods[method]; !ok {
+		return ErrUnknownHashMethod
+	}
+	return nil
+}
+
+// CheckDistribution check the hash distribution whether supported, empty means the default ketama.
+func CheckDistribution(des string) error {
+	if des == "" {
+		return nil
+	}
+	if _, ok := distributions[des]; !ok {
+		return ErrUnknownDistribution
+	}
+	return nil
+}
+
 // NewRing will create new and need init method.
+// Unknown distribution or method fall back to ketama and fnv1a_64.
+// NOTE: the keys are placed as overlord before 1.6.0, fnv1a_64 never sign extends the bytes and ketama
+// continuum differs from twemproxy, use NewTwemproxyRing for the same placement as twemproxy.
 func NewRing(des, method string) *HashRing {
-	var hash func([]byte) uint
-	switch method {
-	case HashMethodFnv1a:
-		fallthrough
-	default:
-		hash = fnv1a64
-	}
-	return newRingWithHash(hash)
+	return newRingByName(des, method, false)
+}
+
+// NewTwemproxyRing will create new ring places keys the same as twemproxy, which moves the keys of
+// the ring created by NewRing with fnv1a_64 or ketama.
+func NewTwemproxyRing(des, method string) *HashRing {
+	return newRingByName(des, method, true)
+}
+
+func newRingByName(des, method string, twemproxy bool) *HashRing {
+	hash, ok := hashMethods[method]
+	if !ok || method == HashMethodFnv1a {
+		hash = fnv1a64Twemproxy
+		if !twemproxy {
+			hash = fnv1a64
+		}
+	}
+	if _, ok = distributions[des]; !ok {
+		des = DistributionKetama
+	}
+	h := newRing(des, hash)
+	h.twemproxy = twemproxy
+	return h
 }
@@ -9,7 +9,64 @@ import (
 func TestNewRingOk(t *testing.T) {
 	ring := NewRing("redis_cluster", "crc16")
 	assert.NotNil(t, ring)
+	assert.Equal(t, DistributionKetama, ring.dist)
 
 	ring = NewRing("ketama", "fnv1a_64")
 	assert.NotNil(t, ring)
+
+	ring = NewRing("modula", "murmur")
+	assert.Equal(t, DistributionModula, ring.dist)
+
+	// NOTE: the unknown method like sha1 is the legacy alias of fnv1a_64.
+	ring = NewRing("ketama", "sha1")
+	assert.Equal(t, fnv1a64([]byte("overlord")), ring.hash([]byte("overlord")))
+}
+
+func TestCheckHashMethodAndDistribution(t *testing.T) {
+	for method := range hashMethods {
+		assert.NoError(t, CheckHashMethod(method))
+	}
+	assert.NoError(t, CheckHashMethod(""))
+	assert.Equal(t, ErrUnknownHashMethod, CheckHashMethod("sha1"))
+
+	for _, des := range []string{"", "ketama", "modula", "random"} {
+		assert.NoError(t, CheckDistribution(des))
+	}
+	assert.Equal(t, ErrUnknownDistribution, CheckDistribution("redis_cluster"))
+}
+
+// vectors are generated by the C functions of twemproxy(nc_hashkit) built by gcc on x86_64,
+// and md5 by the md5_signature of the same bytes order.
+var (
+	hashKeys = []string{"", "a", "foo", "123456789", "overlord", "hello world!", "Four score and seven years ago", "\xe4\xb8\xad\xe6\x96\x87\xff"}
+	hashVecs = map[string][]uint{
+		"one_at_a_time": {0x00000000, 0xca2e9442, 0x238678dd, 0xc66b58c5, 0xd46530d8, 0x9bd919d3, 0x5554a59f, 0xab0386c2},
+		"md5":           {0xd98c1dd4, 0xb975c10c, 0xdb18bdac, 0x94e7f925, 0x80f04692, 0x8ef93ffc, 0x8482c88b, 0x4c22b0bd},
+		"crc16":         {0x00000000, 0x00007c87, 0x0c3caf96, 0x869031c3, 0xcacfd781, 0x063b577b, 0xbe60a5ef, 0x6033d1c0},
+		"crc32":         {0x00000000, 0x000068b7, 0x00000c73, 0x00004bf4, 0x00005dd7, 0x000003b4, 0x00003cfe, 0x000047e7},
+		"crc32a":        {0x00000000, 0xe8b7be43, 0x8c736521, 0xcbf43926, 0x5dd7dfba, 0x03b4c26d, 0x3cfe93b8, 0x47e7ace2},
+		"fnv1_64":       {0x84222325, 0x8601b7be, 0x6ba13533, 0x2bf916d6, 0x0b28105e, 0xb97b86bc, 0xce2804c6, 0xbb707bb4},
+		"fnv1a_64":      {0x84222325, 0x8601ec8c, 0xfed9d577, 0x23c6cdfc, 0xc594c5dc, 0xcd5a2672, 0x1987984c, 0xab9bdd8e},
+		"fnv1_32":       {0x811c9dc5, 0x050c5d7e, 0x408f5e13, 0x24148816, 0x7ec2b07e, 0x8a01b99c, 0xadc7c266, 0x7a7be994},
+		"fnv1a_32":      {0x811c9dc5, 0xe40c292c, 0xa9f37ed7, 0xbb86b11c, 0xa82bbf5c, 0xb034fff2, 0xdc02398c, 0xb4bd4bce},
+		"hsieh":         {0x00000000, 0x93642e87, 0x76d4d427, 0xe4fc1670, 0xb9fcc3ad, 0x13724f63, 0x0c5fc188, 0x61e5ee9c},
+		"murmur":        {0x00000000, 0x4b41757c, 0xc4e0338f, 0xb7760690, 0xa9a081fb, 0x7f8cfcad, 0x666caeaf, 0xcb77d775},
+		"jenkins":       {0xdeadbefc, 0xe0a38690, 0x99f84f99, 0x19777af6, 0x9f2f34b1, 0x1bc66602, 0x1ab867b2, 0xc27b13d0},
+	}
+)
+
+func TestHashMethodsVectors(t *testing.T) {
+	assert.Len(t, hashVecs, len(hashMethods))
+	for method, vecs := range hashVecs {
+		hash, ok := hashMethods[method]
+		assert.True(t, ok, method)
+		for i, key := range hashKeys {
+			assert.Equal(t, vecs[i], hash([]byte(key)), "method:%s key:%q", method, key)
+		}
+	}
+}
+
+func TestHashlittleOk(t *testing.T) {
+	assert.Equal(t, uint32(0xdeadbeef), hashlittle([]byte(""), 0))
+	assert.Equal(t, uint32(0x17770551), hashlittle([]byte("Four score and seven years ago"), 0))
 }
@@ -0,0 +1,47 @@
+package hashkit
+
+// hashHsieh is Paul Hsieh's SuperFastHash.
+func hashHsieh(key []byte) uint {
+	var (
+		hash, tmp uint32
+		l         = len(key)
+	)
+	if l == 0 {
+		return 0
+	}
+	rem := l & 3
+	for l >>= 2; l > 0; l-- {
+		hash += get16bits(key)
+		tmp = get16bits(key[2:])<<11 ^ hash
+		hash = hash<<16 ^ tmp
+		key = key[4:]
+		hash += hash >> 11
+	}
+	switch rem {
+	case 3:
+		hash += get16bits(key)
+		hash ^= hash << 16
+		hash ^= uint32(int8(key[2])) << 18 // NOTE: signed char same as twemproxy
+		hash += hash >> 11
+	case 2:
+		hash += get16bits(key)
+		hash ^= hash << 11
+		hash += hash >> 17
+	case 1:
+		hash += uint32(key[0])
+		hash ^= hash << 10
+		hash += hash >> 1
+	}
+	// force "avalanching" of final 127 bits
+	hash ^= hash << 3
+	hash += hash >> 5
+	hash ^= hash << 4
+	hash += hash >> 17
+	hash ^= hash << 25
+	hash += hash >> 6
+	return uint(hash)
+}
+
+func get16bits(bs []byte) uint32 {
+	return uint32(bs[1])<<8 | uint32(bs[0])
+}
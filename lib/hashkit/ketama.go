@@ -3,6 +3,8 @@ package hashkit
 import (
 	"crypto/md5"
 	"fmt"
+	"math"
+	"math/rand"
 	"sort"
 	"sync"
 	"sync/atomic"
@@ -11,8 +13,9 @@ import (
 )
 
 const (
-	_pointsPerServer = 160
-	_maxHostLen      = 64
+	_pointsPerServer     = 160
+	_maxHostLen          = 64
+	_twemproxyMaxHostLen = 85 // NOTE: same as twemproxy KETAMA_MAX_HOSTLEN without the tail '\0'
 )
 
 type nodeHash struct {
@@ -30,27 +33,29 @@ func (p *tickArray) Less(i, j int) bool { return p.nodes[i].hash < p.nodes[j].ha
 func (p *tickArray) Swap(i, j int)      { p.nodes[i], p.nodes[j] = p.nodes[j], p.nodes[i] }
 func (p *tickArray) Sort()              { sort.Sort(p) }
 
-// HashRing ketama hash ring.
+// HashRing hash ring with ketama, modula or random distribution.
 type HashRing struct {
 	nodes []string
 	spots []int
 	ticks atomic.Value
 	lock  sync.Mutex
 	hash  func([]byte) uint
+	dist  string
+	// twemproxy means the ketama continuum is of the float precision and host length of twemproxy.
+	twemproxy bool
 }
 
 // Ketama new a hash ring with ketama consistency.
 // Default hash: fnv1a64
 func Ketama() (h *HashRing) {
-	h = new(HashRing)
-	h.hash = fnv1a64
-	return
+	return newRing(DistributionKetama, fnv1a64)
 }
 
-// newRingWithHash new a hash ring with a hash func.
-func newRingWithHash(hash func([]byte) uint) (h *HashRing) {
-	h = Ketama()
+// newRing new a hash ring with a distribution and a hash func.
+func newRing(dist string, hash func([]byte) uint) (h *HashRing) {
+	h = new(HashRing)
 	h.hash = hash
+	h.dist = dist
 	return
 }
 
@@ -63,24 +68,53 @@ func (h *HashRing) Init(nodes []string, spots []int) {
 	h.nodes = nodes
 	h.spots = spots
 	h.lock.Unlock()
+	var ticks []nodeHash
+	switch h.dist {
+	case DistributionModula:
+		// NOTE: every node take weight continuous ticks by the config order.
+		for idx, node := range nodes {
+			for i := 0; i < spots[idx]; i++ {
+				ticks = append(ticks, nodeHash{node: node})
+			}
+		}
+	case DistributionRandom:
+		for _, node := range nodes {
+			ticks = append(ticks, nodeHash{node: node})
+		}
+	default:
+		ticks = h.ketamaTicks(nodes, spots)
+	}
+	h.ticks.Store(&tickArray{nodes: ticks, length: len(ticks)})
+}
+
+func (h *HashRing) ketamaTicks(nodes []string, spots []int) []nodeHash {
 	var (
 		ticks          []nodeHash
 		svrn           = len(nodes)
 		totalw         int
-		pointerCnt     int
 		pointerPerSvr  int
 		pointerPerHash = 4
 	)
 	for _, sp := range spots {
 		totalw += sp
 	}
+	maxHostLen := _maxHostLen
+	if h.twemproxy {
+		maxHostLen = _twemproxyMaxHostLen
+	}
 	for idx, node := range nodes {
-		pct := float64(spots[idx]) / float64(totalw)
-		pointerPerSvr = int((pct*_pointsPerServer/4*float64(svrn) + 0.0000000001) * 4)
+		if h.twemproxy {
+			// NOTE: same float precision as twemproxy
+			pct := float32(spots[idx]) / float32(totalw)
+			pointerPerSvr = int(math.Floor(float64(pct*_pointsPerServer/4*float32(svrn))+0.0000000001)) * 4
+		} else {
+			pct := float64(spots[idx]) / float64(totalw)
+			pointerPerSvr = int((pct*_pointsPerServer/4*float64(svrn) + 0.0000000001) * 4)
+		}
 		for pidx := 1; pidx <= pointerPerSvr/pointerPerHash; pidx++ {
 			host := fmt.Sprintf("%s-%d", node, pidx-1)
-			if len(host) > _maxHostLen {
-				host = host[:_maxHostLen]
+			if len(host) > maxHostLen {
+				host = host[:maxHostLen]
 			}
 			for x := 0; x < pointerPerHash; x++ {
 				value := h.ketamaHash(host, len(host), x)
@@ -91,11 +125,10 @@ func (h *HashRing) Init(nodes []string, spots []int) {
 				ticks = append(ticks, *n)
 			}
 		}
-		pointerCnt += pointerPerSvr
 	}
 	ts := &tickArray{nodes: ticks, length: len(ticks)}
 	ts.Sort()
-	h.ticks.Store(ts)
+	return ts.nodes
 }
 
 func (h *HashRing) ketamaHash(key string, kl, alignment int) (v uint) {
@@ -161,6 +194,15 @@ func (h *HashRing) DelNode(n string) {
 	}
 }
 
+// Nodes returns the nodes and spots of the hash ring.
+func (h *HashRing) Nodes() (nodes []string, spots []int) {
+	h.lock.Lock()
+	nodes = append(nodes, h.nodes...)
+	spots = append(spots, h.spots...)
+	h.lock.Unlock()
+	return
+}
+
 // GetNode returns result node by given key.
 func (h *HashRing) GetNode(key []byte) (string, bool) {
 	ts, ok := h.ticks.Load().(*tickArray)
@@ -168,7 +210,12 @@ func (h *HashRing) GetNode(key []byte) (string, bool) {
 		return "", false
 	}
 	value := h.hash(key)
-
+	switch h.dist {
+	case DistributionModula:
+		return ts.nodes[value%uint(ts.length)].node, true
+	case DistributionRandom:
+		return ts.nodes[rand.Intn(ts.length)].node, true
+	}
 	i := sort.Search(ts.length, func(i int) bool { return ts.nodes[i].hash >= value })
 	if i == ts.length {
 		i = 0
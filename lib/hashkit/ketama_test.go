@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"strconv"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 var (
@@ -73,6 +75,66 @@ func testHash(t *testing.T) {
 	t.Log(node5, m[node5])
 }
 
+// placements are generated by the continuum of twemproxy(nc_ketama, nc_modula) on x86_64.
+var (
+	placeNodes = []string{"127.0.0.1", "127.0.0.1:11212", "mc3", "10.0.0.4:6379"}
+	placeSpots = []int{1, 1, 2, 3}
+	placeVecs  = []struct {
+		des, method string
+		idxs        []int
+	}{
+		{des: "ketama", method: "fnv1a_64", idxs: []int{3, 0, 2, 0, 2, 3, 3, 3, 0, 1, 2, 0, 2, 3, 3, 2}},
+		{des: "ketama", method: "murmur", idxs: []int{3, 3, 1, 3, 3, 3, 1, 3, 3, 3, 1, 3, 2, 2, 3, 2}},
+		{des: "modula", method: "murmur", idxs: []int{3, 1, 2, 3, 0, 1, 3, 2, 3, 3, 3, 3, 1, 2, 3, 3}},
+	}
+)
+
+func TestPlacementSameAsTwemproxy(t *testing.T) {
+	for _, pv := range placeVecs {
+		r := NewTwemproxyRing(pv.des, pv.method)
+		r.Init(placeNodes, placeSpots)
+		for i, idx := range pv.idxs {
+			key := "k" + strconv.FormatUint(uint64(uint32(i*2654435761)), 10)
+			node, ok := r.GetNode([]byte(key))
+			assert.True(t, ok)
+			assert.Equal(t, placeNodes[idx], node, "%s %s key:%s", pv.des, pv.method, key)
+		}
+	}
+}
+
+// placements are generated by the ring of overlord 1.5, the keys and node names with bytes >= 0x80 and
+// the weights are of the differences from twemproxy.
+func TestPlacementSameAsBefore(t *testing.T) {
+	nodes := []string{"127.0.0.1:11211", "127.0.0.1:11212", "mc3", "10.0.0.4:6379", "\xe4\xb8\xad.example.com:11211"}
+	idxs := []int{3, 3, 2, 0, 1, 0, 3, 0, 3, 3, 0, 3, 4, 3, 4, 3}
+	r := NewRing("ketama", "fnv1a_64")
+	r.Init(nodes, []int{1, 1, 2, 3, 1})
+	for i, idx := range idxs {
+		key := "k" + strconv.FormatUint(uint64(uint32(i*2654435761)), 10)
+		if i%2 == 1 {
+			key += "\xff\xe4"
+		}
+		node, ok := r.GetNode([]byte(key))
+		assert.True(t, ok)
+		assert.Equal(t, nodes[idx], node, "key:%q", key)
+	}
+}
+
+func TestRandomDistribution(t *testing.T) {
+	r := NewRing("random", "fnv1a_64")
+	_, ok := r.GetNode([]byte("a"))
+	assert.False(t, ok)
+
+	r.Init(placeNodes, placeSpots)
+	m := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		node, ok := r.GetNode([]byte("a"))
+		assert.True(t, ok)
+		m[node]++
+	}
+	assert.Len(t, m, len(placeNodes))
+}
+
 func BenchmarkHash(b *testing.B) {
 	ring.Init(nodes, sis)
 	for i := 0; i < b.N; i++ {
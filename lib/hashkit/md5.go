@@ -0,0 +1,10 @@
+package hashkit
+
+import (
+	"crypto/md5"
+)
+
+func hashMD5(key []byte) uint {
+	bs := md5.Sum(key)
+	return uint(bs[3])<<24 | uint(bs[2])<<16 | uint(bs[1])<<8 | uint(bs[0])
+}
@@ -0,0 +1,41 @@
+package hashkit
+
+import (
+	"encoding/binary"
+)
+
+// hashMurmur is MurmurHash2 with the seed of twemproxy.
+func hashMurmur(key []byte) uint {
+	const (
+		m = 0x5bd1e995
+		r = 24
+	)
+	var (
+		l    = uint32(len(key))
+		seed = 0xdeadbeef * l
+		h    = seed ^ l
+	)
+	for ; len(key) >= 4; key = key[4:] {
+		k := binary.LittleEndian.Uint32(key)
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+	switch len(key) {
+	case 3:
+		h ^= uint32(key[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(key[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(key[0])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return uint(h)
+}
@@ -0,0 +1,15 @@
+package hashkit
+
+// hashOneAtATime is Bob Jenkins' one-at-a-time hash.
+func hashOneAtATime(key []byte) uint {
+	var value uint32
+	for _, c := range key {
+		value += uint32(int8(c)) // NOTE: signed char same as twemproxy
+		value += value << 10
+		value ^= value >> 6
+	}
+	value += value << 3
+	value ^= value >> 11
+	value += value << 15
+	return uint(value)
+}
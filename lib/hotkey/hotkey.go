@@ -0,0 +1,236 @@
+package hotkey
+
+import (
+	"container/list"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	shardNum    = 16
+	sketchDepth = 4
+	sketchWidth = 1 << 12
+	// entryOverhead is the approximate bytes of entry besides the key and value.
+	entryOverhead = 64
+
+	offset64 = 14695981039346656037
+	prime64  = 1099511628211
+)
+
+// HotKey is the key detected hot and the access count in the window.
+type HotKey struct {
+	Key   string
+	Count uint32
+}
+
+// Cache caches the values of hot keys in memory with ttl and memory budget, the least recently used are evicted.
+// The hot keys are detected by the count-min sketch of access frequency in the window, see Rotate.
+type Cache struct {
+	threshold uint32
+	ttl       time.Duration
+	maxHot    int
+
+	sketch [sketchDepth][sketchWidth]uint32
+	shards [shardNum]*shard
+
+	lock sync.RWMutex
+	hots map[string]struct{} // NOTE: the keys became hot in the window
+}
+
+type shard struct {
+	lock     sync.Mutex
+	items    map[string]*list.Element
+	lru      *list.List
+	bytes    int
+	maxBytes int
+}
+
+type entry struct {
+	key    string
+	value  []byte
+	expire time.Time
+}
+
+// New new a cache, the key is hot when accessed threshold times in the window,
+// maxBytes is the memory budget of values and maxHot is the max hot keys reported by Rotate.
+func New(threshold int, ttl time.Duration, maxBytes, maxHot int) *Cache {
+	c := &Cache{
+		threshold: uint32(threshold),
+		ttl:       ttl,
+		maxHot:    maxHot,
+		hots:      make(map[string]struct{}),
+	}
+	for i := range c.shards {
+		c.shards[i] = &shard{
+			items:    make(map[string]*list.Element),
+			lru:      list.New(),
+			maxBytes: maxBytes / shardNum,
+		}
+	}
+	return c
+}
+
+// Incr record an access of key and returns whether the key is hot.
+func (c *Cache) Incr(key []byte) bool {
+	h1, h2 := hash(key)
+	min := ^uint32(0)
+	for i := 0; i < sketchDepth; i++ {
+		idx := (h1 + uint32(i)*h2) % sketchWidth
+		if n := atomic.AddUint32(&c.sketch[i][idx], 1); n < min {
+			min = n
+		}
+	}
+	if min < c.threshold {
+		return false
+	}
+	c.lock.RLock()
+	_, ok := c.hots[string(key)]
+	c.lock.RUnlock()
+	if !ok {
+		c.lock.Lock()
+		if len(c.hots) < c.maxHot*4 {
+			c.hots[string(key)] = struct{}{}
+		}
+		c.lock.Unlock()
+	}
+	return true
+}
+
+// IsHot check the key whether hot without access.
+func (c *Cache) IsHot(key []byte) bool {
+	return c.count(key) >= c.threshold
+}
+
+func (c *Cache) count(key []byte) uint32 {
+	h1, h2 := hash(key)
+	min := ^uint32(0)
+	for i := 0; i < sketchDepth; i++ {
+		idx := (h1 + uint32(i)*h2) % sketchWidth
+		if n := atomic.LoadUint32(&c.sketch[i][idx]); n < min {
+			min = n
+		}
+	}
+	return min
+}
+
+// Rotate ends the window and the access counts are reset,
+// returns the hot keys in the ended window order by count.
+func (c *Cache) Rotate() []HotKey {
+	c.lock.Lock()
+	hots := c.hots
+	c.hots = make(map[string]struct{})
+	c.lock.Unlock()
+	keys := make([]HotKey, 0, len(hots))
+	for k := range hots {
+		keys = append(keys, HotKey{Key: k, Count: c.count([]byte(k))})
+	}
+	for i := range c.sketch {
+		for j := range c.sketch[i] {
+			atomic.StoreUint32(&c.sketch[i][j], 0)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Count != keys[j].Count {
+			return keys[i].Count > keys[j].Count
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	if len(keys) > c.maxHot {
+		keys = keys[:c.maxHot]
+	}
+	return keys
+}
+
+// Get returns the value of key cached and not expired.
+// NOTE: the value is shared and must not be modified.
+func (c *Cache) Get(key []byte) ([]byte, bool) {
+	s := c.shard(key)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	elem, ok := s.items[string(key)]
+	if !ok {
+		return nil, false
+	}
+	e := elem.Value.(*entry)
+	if time.Now().After(e.expire) {
+		s.remove(elem)
+		return nil, false
+	}
+	s.lru.MoveToFront(elem)
+	return e.value, true
+}
+
+// Set cache the copy of value with ttl, the least recently used are evicted when out of memory budget.
+func (c *Cache) Set(key, value []byte) {
+	size := len(key) + len(value) + entryOverhead
+	s := c.shard(key)
+	if size > s.maxBytes {
+		return
+	}
+	e := &entry{key: string(key), value: append([]byte(nil), value...), expire: time.Now().Add(c.ttl)}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if elem, ok := s.items[e.key]; ok {
+		s.remove(elem)
+	}
+	for s.bytes+size > s.maxBytes {
+		s.remove(s.lru.Back())
+	}
+	s.items[e.key] = s.lru.PushFront(e)
+	s.bytes += size
+}
+
+// Del remove the key from cache.
+func (c *Cache) Del(key []byte) {
+	s := c.shard(key)
+	s.lock.Lock()
+	if elem, ok := s.items[string(key)]; ok {
+		s.remove(elem)
+	}
+	s.lock.Unlock()
+}
+
+// Purge remove all the keys from cache, like the data flushed.
+func (c *Cache) Purge() {
+	for _, s := range c.shards {
+		s.lock.Lock()
+		s.items = make(map[string]*list.Element)
+		s.lru.Init()
+		s.bytes = 0
+		s.lock.Unlock()
+	}
+}
+
+// Len returns the count of keys cached.
+func (c *Cache) Len() (n int) {
+	for _, s := range c.shards {
+		s.lock.Lock()
+		n += len(s.items)
+		s.lock.Unlock()
+	}
+	return
+}
+
+func (c *Cache) shard(key []byte) *shard {
+	h1, _ := hash(key)
+	return c.shards[h1%shardNum]
+}
+
+func (s *shard) remove(elem *list.Element) {
+	e := s.lru.Remove(elem).(*entry)
+	delete(s.items, e.key)
+	s.bytes -= len(e.key) + len(e.value) + entryOverhead
+}
+
+// hash returns two hashes of key by fnv1a_64 for the double hashing of sketch.
+func hash(key []byte) (h1, h2 uint32) {
+	var sum uint64 = offset64
+	for _, c := range key {
+		sum ^= uint64(c)
+		sum *= prime64
+	}
+	h1, h2 = uint32(sum), uint32(sum>>32)|1
+	return
+}
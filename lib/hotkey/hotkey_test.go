@@ -0,0 +1,72 @@
+package hotkey
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIncrAndRotate(t *testing.T) {
+	c := New(3, time.Second, 1024, 2)
+	for i := 0; i < 2; i++ {
+		assert.False(t, c.Incr([]byte("a")))
+	}
+	assert.False(t, c.IsHot([]byte("a")))
+	assert.True(t, c.Incr([]byte("a")))
+	assert.True(t, c.IsHot([]byte("a")))
+	for i := 0; i < 5; i++ {
+		c.Incr([]byte("b"))
+	}
+	for i := 0; i < 4; i++ {
+		c.Incr([]byte("c"))
+	}
+	c.Incr([]byte("d"))
+	assert.Equal(t, []HotKey{{Key: "b", Count: 5}, {Key: "c", Count: 4}}, c.Rotate())
+	assert.False(t, c.IsHot([]byte("b")))
+	assert.Len(t, c.Rotate(), 0)
+}
+
+func TestCacheGetSetDel(t *testing.T) {
+	c := New(1, 50*time.Millisecond, 1024*shardNum, 8)
+	_, ok := c.Get([]byte("a"))
+	assert.False(t, ok)
+	v := []byte("hello")
+	c.Set([]byte("a"), v)
+	v[0] = 'x'
+	got, ok := c.Get([]byte("a"))
+	assert.True(t, ok)
+	assert.Equal(t, "hello", string(got))
+	c.Del([]byte("a"))
+	_, ok = c.Get([]byte("a"))
+	assert.False(t, ok)
+
+	c.Set([]byte("a"), v)
+	time.Sleep(60 * time.Millisecond)
+	_, ok = c.Get([]byte("a"))
+	assert.False(t, ok, "expired")
+	assert.Equal(t, 0, c.Len())
+
+	c.Set([]byte("a"), v)
+	c.Set([]byte("b"), v)
+	c.Purge()
+	_, ok = c.Get([]byte("a"))
+	assert.False(t, ok, "purged")
+	assert.Equal(t, 0, c.Len())
+}
+
+func TestCacheEvict(t *testing.T) {
+	c := New(1, time.Minute, 200*shardNum, 8)
+	c.Set([]byte("big"), make([]byte, 200))
+	assert.Equal(t, 0, c.Len(), "larger than budget")
+	for i := 0; i < 100; i++ {
+		c.Set([]byte(fmt.Sprintf("k%d", i)), make([]byte, 50))
+	}
+	assert.True(t, c.Len() <= shardNum*2)
+	for _, s := range c.shards {
+		assert.True(t, s.bytes <= s.maxBytes)
+	}
+	_, ok := c.Get([]byte("k99"))
+	assert.True(t, ok, "the recently used kept")
+}
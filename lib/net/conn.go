@@ -21,6 +21,9 @@ type Conn struct {
 	readTimeout  time.Duration
 	writeTimeout time.Duration
 
+	readBytes  int64
+	writeBytes int64
+
 	closed bool
 }
 
@@ -52,6 +55,7 @@ func (c *Conn) Read(b []byte) (n int, err error) {
 		}
 	}
 	n, err = c.Conn.Read(b)
+	c.readBytes += int64(n)
 	return
 }
 
@@ -65,6 +69,14 @@ func (c *Conn) Write(b []byte) (n int, err error) {
 		}
 	}
 	n, err = c.Conn.Write(b)
+	c.writeBytes += int64(n)
+	return
+}
+
+// SetReadTimeout change the timeout of the following reads and returns the old, 0 means never timeout.
+// NOTE: not safe for concurrent use as Read.
+func (c *Conn) SetReadTimeout(timeout time.Duration) (old time.Duration) {
+	old, c.readTimeout = c.readTimeout, timeout
 	return
 }
 
@@ -83,5 +95,12 @@ func (c *Conn) Writev(buf *net.Buffers) (int64, error) {
 		return 0, ErrConnClosed
 	}
 	n, err := buf.WriteTo(c.Conn)
+	c.writeBytes += n
 	return n, err
 }
+
+// Bytes returns the total bytes read and written.
+// NOTE: not safe for concurrent use as Read and Write.
+func (c *Conn) Bytes() (read, written int64) {
+	return c.readBytes, c.writeBytes
+}
@@ -68,6 +68,9 @@ func _testReadWriteWithMockTimeout(t *testing.T, rt, wt time.Duration) {
 	assert.NoError(t, err)
 	assert.Equal(t, len(data), size)
 
+	read, written := conn.Bytes()
+	assert.Equal(t, int64(len(data)), read)
+	assert.Equal(t, int64(len(data)), written)
 }
 
 func TestConnProxyMockReadWriteOk(t *testing.T) {
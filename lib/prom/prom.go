@@ -13,6 +13,17 @@ const (
 
 	statProxyTimer   = "overlord_proxy_timer"
 	statHandlerTimer = "overlord_proxy_handler_timer"
+
+	statHashMiss = "overlord_proxy_hash_miss"
+	statReconn   = "overlord_proxy_reconn"
+	statRedirect = "overlord_proxy_redirect"
+	statEject    = "overlord_proxy_eject"
+	statBackup   = "overlord_proxy_backup"
+	statTiered   = "overlord_proxy_tiered"
+	statHotKey   = "overlord_proxy_hotkey"
+	statHotCache = "overlord_proxy_hotkey_cache"
+	statLimited  = "overlord_proxy_limited"
+	statBreaker  = "overlord_proxy_breaker"
 )
 
 var (
@@ -20,12 +31,27 @@ var (
 	gerr         *prometheus.GaugeVec
 	proxyTimer   *prometheus.HistogramVec
 	handlerTimer *prometheus.HistogramVec
+	hashMiss     *prometheus.CounterVec
+	reconn       *prometheus.CounterVec
+	redirect     *prometheus.CounterVec
+	eject        *prometheus.CounterVec
+	backup       *prometheus.CounterVec
+	tiered       *prometheus.CounterVec
+	hotKey       *prometheus.GaugeVec
+	hotCache     *prometheus.CounterVec
+	limited      *prometheus.CounterVec
+	breaker      *prometheus.GaugeVec
+
+	// latencyBuckets in microseconds, from 100us to 1s.
+	latencyBuckets = []float64{100, 250, 500, 1000, 2500, 5000, 10000, 25000, 50000, 100000, 250000, 500000, 1000000}
 
-	clusterLabels        = []string{"cluster"}
-	clusterNodeLabels    = []string{"cluster", "node"}
-	clusterNodeErrLabels = []string{"cluster", "node", "cmd", "error"}
-	clusterCmdLabels     = []string{"cluster", "cmd"}
-	clusterNodeCmdLabels = []string{"cluster", "node", "cmd"}
+	clusterLabels         = []string{"cluster"}
+	clusterNodeLabels     = []string{"cluster", "node"}
+	clusterNodeErrLabels  = []string{"cluster", "node", "cmd", "error"}
+	clusterCmdLabels      = []string{"cluster", "cmd"}
+	clusterNodeCmdLabels  = []string{"cluster", "node", "cmd"}
+	clusterNodeTypeLabels = []string{"cluster", "node", "type"}
+	clusterTypeLabels     = []string{"cluster", "type"}
 	// On Prom switch
 	On = true
 )
@@ -47,17 +73,77 @@ func Init() {
 	proxyTimer = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Name:    statProxyTimer,
-			Help:    statProxyTimer,
-			Buckets: prometheus.LinearBuckets(0, 10, 1),
+			Help:    "proxy total duration in microseconds",
+			Buckets: latencyBuckets,
 		}, clusterCmdLabels)
 	prometheus.MustRegister(proxyTimer)
 	handlerTimer = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Name:    statHandlerTimer,
-			Help:    statHandlerTimer,
-			Buckets: prometheus.LinearBuckets(0, 10, 1),
+			Help:    "backend node duration in microseconds",
+			Buckets: latencyBuckets,
 		}, clusterNodeCmdLabels)
 	prometheus.MustRegister(handlerTimer)
+	hashMiss = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: statHashMiss,
+			Help: statHashMiss,
+		}, clusterLabels)
+	prometheus.MustRegister(hashMiss)
+	reconn = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: statReconn,
+			Help: statReconn,
+		}, clusterNodeLabels)
+	prometheus.MustRegister(reconn)
+	redirect = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: statRedirect,
+			Help: statRedirect,
+		}, clusterNodeTypeLabels)
+	prometheus.MustRegister(redirect)
+	eject = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: statEject,
+			Help: statEject,
+		}, clusterNodeLabels)
+	prometheus.MustRegister(eject)
+	backup = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: statBackup,
+			Help: statBackup,
+		}, clusterTypeLabels)
+	prometheus.MustRegister(backup)
+	tiered = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: statTiered,
+			Help: statTiered,
+		}, clusterTypeLabels)
+	prometheus.MustRegister(tiered)
+	hotKey = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: statHotKey,
+			Help: statHotKey,
+		}, []string{"cluster", "key"})
+	prometheus.MustRegister(hotKey)
+	hotCache = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: statHotCache,
+			Help: statHotCache,
+		}, clusterTypeLabels)
+	prometheus.MustRegister(hotCache)
+	limited = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: statLimited,
+			Help: statLimited,
+		}, clusterTypeLabels)
+	prometheus.MustRegister(limited)
+	breaker = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: statBreaker,
+			Help: "node circuit breaker state, 0 closed, 1 open and 2 half-open",
+		}, clusterNodeLabels)
+	prometheus.MustRegister(breaker)
 	// metrics
 	metrics()
 }
@@ -69,15 +155,15 @@ func metrics() {
 	})
 }
 
-// ProxyTime log timing information (in milliseconds).
-func ProxyTime(cluster, node string, ts int64) {
+// ProxyTime log timing information (in microseconds).
+func ProxyTime(cluster, cmd string, ts int64) {
 	if proxyTimer == nil {
 		return
 	}
-	proxyTimer.WithLabelValues(cluster, node).Observe(float64(ts))
+	proxyTimer.WithLabelValues(cluster, cmd).Observe(float64(ts))
 }
 
-// HandleTime log timing information (in milliseconds).
+// HandleTime log timing information (in microseconds).
 func HandleTime(cluster, node, cmd string, ts int64) {
 	if handlerTimer == nil {
 		return
@@ -108,3 +194,99 @@ func ConnDecr(cluster string) {
 	}
 	conns.WithLabelValues(cluster).Dec()
 }
+
+// HashMissIncr increments the counter of key hash no hit node.
+func HashMissIncr(cluster string) {
+	if hashMiss == nil {
+		return
+	}
+	hashMiss.WithLabelValues(cluster).Inc()
+}
+
+// ReconnIncr increments the counter of node reconnection.
+func ReconnIncr(cluster, node string) {
+	if reconn == nil {
+		return
+	}
+	reconn.WithLabelValues(cluster, node).Inc()
+}
+
+// RedirectIncr increments the counter of redis cluster redirection, typ is MOVED or ASK.
+func RedirectIncr(cluster, node, typ string) {
+	if redirect == nil {
+		return
+	}
+	redirect.WithLabelValues(cluster, node, typ).Inc()
+}
+
+// EjectIncr increments the counter of node ejection.
+func EjectIncr(cluster, node string) {
+	if eject == nil {
+		return
+	}
+	eject.WithLabelValues(cluster, node).Inc()
+}
+
+// BackupIncr increments the counter of backup cluster by type, like write, drop and fallback.
+func BackupIncr(cluster, tp string) {
+	if backup == nil {
+		return
+	}
+	backup.WithLabelValues(cluster, tp).Inc()
+}
+
+// TieredIncr increments the counter of L1&L2 cache by type, like hit, miss, populate and drop.
+func TieredIncr(cluster, tp string) {
+	if tiered == nil {
+		return
+	}
+	tiered.WithLabelValues(cluster, tp).Inc()
+}
+
+// HotKeySet set the reads per second of hot key.
+func HotKeySet(cluster, key string, count uint32) {
+	if hotKey == nil {
+		return
+	}
+	hotKey.WithLabelValues(cluster, key).Set(float64(count))
+}
+
+// HotKeyDelete delete the key not hot any more.
+func HotKeyDelete(cluster, key string) {
+	if hotKey == nil {
+		return
+	}
+	hotKey.DeleteLabelValues(cluster, key)
+}
+
+// HotCacheIncr increments the counter of hot key local cache by type, hit or miss.
+func HotCacheIncr(cluster, tp string) {
+	if hotCache == nil {
+		return
+	}
+	hotCache.WithLabelValues(cluster, tp).Inc()
+}
+
+// LimitedIncr increments the counter of requests rejected by rate limit, the type is the limit exceeded like ops and client_bytes.
+func LimitedIncr(cluster, tp string) {
+	if limited == nil {
+		return
+	}
+	limited.WithLabelValues(cluster, tp).Inc()
+}
+
+// BreakerState set the circuit breaker state of node.
+func BreakerState(cluster, node string, state int) {
+	if breaker == nil {
+		return
+	}
+	breaker.WithLabelValues(cluster, node).Set(float64(state))
+}
+
+// BreakerDelete delete the circuit breaker state of node removed.
+func BreakerDelete(cluster, node string) {
+	if breaker == nil {
+		return
+	}
+	breaker.DeleteLabelValues(cluster, node)
+}
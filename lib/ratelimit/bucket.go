@@ -0,0 +1,87 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Bucket is the token bucket which refills rate tokens per second and holds one second tokens at most.
+type Bucket struct {
+	lock   sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// NewBucket new a full bucket with rate tokens per second.
+func NewBucket(rate int) *Bucket {
+	return newBucket(rate, time.Now)
+}
+
+func newBucket(rate int, now func() time.Time) *Bucket {
+	return &Bucket{rate: float64(rate), tokens: float64(rate), last: now(), now: now}
+}
+
+// Allow take n tokens and returns true when enough, or nothing taken and returns false.
+func (b *Bucket) Allow(n int) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.refill()
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// AllowDebt take n tokens and returns true when enough or the bucket is full, or nothing taken and returns false.
+// NOTE: the cost more than rate is never enough by Allow, it is charged as the debt paid by the later refill.
+func (b *Bucket) AllowDebt(n int) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.refill()
+	if b.tokens < float64(n) && b.tokens < b.rate {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// Take take n tokens even if not enough, the debt is paid by the later refill.
+// NOTE: used when the cost is known after done, like the bytes of reply.
+func (b *Bucket) Take(n int) {
+	b.lock.Lock()
+	b.refill()
+	b.tokens -= float64(n)
+	b.lock.Unlock()
+}
+
+// Refund give back n tokens taken.
+func (b *Bucket) Refund(n int) {
+	b.lock.Lock()
+	b.tokens += float64(n)
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.lock.Unlock()
+}
+
+// Ready check the bucket whether any token left.
+func (b *Bucket) Ready() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.refill()
+	return b.tokens > 0
+}
+
+func (b *Bucket) refill() {
+	now := b.now()
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.rate {
+			b.tokens = b.rate
+		}
+	}
+	b.last = now
+}
@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func TestBucketAllow(t *testing.T) {
+	c := &fakeClock{t: time.Now()}
+	b := newBucket(10, c.now)
+	assert.True(t, b.Allow(6))
+	assert.False(t, b.Allow(5), "only 4 left")
+	assert.True(t, b.Allow(4))
+	assert.False(t, b.Allow(1))
+
+	c.t = c.t.Add(500 * time.Millisecond)
+	assert.True(t, b.Allow(5))
+	assert.False(t, b.Allow(1))
+
+	c.t = c.t.Add(time.Hour)
+	assert.False(t, b.Allow(11), "never more than one second tokens")
+	assert.True(t, b.Allow(10))
+	b.Refund(3)
+	assert.True(t, b.Allow(3))
+}
+
+func TestBucketTakeDebt(t *testing.T) {
+	c := &fakeClock{t: time.Now()}
+	b := newBucket(10, c.now)
+	b.Take(25)
+	assert.False(t, b.Ready())
+	c.t = c.t.Add(time.Second)
+	assert.False(t, b.Ready(), "still in debt")
+	c.t = c.t.Add(600 * time.Millisecond)
+	assert.True(t, b.Ready())
+	assert.False(t, b.Allow(2))
+	assert.True(t, b.Allow(1))
+}
+
+func TestBucketAllowDebt(t *testing.T) {
+	c := &fakeClock{t: time.Now()}
+	b := newBucket(10, c.now)
+	assert.True(t, b.AllowDebt(25), "more than rate is allowed when full")
+	assert.False(t, b.AllowDebt(1))
+	c.t = c.t.Add(time.Second)
+	assert.False(t, b.AllowDebt(1), "still in debt")
+	c.t = c.t.Add(600 * time.Millisecond)
+	assert.False(t, b.AllowDebt(2))
+	assert.True(t, b.AllowDebt(1))
+	c.t = c.t.Add(time.Hour)
+	assert.True(t, b.AllowDebt(6))
+	assert.False(t, b.AllowDebt(5), "only 4 left and not full")
+}
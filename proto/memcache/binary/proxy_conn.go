@@ -154,7 +154,9 @@ func (p *proxyConn) Encode(m *proto.Message) (err error) {
 		_ = p.bw.Write(mcr.keyLen)
 		_ = p.bw.Write(mcr.extraLen)
 		_ = p.bw.Write(zeroBytes)
-		if me := m.Err(); me != nil {
+		if me := m.Err(); errors.Cause(me) == proto.ErrRateLimited {
+			_ = p.bw.Write(responseStatusBusyBytes)
+		} else if me != nil {
 			_ = p.bw.Write(resopnseStatusInternalErrBytes)
 		} else {
 			_ = p.bw.Write(mcr.status)
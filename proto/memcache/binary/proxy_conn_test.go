@@ -372,4 +372,12 @@ func TestProxyConnEncodeErr(t *testing.T) {
 	buf := make([]byte, 1024)
 	c.wbuf.Read(buf)
 	assert.Equal(t, resopnseStatusInternalErrBytes, buf[6:8])
+
+	msg = proto.NewMessage()
+	msg.WithRequest(newReq())
+	msg.WithError(proto.ErrRateLimited)
+	assert.NoError(t, p.Encode(msg))
+	p.Flush()
+	c.wbuf.Read(buf)
+	assert.Equal(t, responseStatusBusyBytes, buf[6:8])
 }
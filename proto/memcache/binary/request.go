@@ -233,6 +233,7 @@ const (
 
 var (
 	resopnseStatusInternalErrBytes = []byte{0x00, 0x84}
+	responseStatusBusyBytes        = []byte{0x00, 0x85}
 )
 
 // errors
@@ -323,6 +324,15 @@ func (r *MCRequest) Key() []byte {
 	return r.key
 }
 
+// IsReadOnly check the request whether only reads the data, which can be served by replica.
+func (r *MCRequest) IsReadOnly() bool {
+	switch r.rTp {
+	case RequestTypeGet, RequestTypeGetQ, RequestTypeGetK, RequestTypeGetKQ:
+		return true
+	}
+	return false
+}
+
 func (r *MCRequest) String() string {
 	return fmt.Sprintf("type:%s key:%s data:%s", r.rTp.String(), r.key, r.data)
 }
@@ -55,6 +55,9 @@ func TestMCRequestFuncsOk(t *testing.T) {
 	assert.Equal(t, []byte{byte(RequestTypeGet)}, req.Cmd())
 	assert.Equal(t, "abc", string(req.Key()))
 	assert.Equal(t, "type:get key:abc data:\r\n", req.String())
+	assert.True(t, req.IsReadOnly())
+	req.rTp = RequestTypeSet
+	assert.False(t, req.IsReadOnly())
 
 	req.Put()
 
@@ -54,6 +54,10 @@ func (n *nodeConn) Write(m *proto.Message) (err error) {
 		return
 	}
 	_ = n.bw.Write(mcr.rTp.Bytes())
+	if mcr.IsBroadcast() {
+		err = n.bw.Write(mcr.data) // NOTE: the args with CRLF
+		return
+	}
 	_ = n.bw.Write(spaceBytes)
 	if mcr.rTp == RequestTypeGat || mcr.rTp == RequestTypeGats {
 		_ = n.bw.Write(mcr.data) // NOTE: exp time
@@ -96,6 +100,9 @@ REREAD:
 		err = errors.WithStack(err)
 		return
 	}
+	if mcr.rTp == RequestTypeStats {
+		return n.readStats(mcr, bs)
+	}
 	if _, ok := withValueTypes[mcr.rTp]; !ok || bytes.Equal(bs, endBytes) || bytes.Equal(bs, errorBytes) {
 		mcr.data = mcr.data[:0]
 		mcr.data = append(mcr.data, bs...)
@@ -125,6 +132,29 @@ REREADData:
 	return
 }
 
+// readStats read the lines of stats until END, or the only line like RESET and errors.
+func (n *nodeConn) readStats(mcr *MCRequest, bs []byte) (err error) {
+	mcr.data = mcr.data[:0]
+	mcr.data = append(mcr.data, bs...)
+	if !bytes.HasPrefix(bs, statBytes) && !bytes.HasPrefix(bs, itemBytes) {
+		return
+	}
+	for !bytes.Equal(bs, endBytes) {
+		if bs, err = n.br.ReadLine(); err == bufio.ErrBufferFull {
+			if err = n.br.Read(); err != nil {
+				err = errors.WithStack(err)
+				return
+			}
+			continue
+		} else if err != nil {
+			err = errors.WithStack(err)
+			return
+		}
+		mcr.data = append(mcr.data, bs...)
+	}
+	return
+}
+
 func (n *nodeConn) Close() error {
 	if atomic.CompareAndSwapInt32(&n.state, opened, closed) {
 		return n.conn.Close()
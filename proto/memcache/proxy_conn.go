@@ -19,7 +19,9 @@ const (
 )
 
 var (
+	clientErrorBytes = []byte(clientErrorPrefix)
 	serverErrorBytes = []byte(serverErrorPrefix)
+	statNodeBytes    = []byte("STAT node ")
 )
 
 type proxyConn struct {
@@ -115,6 +117,13 @@ func (p *proxyConn) decode(m *proto.Message) (err error) {
 		return p.decodeGetAndTouch(m, line[ed:], RequestTypeGat)
 	case "gats":
 		return p.decodeGetAndTouch(m, line[ed:], RequestTypeGats)
+	// Broadcast:
+	case "flush_all":
+		return p.decodeBroadcast(m, line[ed:], RequestTypeFlushAll)
+	case "version":
+		return p.decodeBroadcast(m, line[ed:], RequestTypeVersion)
+	case "stats":
+		return p.decodeBroadcast(m, line[ed:], RequestTypeStats)
 	}
 	err = errors.WithStack(ErrBadRequest)
 	return
@@ -250,6 +259,32 @@ func (p *proxyConn) decodeGetAndTouch(m *proto.Message, bs []byte, reqType Reque
 	return
 }
 
+// decodeBroadcast decode the request without key, which is sent to all the nodes.
+// NOTE: noreply never supported, flush_all only with delay.
+func (p *proxyConn) decodeBroadcast(m *proto.Message, bs []byte, reqType RequestType) (err error) {
+	args := bytes.Fields(bs)
+	switch reqType {
+	case RequestTypeFlushAll:
+		if len(args) > 1 {
+			err = errors.WithStack(ErrBadRequest)
+			return
+		}
+		if len(args) == 1 {
+			if _, err = conv.Btoi(args[0]); err != nil {
+				err = errors.WithStack(ErrBadRequest)
+				return
+			}
+		}
+	case RequestTypeVersion:
+		if len(args) != 0 {
+			err = errors.WithStack(ErrBadRequest)
+			return
+		}
+	}
+	p.withReq(m, reqType, nil, append([]byte(nil), bs...)) // NOTE: the reply is read into data, never share the buffer
+	return
+}
+
 func (p *proxyConn) withReq(m *proto.Message, rtype RequestType, key []byte, data []byte) {
 	req := m.NextReq()
 	if req == nil {
@@ -340,6 +375,9 @@ func revSpacIdx(bs []byte) int {
 
 // Encode encode response and write into writer.
 func (p *proxyConn) Encode(m *proto.Message) (err error) {
+	if mcr, ok := m.Request().(*MCRequest); ok && m.IsBatch() && mcr.IsBroadcast() {
+		return p.encodeBroadcast(m)
+	}
 	if !m.IsBatch() {
 		if me := m.Err(); me != nil {
 			se := errors.Cause(me).Error()
@@ -381,6 +419,41 @@ func (p *proxyConn) Encode(m *proto.Message) (err error) {
 	return
 }
 
+// encodeBroadcast merge the replies of all the nodes.
+// The stats are replied by the sections of nodes leading by "STAT node <addr>",
+// and the others reply the first error or the first reply when all succeeded.
+func (p *proxyConn) encodeBroadcast(m *proto.Message) (err error) {
+	if me := m.Err(); me != nil {
+		_ = p.bw.Write(serverErrorBytes)
+		_ = p.bw.Write([]byte(errors.Cause(me).Error()))
+		err = p.bw.Write(crlfBytes)
+		return
+	}
+	reqs := m.Requests()
+	for _, req := range reqs {
+		if mcr := req.(*MCRequest); isErrorReply(mcr.data) {
+			return p.bw.Write(mcr.data)
+		}
+	}
+	first := reqs[0].(*MCRequest)
+	if first.rTp != RequestTypeStats || !bytes.HasSuffix(first.data, endBytes) {
+		return p.bw.Write(first.data)
+	}
+	for _, req := range reqs {
+		mcr := req.(*MCRequest)
+		_ = p.bw.Write(statNodeBytes)
+		_ = p.bw.Write([]byte(mcr.node))
+		_ = p.bw.Write(crlfBytes)
+		_ = p.bw.Write(bytes.TrimSuffix(mcr.data, endBytes))
+	}
+	err = p.bw.Write(endBytes)
+	return
+}
+
+func isErrorReply(data []byte) bool {
+	return bytes.HasPrefix(data, errorBytes) || bytes.HasPrefix(data, clientErrorBytes) || bytes.HasPrefix(data, serverErrorBytes)
+}
+
 func (p *proxyConn) Flush() (err error) {
 	return p.bw.Flush()
 }
@@ -246,3 +246,58 @@ func TestEncodeErr(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Contains(t, string(buf[:size]), "SERVER_ERR")
 }
+
+func TestProxyConnBroadcast(t *testing.T) {
+	ts := []struct {
+		Name   string
+		Req    string
+		Write  string
+		Resp   [][]byte
+		Except string
+	}{
+		{Name: "FlushAll", Req: "flush_all 10\r\n", Write: "flush_all 10\r\n",
+			Resp:   [][]byte{[]byte("OK\r\n"), []byte("OK\r\n")},
+			Except: "OK\r\n"},
+		{Name: "FlushAllErr", Req: "flush_all\r\n", Write: "flush_all\r\n",
+			Resp:   [][]byte{[]byte("OK\r\n"), []byte("SERVER_ERROR out of memory\r\n")},
+			Except: "SERVER_ERROR out of memory\r\n"},
+		{Name: "Version", Req: "version\r\n", Write: "version\r\n",
+			Resp:   [][]byte{[]byte("VERSION 1.5.1\r\n"), []byte("VERSION 1.5.2\r\n")},
+			Except: "VERSION 1.5.1\r\n"},
+		{Name: "Stats", Req: "stats\r\n", Write: "stats\r\n",
+			Resp:   [][]byte{[]byte("STAT pid 1\r\nSTAT uptime 2\r\nEND\r\n"), []byte("STAT pid 3\r\nEND\r\n")},
+			Except: "STAT node n1\r\nSTAT pid 1\r\nSTAT uptime 2\r\nSTAT node n2\r\nSTAT pid 3\r\nEND\r\n"},
+		{Name: "StatsReset", Req: "stats reset\r\n", Write: "stats reset\r\n",
+			Resp:   [][]byte{[]byte("RESET\r\n"), []byte("RESET\r\n")},
+			Except: "RESET\r\n"},
+	}
+	for _, tt := range ts {
+		t.Run(tt.Name, func(t *testing.T) {
+			p := NewProxyConn(_createConn([]byte(tt.Req)))
+			msgs, err := p.Decode(proto.GetMsgs(1))
+			assert.NoError(t, err)
+			m := msgs[0]
+			mcr := m.Request().(*MCRequest)
+			assert.True(t, mcr.IsBroadcast())
+			mcr.Broadcast(m, []string{"n1", "n2"})
+			for idx, subm := range m.Batch() {
+				nc := _createNodeConn(tt.Resp[idx])
+				assert.NoError(t, nc.Write(subm))
+				assert.NoError(t, nc.Flush())
+				assert.Equal(t, tt.Write, nc.conn.Conn.(*mockConn).wbuf.String())
+				assert.NoError(t, nc.Read(subm))
+			}
+			conn := _createConn(nil)
+			p = NewProxyConn(conn)
+			assert.NoError(t, p.Encode(m))
+			assert.NoError(t, p.Flush())
+			assert.Equal(t, tt.Except, conn.Conn.(*mockConn).wbuf.String())
+		})
+	}
+
+	p := NewProxyConn(_createConn([]byte("flush_all noreply\r\nversion 1\r\n")))
+	_, err := p.Decode(proto.GetMsgs(1))
+	_causeEqual(t, ErrBadRequest, err)
+	_, err = p.Decode(proto.GetMsgs(1))
+	_causeEqual(t, ErrBadRequest, err)
+}
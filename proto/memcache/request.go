@@ -1,9 +1,13 @@
 package memcache
 
 import (
+	"bytes"
 	errs "errors"
 	"fmt"
+	"strconv"
 	"sync"
+
+	"overlord/proto"
 )
 
 const (
@@ -17,6 +21,9 @@ var (
 	crlfBytes  = []byte("\r\n")
 	endBytes   = []byte("END\r\n")
 	errorBytes = []byte("ERROR\r\n")
+	valueBytes = []byte("VALUE ")
+
+	storedBytes = []byte("STORED\r\n")
 
 	setBytes     = []byte("set")
 	addBytes     = []byte("add")
@@ -39,6 +46,12 @@ var (
 	// notFoundBytes  = []byte("NOT_FOUND\r\n")
 	// deletedBytes   = []byte("DELETED\r\n")
 	// touchedBytes   = []byte("TOUCHED\r\n")
+
+	flushAllBytes = []byte("flush_all")
+	versionBytes  = []byte("version")
+	statsBytes    = []byte("stats")
+	statBytes     = []byte("STAT ")
+	itemBytes     = []byte("ITEM ")
 )
 
 const (
@@ -57,6 +70,10 @@ const (
 	gatString     = "gat"
 	gatsString    = "gats"
 	unknownString = "unknown"
+
+	flushAllString = "flush_all"
+	versionString  = "version"
+	statsString    = "stats"
 )
 
 // RequestType is the protocol-agnostic identifier for the command
@@ -92,6 +109,12 @@ func (rt RequestType) String() string {
 		return gatString
 	case RequestTypeGats:
 		return gatsString
+	case RequestTypeFlushAll:
+		return flushAllString
+	case RequestTypeVersion:
+		return versionString
+	case RequestTypeStats:
+		return statsString
 	}
 	return unknownString
 }
@@ -127,6 +150,12 @@ func (rt RequestType) Bytes() []byte {
 		return gatBytes
 	case RequestTypeGats:
 		return gatsBytes
+	case RequestTypeFlushAll:
+		return flushAllBytes
+	case RequestTypeVersion:
+		return versionBytes
+	case RequestTypeStats:
+		return statsBytes
 	}
 	return unknownBytes
 }
@@ -148,6 +177,9 @@ const (
 	RequestTypeTouch
 	RequestTypeGat
 	RequestTypeGats
+	RequestTypeFlushAll
+	RequestTypeVersion
+	RequestTypeStats
 )
 
 var (
@@ -202,10 +234,15 @@ var (
 // 	touch <key> <exptime> [noreply]\r\n
 // Get And Touch:
 // 	gat|gats <exptime> <key>*\r\n
+// Broadcast commands:
+// 	flush_all [delay]\r\n
+// 	version\r\n
+// 	stats [args]\r\n
 type MCRequest struct {
 	rTp  RequestType
 	key  []byte
 	data []byte
+	node string // NOTE: the node which the broadcast request sent to
 }
 
 var msgPool = &sync.Pool{
@@ -229,6 +266,7 @@ func (r *MCRequest) Put() {
 	r.data = nil
 	r.rTp = RequestTypeUnknown
 	r.key = nil
+	r.node = ""
 	msgPool.Put(r)
 }
 
@@ -247,6 +285,161 @@ func (r *MCRequest) Key() []byte {
 	return r.key
 }
 
+// IsReadOnly check the request whether only reads the data, which can be served by replica.
+func (r *MCRequest) IsReadOnly() bool {
+	return r.rTp == RequestTypeGet || r.rTp == RequestTypeGets
+}
+
+// IsWrite check the request whether changes the data, which should be copied to backup cluster.
+func (r *MCRequest) IsWrite() bool {
+	switch r.rTp {
+	case RequestTypeSet, RequestTypeAdd, RequestTypeReplace, RequestTypeAppend, RequestTypePrepend, RequestTypeCas,
+		RequestTypeDelete, RequestTypeIncr, RequestTypeDecr, RequestTypeTouch:
+		return true
+	}
+	return false
+}
+
+// IsStored check the reply of storage request whether stored.
+func (r *MCRequest) IsStored() bool {
+	return bytes.Equal(r.data, storedBytes)
+}
+
+// IsFlush check the request whether is flush_all, which invalidates all the items.
+func (r *MCRequest) IsFlush() bool {
+	return r.rTp == RequestTypeFlushAll
+}
+
+// IsBroadcast impl proto.Broadcaster.
+func (r *MCRequest) IsBroadcast() bool {
+	return r.rTp == RequestTypeFlushAll || r.rTp == RequestTypeVersion || r.rTp == RequestTypeStats
+}
+
+// Broadcast impl proto.Broadcaster, the copies of request for the other nodes are appended into message.
+func (r *MCRequest) Broadcast(m *proto.Message, nodes []string) {
+	r.node = nodes[0]
+	for _, node := range nodes[1:] {
+		req, ok := m.NextReq().(*MCRequest)
+		if !ok {
+			req = GetReq()
+			m.WithRequest(req)
+		}
+		req.rTp = r.rTp
+		req.key = nil
+		req.data = append([]byte(nil), r.data...) // NOTE: the reply is read into data, never share
+		req.node = node
+	}
+}
+
+// IsMiss check the reply of retrieval request whether missed.
+func (r *MCRequest) IsMiss() bool {
+	return bytes.Equal(r.data, endBytes)
+}
+
+// IsHit check the reply of retrieval request whether is value.
+func (r *MCRequest) IsHit() bool {
+	return bytes.HasPrefix(r.data, valueBytes)
+}
+
+// HitReply returns the reply of retrieval request when hit.
+func (r *MCRequest) HitReply() ([]byte, bool) {
+	if !r.IsHit() {
+		return nil, false
+	}
+	return r.data, true
+}
+
+// WithHitReply set the reply of retrieval request which is cached by proxy.
+// NOTE: the data is shared and never modified, for the reply only be encoded.
+func (r *MCRequest) WithHitReply(data []byte) {
+	r.data = data
+}
+
+// ResetRetrieval reset the replied retrieval request, then it can be forwarded again.
+func (r *MCRequest) ResetRetrieval() {
+	r.data = crlfBytes
+}
+
+// WithMiss set the reply of retrieval request as missed.
+func (r *MCRequest) WithMiss() {
+	r.data = endBytes
+}
+
+// Type returns the request type.
+func (r *MCRequest) Type() RequestType {
+	return r.rTp
+}
+
+// ToSet returns the set request from pool which stores the value replied by get request with exptime,
+// false when the reply is not a value.
+func (r *MCRequest) ToSet(exptime int) (*MCRequest, bool) {
+	if r.rTp != RequestTypeGet || !bytes.HasPrefix(r.data, valueBytes) {
+		return nil, false
+	}
+	idx := bytes.Index(r.data, crlfBytes)
+	if idx == -1 {
+		return nil, false
+	}
+	// NOTE: VALUE <key> <flags> <bytes>\r\n<data block>\r\nEND\r\n
+	fields := bytes.Fields(r.data[:idx])
+	if len(fields) != 4 || !bytes.HasSuffix(r.data, endBytes) {
+		return nil, false
+	}
+	return newSetReq(r.key, fields[2], exptime, fields[3], r.data[idx:len(r.data)-len(endBytes)]), true
+}
+
+// CloneWithExptime returns the copy of set request from pool with the exptime replaced, false when not a set.
+func (r *MCRequest) CloneWithExptime(exptime int) (*MCRequest, bool) {
+	if r.rTp != RequestTypeSet {
+		return nil, false
+	}
+	idx := bytes.Index(r.data, crlfBytes)
+	if idx == -1 {
+		return nil, false
+	}
+	// NOTE: <flags> <exptime> <bytes>\r\n<data block>\r\n
+	fields := bytes.Fields(r.data[:idx])
+	if len(fields) != 3 {
+		return nil, false
+	}
+	return newSetReq(r.key, fields[0], exptime, fields[2], r.data[idx:]), true
+}
+
+// newSetReq returns the set request from pool, block is the data block with the leading and trailing CRLF.
+func newSetReq(key, flags []byte, exptime int, length, block []byte) *MCRequest {
+	req := GetReq()
+	req.rTp = RequestTypeSet
+	req.key = append(req.key[:0], key...)
+	req.data = req.data[:0]
+	req.data = append(req.data, spaceByte)
+	req.data = append(req.data, flags...)
+	req.data = append(req.data, spaceByte)
+	req.data = strconv.AppendInt(req.data, int64(exptime), 10)
+	req.data = append(req.data, spaceByte)
+	req.data = append(req.data, length...)
+	req.data = append(req.data, block...)
+	return req
+}
+
+// NewDeleteReq returns the delete request of key from pool, the key is copied.
+func NewDeleteReq(key []byte) *MCRequest {
+	req := GetReq()
+	req.rTp = RequestTypeDelete
+	req.key = append(req.key[:0], key...)
+	req.data = crlfBytes
+	return req
+}
+
+// Clone returns the copy of request from pool, the key and data are copied too,
+// so it can be forwarded after the origin replied or put back.
+func (r *MCRequest) Clone() *MCRequest {
+	req := GetReq()
+	req.rTp = r.rTp
+	req.key = append(req.key[:0], r.key...)
+	req.data = append(req.data[:0], r.data...)
+	return req
+}
+
 func (r *MCRequest) String() string {
 	return fmt.Sprintf("type:%s key:%s data:%s", r.rTp.Bytes(), r.key, r.data)
 }
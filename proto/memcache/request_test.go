@@ -42,6 +42,9 @@ func TestMCRequestFuncsOk(t *testing.T) {
 	assert.Equal(t, []byte("get"), req.Cmd())
 	assert.Equal(t, "abc", string(req.Key()))
 	assert.Equal(t, "type:get key:abc data:\r\n", req.String())
+	assert.True(t, req.IsReadOnly())
+	req.rTp = RequestTypeGat
+	assert.False(t, req.IsReadOnly())
 
 	req.Put()
 
@@ -49,3 +52,52 @@ func TestMCRequestFuncsOk(t *testing.T) {
 	assert.Nil(t, req.key)
 	assert.Nil(t, req.data)
 }
+
+func TestMCRequestToSet(t *testing.T) {
+	req := &MCRequest{rTp: RequestTypeGet, key: []byte("abc"), data: []byte("VALUE abc 3 5\r\nhello\r\nEND\r\n")}
+	assert.False(t, req.IsMiss())
+	assert.True(t, req.IsHit())
+	data, ok := req.HitReply()
+	assert.True(t, ok)
+	assert.Equal(t, "VALUE abc 3 5\r\nhello\r\nEND\r\n", string(data))
+	set, ok := req.ToSet(60)
+	if assert.True(t, ok) {
+		assert.Equal(t, RequestTypeSet, set.Type())
+		assert.Equal(t, "abc", string(set.Key()))
+		assert.Equal(t, " 3 60 5\r\nhello\r\n", string(set.data))
+		assert.True(t, set.IsWrite())
+	}
+	req.WithMiss()
+	assert.True(t, req.IsMiss())
+	assert.False(t, req.IsHit())
+	_, ok = req.HitReply()
+	assert.False(t, ok)
+	_, ok = req.ToSet(60)
+	assert.False(t, ok)
+	req.ResetRetrieval()
+	assert.Equal(t, "\r\n", string(req.data))
+
+	req.rTp = RequestTypeGets
+	req.data = []byte("VALUE abc 3 5 10\r\nhello\r\nEND\r\n")
+	_, ok = req.ToSet(60)
+	assert.False(t, ok)
+}
+
+func TestMCRequestCloneAndDelete(t *testing.T) {
+	req := &MCRequest{rTp: RequestTypeSet, key: []byte("abc"), data: []byte(" 0 0 1\r\n1\r\n")}
+	clone := req.Clone()
+	req.key[0], req.data[1] = 'x', '9'
+	assert.Equal(t, "abc", string(clone.Key()))
+	assert.Equal(t, " 0 0 1\r\n1\r\n", string(clone.data))
+	set, ok := clone.CloneWithExptime(30)
+	if assert.True(t, ok) {
+		assert.Equal(t, " 0 30 1\r\n1\r\n", string(set.data))
+	}
+
+	del := NewDeleteReq(clone.Key())
+	assert.Equal(t, RequestTypeDelete, del.Type())
+	assert.Equal(t, "abc", string(del.Key()))
+	assert.Equal(t, "\r\n", string(del.data))
+	_, ok = del.CloneWithExptime(30)
+	assert.False(t, ok)
+}
@@ -1,10 +1,20 @@
 package proto
 
 import (
+	errs "errors"
+	"io"
+	"net"
 	"sync"
 	"sync/atomic"
+	"time"
 
+	"overlord/lib/breaker"
 	"overlord/lib/hashkit"
+	"overlord/lib/log"
+	libnet "overlord/lib/net"
+	"overlord/lib/prom"
+
+	"github.com/pkg/errors"
 )
 
 const (
@@ -14,38 +24,69 @@ const (
 	pipeMaxCount = 128
 )
 
+// errors
+var (
+	ErrNodeConnPipeClosed = errs.New("node conn pipe already closed")
+	ErrNodeBreakerOpen    = errs.New("node circuit breaker open")
+)
+
 // NodeConnPipe multi MsgPipe for node conns.
 type NodeConnPipe struct {
+	cluster string
+	addr    string
+	breaker *breaker.Breaker // NOTE: nil means no breaker
+
 	conns  int32
 	inputs []chan *Message
 	mps    []*msgPipe
 	l      sync.RWMutex
+	wg     sync.WaitGroup
 
 	errCh chan error
 
 	state int32
 }
 
-// NewNodeConnPipe new NodeConnPipe.
-func NewNodeConnPipe(conns int32, newNc func() NodeConn) (ncp *NodeConnPipe) {
+// NewNodeConnPipe new NodeConnPipe, cluster and addr are used by metrics.
+// The node is guarded by circuit breaker with bc, nil means no breaker.
+func NewNodeConnPipe(cluster, addr string, conns int32, bc *breaker.Config, newNc func() NodeConn) (ncp *NodeConnPipe) {
 	if conns <= 0 {
 		panic("the number of connections cannot be zero")
 	}
 	ncp = &NodeConnPipe{
-		conns:  conns,
-		inputs: make([]chan *Message, conns),
-		mps:    make([]*msgPipe, conns),
-		errCh:  make(chan error, 1),
+		cluster: cluster,
+		addr:    addr,
+		conns:   conns,
+		inputs:  make([]chan *Message, conns),
+		mps:     make([]*msgPipe, conns),
+		errCh:   make(chan error, 1),
+	}
+	if bc != nil {
+		ncp.breaker = breaker.New(bc, ncp.breakerChanged)
+		if prom.On {
+			prom.BreakerState(cluster, addr, int(breaker.StateClosed))
+		}
 	}
 	for i := int32(0); i < ncp.conns; i++ {
 		ncp.inputs[i] = make(chan *Message, pipeMaxCount*128)
-		ncp.mps[i] = newMsgPipe(ncp.inputs[i], newNc, ncp.errCh)
+		ncp.wg.Add(1)
+		ncp.mps[i] = newMsgPipe(cluster, addr, ncp.breaker, ncp.inputs[i], newNc, ncp.errCh, &ncp.wg)
 	}
 	return
 }
 
 // Push push message into input chan.
+// NOTE: the message is failed immediately with ErrNodeBreakerOpen when the breaker of node is open.
 func (ncp *NodeConnPipe) Push(m *Message) {
+	if ncp.breaker != nil && !ncp.breaker.Allow() {
+		m.WithError(ErrNodeBreakerOpen)
+		if prom.On {
+			if req := m.Request(); req != nil {
+				prom.ErrIncr(ncp.cluster, ncp.addr, req.CmdString(), errClass(ErrNodeBreakerOpen))
+			}
+		}
+		return
+	}
 	ncp.l.RLock()
 	if ncp.state == opened {
 		if ncp.conns == 1 {
@@ -61,28 +102,67 @@ func (ncp *NodeConnPipe) Push(m *Message) {
 				// NOTE: impossible!!!
 			}
 		}
+	} else {
+		m.WithError(ErrNodeConnPipeClosed)
 	}
 	ncp.l.RUnlock()
 }
 
+// Conns return the number of node conns.
+func (ncp *NodeConnPipe) Conns() int32 {
+	return ncp.conns
+}
+
+// BreakerState returns the state of breaker, always closed when no breaker.
+func (ncp *NodeConnPipe) BreakerState() breaker.State {
+	if ncp.breaker == nil {
+		return breaker.StateClosed
+	}
+	return ncp.breaker.State()
+}
+
+func (ncp *NodeConnPipe) breakerChanged(from, to breaker.State) {
+	if log.V(2) {
+		log.Warnf("cluster(%s) node(%s) circuit breaker %s -> %s", ncp.cluster, ncp.addr, from, to)
+	}
+	if prom.On {
+		prom.BreakerState(ncp.cluster, ncp.addr, int(to))
+	}
+}
+
 // ErrorEvent return error chan.
 func (ncp *NodeConnPipe) ErrorEvent() <-chan error {
 	return ncp.errCh
 }
 
 // Close close pipe.
+// The messages already pushed will be drained by msgPipe before the node conns closed.
 func (ncp *NodeConnPipe) Close() {
-	close(ncp.errCh)
 	ncp.l.Lock()
+	if ncp.state == closed {
+		ncp.l.Unlock()
+		return
+	}
 	ncp.state = closed
 	for _, input := range ncp.inputs {
 		close(input)
 	}
 	ncp.l.Unlock()
+	if ncp.breaker != nil && prom.On {
+		prom.BreakerDelete(ncp.cluster, ncp.addr)
+	}
+	go func() {
+		ncp.wg.Wait()
+		close(ncp.errCh) // NOTE: close after all msgPipe exited, avoid send on closed chan.
+	}()
 }
 
 // msgPipe message pipeline.
 type msgPipe struct {
+	cluster string
+	addr    string
+	breaker *breaker.Breaker
+
 	nc    atomic.Value
 	newNc func() NodeConn
 	input <-chan *Message
@@ -91,14 +171,19 @@ type msgPipe struct {
 	count int
 
 	errCh chan<- error
+	wg    *sync.WaitGroup
 }
 
 // newMsgPipe new msgPipe and return.
-func newMsgPipe(input <-chan *Message, newNc func() NodeConn, errCh chan<- error) (mp *msgPipe) {
+func newMsgPipe(cluster, addr string, brk *breaker.Breaker, input <-chan *Message, newNc func() NodeConn, errCh chan<- error, wg *sync.WaitGroup) (mp *msgPipe) {
 	mp = &msgPipe{
-		newNc: newNc,
-		input: input,
-		errCh: errCh,
+		cluster: cluster,
+		addr:    addr,
+		breaker: brk,
+		newNc:   newNc,
+		input:   input,
+		errCh:   errCh,
+		wg:      wg,
 	}
 	mp.nc.Store(newNc())
 	go mp.pipe()
@@ -111,6 +196,7 @@ func (mp *msgPipe) pipe() {
 		ok bool
 		nc = mp.nc.Load().(NodeConn)
 	)
+	defer mp.wg.Done()
 	for {
 		for {
 			if m == nil {
@@ -126,10 +212,20 @@ func (mp *msgPipe) pipe() {
 					break
 				}
 			}
+			if mp.breaker != nil && mp.breaker.State() == breaker.StateOpen {
+				// NOTE: the messages queued before breaker opened are failed fast too, not wait for the slow node.
+				m.WithError(ErrNodeBreakerOpen)
+				mp.errIncr(m, ErrNodeBreakerOpen)
+				m.Done()
+				m = nil
+				continue
+			}
 			mp.batch[mp.count] = m
 			mp.count++
+			m.MarkWrite()
 			if werr := nc.Write(m); werr != nil {
 				m.WithError(werr)
+				mp.errIncr(m, werr)
 			}
 			m = nil
 			if mp.count >= pipeMaxCount {
@@ -140,6 +236,8 @@ func (mp *msgPipe) pipe() {
 			if ferr := nc.Flush(); ferr != nil {
 				for i := 0; i < mp.count; i++ {
 					mp.batch[i].WithError(ferr)
+					mp.errIncr(mp.batch[i], ferr)
+					mp.mark(mp.batch[i], ferr)
 					mp.batch[i].Done()
 				}
 				mp.count = 0
@@ -150,7 +248,12 @@ func (mp *msgPipe) pipe() {
 			for i := 0; i < mp.count; i++ {
 				if rerr = nc.Read(mp.batch[i]); rerr != nil {
 					mp.batch[i].WithError(rerr)
+					mp.errIncr(mp.batch[i], rerr)
+				} else {
+					mp.batch[i].MarkRead()
+					mp.handleTime(mp.batch[i])
 				}
+				mp.mark(mp.batch[i], rerr)
 				mp.batch[i].Done()
 			}
 			mp.count = 0
@@ -175,6 +278,54 @@ func (mp *msgPipe) reNewNc(nc NodeConn, err error) NodeConn {
 		}
 	}
 	nc.Close()
+	if prom.On {
+		prom.ReconnIncr(mp.cluster, mp.addr)
+	}
 	mp.nc.Store(mp.newNc())
 	return mp.nc.Load().(NodeConn)
 }
+
+// mark the result of message into breaker, the remote duration is the latency.
+func (mp *msgPipe) mark(m *Message, err error) {
+	if mp.breaker != nil {
+		mp.breaker.Mark(err, m.RemoteDur())
+	}
+}
+
+func (mp *msgPipe) handleTime(m *Message) {
+	if !prom.On {
+		return
+	}
+	if req := m.Request(); req != nil {
+		prom.HandleTime(mp.cluster, mp.addr, req.CmdString(), int64(m.RemoteDur()/time.Microsecond))
+	}
+}
+
+func (mp *msgPipe) errIncr(m *Message, err error) {
+	if !prom.On {
+		return
+	}
+	if req := m.Request(); req != nil {
+		prom.ErrIncr(mp.cluster, mp.addr, req.CmdString(), errClass(err))
+	}
+}
+
+// errClass returns the class of backend error for metrics, keep the label values limited.
+func errClass(err error) string {
+	err = errors.Cause(err)
+	switch err {
+	case io.EOF, io.ErrUnexpectedEOF:
+		return "eof"
+	case libnet.ErrConnClosed:
+		return "closed"
+	case ErrNodeBreakerOpen:
+		return "breaker"
+	}
+	if ne, ok := err.(net.Error); ok {
+		if ne.Timeout() {
+			return "timeout"
+		}
+		return "network"
+	}
+	return "other"
+}
@@ -2,10 +2,15 @@ package proto
 
 import (
 	"crypto/rand"
+	"io"
 	"sync"
 	"testing"
 	"time"
 
+	"overlord/lib/breaker"
+	libnet "overlord/lib/net"
+
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -34,11 +39,11 @@ func (*mockRequest) Put() {}
 
 func TestPipe(t *testing.T) {
 	nc1 := &mockNodeConn{}
-	ncp1 := NewNodeConnPipe(1, func() NodeConn {
+	ncp1 := NewNodeConnPipe("test", "127.0.0.1:1", 1, nil, func() NodeConn {
 		return nc1
 	})
 	nc2 := &mockNodeConn{}
-	ncp2 := NewNodeConnPipe(2, func() NodeConn {
+	ncp2 := NewNodeConnPipe("test", "127.0.0.1:2", 2, nil, func() NodeConn {
 		return nc2
 	})
 	wg := &sync.WaitGroup{}
@@ -56,3 +61,69 @@ func TestPipe(t *testing.T) {
 	assert.True(t, nc1.closed)
 	assert.True(t, nc2.closed)
 }
+
+type mockNetErr struct{ timeout bool }
+
+func (e *mockNetErr) Error() string   { return "mock net error" }
+func (e *mockNetErr) Timeout() bool   { return e.timeout }
+func (e *mockNetErr) Temporary() bool { return false }
+
+func TestErrClass(t *testing.T) {
+	assert.Equal(t, "eof", errClass(errors.WithStack(io.EOF)))
+	assert.Equal(t, "closed", errClass(libnet.ErrConnClosed))
+	assert.Equal(t, "timeout", errClass(errors.WithStack(&mockNetErr{timeout: true})))
+	assert.Equal(t, "network", errClass(&mockNetErr{}))
+	assert.Equal(t, "other", errClass(ErrNodeConnPipeClosed))
+}
+
+func TestPipeMarkRemoteTime(t *testing.T) {
+	ncp := NewNodeConnPipe("test", "127.0.0.1:1", 1, nil, func() NodeConn {
+		return &mockNodeConn{}
+	})
+	defer ncp.Close()
+	wg := &sync.WaitGroup{}
+	m := getMsg()
+	m.Reset()
+	m.WithRequest(&mockRequest{})
+	m.WithWaitGroup(wg)
+	ncp.Push(m)
+	wg.Wait()
+	assert.True(t, m.RemoteDur() >= 0)
+	assert.NotEqual(t, defaultTime, m.wt)
+	assert.NotEqual(t, defaultTime, m.rt)
+}
+
+type mockErrNodeConn struct {
+	mockNodeConn
+	err error
+}
+
+func (n *mockErrNodeConn) Read(*Message) error { return n.err }
+
+func TestPipeBreaker(t *testing.T) {
+	nc := &mockErrNodeConn{err: io.EOF}
+	bc := &breaker.Config{ErrorRate: 50, MinRequests: 2, Window: time.Minute, OpenTimeout: 50 * time.Millisecond}
+	ncp := NewNodeConnPipe("test", "127.0.0.1:1", 1, bc, func() NodeConn {
+		return nc
+	})
+	defer ncp.Close()
+	push := func() *Message {
+		wg := &sync.WaitGroup{}
+		m := getMsg()
+		m.Reset()
+		m.WithRequest(&mockRequest{})
+		m.WithWaitGroup(wg)
+		ncp.Push(m)
+		wg.Wait()
+		return m
+	}
+	assert.Equal(t, io.EOF, errors.Cause(push().Err()))
+	assert.Equal(t, io.EOF, errors.Cause(push().Err()))
+	assert.Equal(t, breaker.StateOpen, ncp.BreakerState())
+	assert.Equal(t, ErrNodeBreakerOpen, push().Err(), "failed fast when open")
+
+	time.Sleep(50 * time.Millisecond)
+	nc.err = nil
+	assert.NoError(t, push().Err(), "probe when half-open")
+	assert.Equal(t, breaker.StateClosed, ncp.BreakerState())
+}
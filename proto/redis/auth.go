@@ -0,0 +1,97 @@
+package redis
+
+import (
+	errs "errors"
+	"strconv"
+
+	"overlord/lib/bufio"
+	libnet "overlord/lib/net"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	authBufferSize = 128
+)
+
+// errors
+var (
+	ErrAuthFailed     = errs.New("redis auth failed")
+	ErrReadOnlyFailed = errs.New("redis readonly failed")
+)
+
+var (
+	cmdAuthBytes       = []byte("4\r\nAUTH")
+	cmdAuthPrefixBytes = []byte("*2\r\n$4\r\nAUTH\r\n$")
+	cmdReadOnlyBytes   = []byte("*1\r\n$8\r\nREADONLY\r\n")
+
+	authOKBytes          = []byte("OK")
+	noAuthDataBytes      = []byte("NOAUTH Authentication required.")
+	invalidPassDataBytes = []byte("ERR invalid password")
+	noPassSetDataBytes   = []byte("ERR Client sent AUTH, but no password is set")
+	authArgsDataBytes    = []byte("ERR wrong number of arguments for 'auth' command")
+)
+
+// Auth send AUTH with password by the new conn and check the reply.
+// It must be called before any other command was sent by conn, and do nothing when password is empty.
+func Auth(conn *libnet.Conn, password string) (err error) {
+	if password == "" {
+		return
+	}
+	bw := bufio.NewWriter(conn)
+	_ = bw.Write(cmdAuthPrefixBytes)
+	_ = bw.Write([]byte(strconv.Itoa(len(password))))
+	_ = bw.Write(crlfBytes)
+	_ = bw.Write([]byte(password))
+	_ = bw.Write(crlfBytes)
+	if err = bw.Flush(); err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	reply, err := readSetupReply(conn)
+	if err != nil {
+		return
+	}
+	if reply.rTp != respString {
+		err = errors.Wrapf(ErrAuthFailed, "%s", reply.data)
+	}
+	return
+}
+
+// ReadOnly send READONLY by the new conn and check the reply, which enables the read requests to redis cluster replica.
+// It must be called before any other command except AUTH was sent by conn.
+func ReadOnly(conn *libnet.Conn) (err error) {
+	bw := bufio.NewWriter(conn)
+	_ = bw.Write(cmdReadOnlyBytes)
+	if err = bw.Flush(); err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	reply, err := readSetupReply(conn)
+	if err != nil {
+		return
+	}
+	if reply.rTp != respString {
+		err = errors.Wrapf(ErrReadOnlyFailed, "%s", reply.data)
+	}
+	return
+}
+
+// readSetupReply read one reply of the conn setup command.
+func readSetupReply(conn *libnet.Conn) (reply *resp, err error) {
+	br := bufio.NewReader(conn, bufio.NewBuffer(authBufferSize))
+	reply = &resp{}
+	for {
+		if err = br.Read(); err != nil {
+			err = errors.WithStack(err)
+			return
+		}
+		if err = reply.decode(br); err == bufio.ErrBufferFull {
+			continue
+		} else if err != nil {
+			err = errors.WithStack(err)
+			return
+		}
+		return
+	}
+}
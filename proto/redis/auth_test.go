@@ -0,0 +1,50 @@
+package redis
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthOk(t *testing.T) {
+	conn := _createConn([]byte("+OK\r\n"))
+	assert.NoError(t, Auth(conn, "foobar"))
+	buf := conn.Conn.(*mockConn).wbuf
+	assert.Equal(t, "*2\r\n$4\r\nAUTH\r\n$6\r\nfoobar\r\n", buf.String())
+}
+
+func TestAuthEmptyPassword(t *testing.T) {
+	conn := _createConn(nil)
+	assert.NoError(t, Auth(conn, ""))
+	buf := conn.Conn.(*mockConn).wbuf
+	assert.Equal(t, 0, buf.Len())
+}
+
+func TestAuthFailed(t *testing.T) {
+	conn := _createConn([]byte("-ERR invalid password\r\n"))
+	err := Auth(conn, "foobar")
+	assert.Equal(t, ErrAuthFailed, errors.Cause(err))
+	assert.Contains(t, err.Error(), "ERR invalid password")
+}
+
+func TestReadOnlyOk(t *testing.T) {
+	conn := _createConn([]byte("+OK\r\n"))
+	assert.NoError(t, ReadOnly(conn))
+	buf := conn.Conn.(*mockConn).wbuf
+	assert.Equal(t, "*1\r\n$8\r\nREADONLY\r\n", buf.String())
+}
+
+func TestReadOnlyFailed(t *testing.T) {
+	conn := _createConn([]byte("-ERR This instance has cluster support disabled\r\n"))
+	err := ReadOnly(conn)
+	assert.Equal(t, ErrReadOnlyFailed, errors.Cause(err))
+	assert.Contains(t, err.Error(), "cluster support disabled")
+}
+
+func TestPingerWithAuthFailed(t *testing.T) {
+	conn := _createConn([]byte("-ERR invalid password\r\n"))
+	p := NewPinger(conn, "foobar")
+	err := p.Ping()
+	assert.Equal(t, ErrPingClosed, errors.Cause(err))
+}
@@ -0,0 +1,231 @@
+package redis
+
+import (
+	"bytes"
+	errs "errors"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	libnet "overlord/lib/net"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// watchReadBufSize is the buffer of reading client conn while the command blocked.
+	watchReadBufSize = 1024
+	// watchStopInterval is the interval of waking up the reading of client conn when stopping watch.
+	watchStopInterval = 10 * time.Millisecond
+)
+
+// errors
+var (
+	ErrInterrupted = errs.New("blocking command interrupted")
+)
+
+var (
+	cmdXReadBytes      = []byte("5\r\nXREAD")
+	cmdXReadGroupBytes = []byte("10\r\nXREADGROUP")
+	cmdBRPopLPushBytes = []byte("10\r\nBRPOPLPUSH")
+
+	streamsBytes = []byte("STREAMS")
+	groupBytes   = []byte("GROUP")
+	countBytes   = []byte("COUNT")
+	blockBytes   = []byte("BLOCK")
+
+	blockArgsDataBytes    = []byte("ERR syntax error")
+	blockTimeoutDataBytes = []byte("ERR timeout is not a float or out of range")
+)
+
+// block send the blocking command by the temporary dedicated conn to the node of keys, so the shared conns
+// are never blocked, the conn is closed when replied or the timeout of command with the read timeout of cluster.
+// NOTE: the client conn is watched while blocked, so the command blocked forever is released when the client closed.
+func (pc *proxyConn) block(r *Request) (err error) {
+	if pc.router == nil {
+		r.withLocalReply(respError, notSupportDataBytes)
+		return
+	}
+	keys := blockKeys(r.resp)
+	if len(keys) == 0 {
+		r.withLocalReply(respError, blockArgsDataBytes)
+		return
+	}
+	if len(keys) > 1 && !pc.router.SameNode(keys) {
+		r.WithCrossSlot()
+		return
+	}
+	sc, perr := pc.dialKey(keys[0])
+	if perr != nil {
+		r.withPinError(perr)
+		return
+	}
+	defer sc.close()
+	rto, ok := blockTimeout(r.resp, sc.conn.SetReadTimeout(0))
+	if !ok {
+		r.withLocalReply(respError, blockTimeoutDataBytes)
+		return
+	}
+	sc.conn.SetReadTimeout(rto)
+	pc.lock.Lock()
+	if pc.interrupted {
+		pc.lock.Unlock()
+		return errors.WithStack(ErrInterrupted)
+	}
+	pc.blocked = sc
+	pc.lock.Unlock()
+	defer func() {
+		pc.lock.Lock()
+		pc.blocked = nil
+		pc.lock.Unlock()
+	}()
+	defer pc.watchClient()()
+	_ = r.resp.encode(sc.bw)
+	if err = sc.bw.Flush(); err != nil {
+		return errors.WithStack(err)
+	}
+	if err = sc.decode(r.reply); err != nil {
+		return errors.WithStack(err)
+	}
+	return
+}
+
+// Interrupt impl proto.Pinner, the blocking command is failed and the client conn should be closed.
+func (pc *proxyConn) Interrupt() {
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+	pc.interrupted = true
+	if pc.blocked != nil {
+		pc.blocked.close()
+	}
+}
+
+// watchClient read the client conn while the command blocked, the blocked is interrupted when the client closed,
+// and the bytes read like the commands pipelined are decoded later. The stop returned must be called when replied.
+func (pc *proxyConn) watchClient() (stop func()) {
+	var stopped int32
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, watchReadBufSize)
+		for {
+			n, err := pc.conn.Read(buf)
+			pc.pr.peeked = append(pc.pr.peeked, buf[:n]...)
+			if n > 0 || atomic.LoadInt32(&stopped) == 1 {
+				return // NOTE: the client is alive, and the next commands are read after replied
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			if err != nil {
+				pc.Interrupt()
+				return
+			}
+		}
+	}()
+	return func() {
+		atomic.StoreInt32(&stopped, 1)
+		for {
+			_ = pc.conn.SetReadDeadline(time.Now()) // NOTE: the reading may set the deadline again by read timeout
+			select {
+			case <-done:
+				_ = pc.conn.SetReadDeadline(time.Time{})
+				return
+			case <-time.After(watchStopInterval):
+			}
+		}
+	}
+}
+
+// peekReader serves the bytes read by watchClient before reading the client conn.
+type peekReader struct {
+	conn   *libnet.Conn
+	peeked []byte
+}
+
+func (r *peekReader) Read(b []byte) (int, error) {
+	if len(r.peeked) > 0 {
+		n := copy(b, r.peeked)
+		r.peeked = r.peeked[n:]
+		return n, nil
+	}
+	return r.conn.Read(b)
+}
+
+// blocks check the blocking command whether blocks the conn, XREAD and XREADGROUP only block with BLOCK.
+func blocks(re *resp) bool {
+	cmd := re.array[0].data
+	if bytes.Equal(cmd, cmdXReadBytes) || bytes.Equal(cmd, cmdXReadGroupBytes) {
+		_, block := xreadArgs(re)
+		return block != 0
+	}
+	return true
+}
+
+// blockKeys returns all the keys of blocking command, nil when the arguments are bad.
+func blockKeys(re *resp) (keys [][]byte) {
+	cmd := re.array[0].data
+	if bytes.Equal(cmd, cmdXReadBytes) || bytes.Equal(cmd, cmdXReadGroupBytes) {
+		streams, _ := xreadArgs(re)
+		n := re.arrayn - streams - 1 // NOTE: the keys and the ids
+		if streams == 0 || n == 0 || n%2 != 0 {
+			return nil
+		}
+		for i := streams + 1; i <= streams+n/2; i++ {
+			keys = append(keys, bulkValue(re.array[i].data))
+		}
+		return
+	}
+	if bytes.Equal(cmd, cmdBRPopLPushBytes) && re.arrayn != 4 {
+		return nil
+	}
+	c, _ := lookup(cmd)
+	return c.kp.keys(re) // NOTE: the last is timeout
+}
+
+// blockTimeout returns the read timeout of dedicated conn by the timeout of command in addition to
+// the read timeout of cluster, 0 means blocked forever, false means the timeout is bad.
+func blockTimeout(re *resp, rto time.Duration) (time.Duration, bool) {
+	cmd := re.array[0].data
+	if bytes.Equal(cmd, cmdXReadBytes) || bytes.Equal(cmd, cmdXReadGroupBytes) {
+		_, block := xreadArgs(re)
+		if block == 0 {
+			return rto, true // NOTE: never blocked without BLOCK
+		}
+		ms, err := strconv.ParseInt(string(bulkValue(re.array[block].data)), 10, 64)
+		if err != nil || ms < 0 {
+			return 0, false
+		} else if ms == 0 {
+			return 0, true
+		}
+		return time.Duration(ms)*time.Millisecond + rto, true
+	}
+	sec, err := strconv.ParseFloat(string(bulkValue(re.array[re.arrayn-1].data)), 64)
+	if err != nil || sec < 0 {
+		return 0, false
+	} else if sec == 0 {
+		return 0, true
+	}
+	return time.Duration(sec*float64(time.Second)) + rto, true
+}
+
+// xreadArgs returns the positions of STREAMS and the milliseconds of BLOCK in the arguments of XREAD
+// and XREADGROUP, 0 means not found.
+func xreadArgs(re *resp) (streams, block int) {
+	for i := 1; i < re.arrayn; i++ {
+		arg := bulkValue(re.array[i].data)
+		switch {
+		case bytes.EqualFold(arg, streamsBytes):
+			return i, block
+		case bytes.EqualFold(arg, groupBytes):
+			i += 2 // NOTE: the group and the consumer
+		case bytes.EqualFold(arg, blockBytes) && i+1 < re.arrayn:
+			i++
+			block = i
+		case bytes.EqualFold(arg, countBytes):
+			i++
+		}
+	}
+	return
+}
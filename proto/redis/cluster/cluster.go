@@ -4,16 +4,19 @@ import (
 	"bytes"
 	errs "errors"
 	"net"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"overlord/lib/breaker"
 	"overlord/lib/hashkit"
 	"overlord/lib/log"
 	libnet "overlord/lib/net"
 	"overlord/proto"
+	"overlord/proto/redis"
 )
 
 const (
@@ -25,7 +28,9 @@ const (
 
 // errors
 var (
-	ErrClusterClosed = errs.New("cluster executor already closed")
+	ErrClusterClosed   = errs.New("cluster executor already closed")
+	ErrClusterNoMaster = errs.New("cluster no master node")
+	ErrClusterFetch    = errs.New("cluster all seed nodes fail to fetch")
 )
 
 const (
@@ -45,11 +50,17 @@ type cluster struct {
 	name          string
 	servers       []string
 	conns         int32
+	auth          string
 	dto, rto, wto time.Duration
 	hashTag       []byte
+	readPolicy    proto.ReadPolicy
+	bc            *breaker.Config // NOTE: the circuit breaker of every node, nil means no breaker
 
 	slotNode atomic.Value
+	rr       uint32 // NOTE: round robin counter of the read routes
 	action   chan struct{}
+	done     chan struct{}
+	lock     sync.Mutex // NOTE: guard the servers and the pipes between initSlotNode and Close
 
 	fakeNodesBytes []byte
 	fakeSlotsBytes []byte
@@ -59,18 +70,26 @@ type cluster struct {
 }
 
 // NewForwarder new proto Forwarder.
-func NewForwarder(name, listen string, servers []string, conns int32, dto, rto, wto time.Duration, hashTag []byte) proto.Forwarder {
+// When auth is not empty, AUTH will be sent first by all the connections to redis nodes.
+// When readPolicy is prefer_replica or round_robin, the read-only requests can be forwarded to the replicas,
+// and READONLY will be sent by all the connections to redis nodes.
+// When bc is not nil, every node is guarded by the circuit breaker.
+func NewForwarder(name, listen string, servers []string, conns int32, auth string, dto, rto, wto time.Duration, hashTag []byte, readPolicy proto.ReadPolicy, bc *breaker.Config) proto.Forwarder {
 	c := &cluster{
-		name:    name,
-		servers: servers,
-		conns:   conns,
-		dto:     dto,
-		rto:     rto,
-		wto:     wto,
-		hashTag: hashTag,
-		action:  make(chan struct{}),
-	}
-	if !c.tryFetch() {
+		name:       name,
+		servers:    servers,
+		conns:      conns,
+		auth:       auth,
+		dto:        dto,
+		rto:        rto,
+		wto:        wto,
+		hashTag:    hashTag,
+		readPolicy: readPolicy,
+		bc:         bc,
+		action:     make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	if !c.tryFetch(servers) {
 		panic("redis cluster all seed nodes fail to fetch")
 	}
 	c.fake(listen)
@@ -80,38 +99,213 @@ func NewForwarder(name, listen string, servers []string, conns int32, dto, rto,
 
 func (c *cluster) Forward(msgs []*proto.Message) error {
 	if state := atomic.LoadInt32(&c.state); state == closed {
+		for _, m := range msgs {
+			m.WithError(ErrClusterClosed)
+		}
 		return ErrClusterClosed
 	}
 	for _, m := range msgs {
+		if sc, ok := m.Request().(proto.Scanner); ok && sc.IsScan() {
+			c.scan(m, sc)
+			continue
+		}
+		if bc, ok := m.Request().(proto.Broadcaster); ok && bc.IsBroadcast() {
+			c.broadcast(m, bc)
+			continue
+		}
+		if mk, ok := m.Request().(proto.MultiKeyer); ok && !m.IsBatch() {
+			if keys := mk.Keys(); len(keys) > 1 && !c.sameSlot(keys) {
+				mk.WithCrossSlot()
+				continue
+			}
+		}
 		if m.IsBatch() {
 			for _, subm := range m.Batch() {
-				ncp := c.getPipe(subm.Request().Key())
+				ncp := c.getPipe(subm.Request())
 				ncp.Push(subm)
 			}
 		} else {
-			ncp := c.getPipe(m.Request().Key())
+			ncp := c.getPipe(m.Request())
 			ncp.Push(m)
 		}
 	}
 	return nil
 }
 
+// Update fetch the cluster nodes by the new seed servers, and the seeds are replaced by the masters fetched.
+// NOTE: the seeds are kept when all the new seed servers fail to fetch.
+func (c *cluster) Update(servers []string) error {
+	if state := atomic.LoadInt32(&c.state); state == closed {
+		return ErrClusterClosed
+	}
+	if !c.tryFetch(servers) {
+		return ErrClusterFetch
+	}
+	return nil
+}
+
+// Close stop fetching the cluster nodes and close all the node pipes.
 func (c *cluster) Close() error {
 	if !atomic.CompareAndSwapInt32(&c.state, opening, closed) {
 		return nil
 	}
+	close(c.done)
+	c.lock.Lock()
+	if sn, ok := c.slotNode.Load().(*slotNode); ok && sn != nil {
+		for _, ncp := range sn.nodePipe {
+			ncp.Close()
+		}
+	}
+	c.lock.Unlock()
 	return nil
 }
 
-func (c *cluster) getPipe(key []byte) (ncp *proto.NodeConnPipe) {
-	realKey := c.trimHashTag(key)
+// State returns the snapshot of nodes and the slot map.
+func (c *cluster) State() *proto.ForwarderState {
+	st := &proto.ForwarderState{}
+	sn, ok := c.slotNode.Load().(*slotNode)
+	if !ok || sn == nil {
+		return st
+	}
+	addrs := make([]string, 0, len(sn.nodePipe))
+	for addr := range sn.nodePipe {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	for _, addr := range addrs {
+		role := roleMaster
+		if _, ok := sn.replicas[addr]; ok {
+			role = roleReplica
+		}
+		ncp := sn.nodePipe[addr]
+		ns := &proto.NodeState{Name: addr, Addr: addr, Role: role, Conns: ncp.Conns()}
+		if c.bc != nil {
+			ns.Breaker = ncp.BreakerState().String()
+		}
+		st.Nodes = append(st.Nodes, ns)
+	}
+	var sr *proto.SlotRange
+	for slot, addr := range sn.nSlots.slots {
+		if sr != nil && sr.Node == addr && sr.End == slot-1 {
+			sr.End = slot
+			continue
+		}
+		if addr == "" {
+			sr = nil
+			continue
+		}
+		sr = &proto.SlotRange{Start: slot, End: slot, Node: addr}
+		for _, raddr := range sn.reads[slot] {
+			if raddr != addr {
+				sr.Replicas = append(sr.Replicas, raddr)
+			}
+		}
+		st.Slots = append(st.Slots, sr)
+	}
+	return st
+}
+
+// broadcast forward the copies of message to all the masters.
+func (c *cluster) broadcast(m *proto.Message, bc proto.Broadcaster) {
+	sn := c.slotNode.Load().(*slotNode)
+	masters := sn.nSlots.getMasters()
+	if len(masters) == 0 {
+		m.WithError(ErrClusterNoMaster)
+		return
+	}
+	sort.Strings(masters)
+	bc.Broadcast(m, masters)
+	if !m.IsBatch() {
+		sn.nodePipe[masters[0]].Push(m)
+		return
+	}
+	for idx, subm := range m.Batch() {
+		sn.nodePipe[masters[idx]].Push(subm)
+	}
+}
+
+// scan forward the message to the master of cursor in addr order.
+// NOTE: the scan may miss or repeat keys when the masters changed during the scan.
+func (c *cluster) scan(m *proto.Message, sc proto.Scanner) {
+	sn := c.slotNode.Load().(*slotNode)
+	masters := sn.nSlots.getMasters()
+	if len(masters) == 0 {
+		m.WithError(ErrClusterNoMaster)
+		return
+	}
+	sort.Strings(masters)
+	idx, ok := sc.Scan(len(masters))
+	if !ok {
+		return
+	}
+	sn.nodePipe[masters[idx]].Push(m)
+}
+
+// KeyNode impl proto.Router, the channel is subscribed on the master of slot like key to spread the subscriptions,
+// for the messages published to any node are broadcast by redis cluster.
+func (c *cluster) KeyNode(key []byte) (string, bool) {
+	sn := c.slotNode.Load().(*slotNode)
+	addr := sn.nSlots.slots[hashkit.Crc16(c.trimHashTag(key))&musk]
+	return addr, addr != ""
+}
+
+// PatternNodes impl proto.Router, the patterns are subscribed on the first master in addr order only,
+// for the messages published to any node are broadcast by redis cluster.
+func (c *cluster) PatternNodes() []string {
+	sn := c.slotNode.Load().(*slotNode)
+	masters := sn.nSlots.getMasters()
+	if len(masters) == 0 {
+		return nil
+	}
+	sort.Strings(masters)
+	return masters[:1]
+}
+
+// SameNode impl proto.Router, the keys must be in the same slot.
+func (c *cluster) SameNode(keys [][]byte) bool {
+	return c.sameSlot(keys)
+}
+
+// Dial impl proto.Router.
+func (c *cluster) Dial(addr string) (*libnet.Conn, error) {
+	conn := libnet.DialWithTimeout(addr, c.dto, c.rto, c.wto)
+	if err := redis.Auth(conn, c.auth); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (c *cluster) getPipe(req proto.Request) (ncp *proto.NodeConnPipe) {
+	realKey := c.trimHashTag(req.Key())
 	crc := hashkit.Crc16(realKey) & musk
 	sn := c.slotNode.Load().(*slotNode)
 	addr := sn.nSlots.slots[crc]
+	if reads := sn.reads[crc]; len(reads) != 0 {
+		if rreq, ok := req.(*redis.Request); ok && rreq.IsReadOnly() {
+			addr = reads[atomic.AddUint32(&c.rr, 1)%uint32(len(reads))]
+		}
+	}
 	ncp = sn.nodePipe[addr]
 	return
 }
 
+// sameSlot check all the keys whether in the same slot.
+func (c *cluster) sameSlot(keys [][]byte) bool {
+	first := hashkit.Crc16(c.trimHashTag(keys[0])) & musk
+	for _, key := range keys[1:] {
+		if hashkit.Crc16(c.trimHashTag(key))&musk != first {
+			return false
+		}
+	}
+	return true
+}
+
+// replicaReadable check whether the read-only requests can be forwarded to the replicas.
+func (c *cluster) replicaReadable() bool {
+	return c.readPolicy == proto.ReadPolicyPreferReplica || c.readPolicy == proto.ReadPolicyRoundRobin
+}
+
 func (c *cluster) trimHashTag(key []byte) []byte {
 	if len(c.hashTag) != 2 {
 		return key
@@ -132,21 +326,38 @@ func (c *cluster) fetchproc() {
 		select {
 		case <-c.action:
 		case <-time.After(1 * time.Minute):
+		case <-c.done:
+			return
 		}
-		c.tryFetch()
+		c.tryFetch(c.seeds())
 	}
 }
 
-func (c *cluster) tryFetch() bool {
+// seeds returns the seed servers, which are replaced by the masters when fetched.
+func (c *cluster) seeds() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.servers
+}
+
+func (c *cluster) tryFetch(servers []string) bool {
 	// for map's access is random in golang.
 	shuffleMap := make(map[string]struct{})
-	for _, server := range c.servers {
+	for _, server := range servers {
 		shuffleMap[server] = struct{}{}
 	}
 	for server := range shuffleMap {
 		conn := libnet.DialWithTimeout(server, c.dto, c.rto, c.wto)
+		if err := redis.Auth(conn, c.auth); err != nil {
+			_ = conn.Close()
+			if log.V(1) {
+				log.Errorf("Redis Cluster fail to auth server:%s error:%v", server, err)
+			}
+			continue
+		}
 		f := newFetcher(conn)
 		nSlots, err := f.fetch()
+		_ = f.Close()
 		if err != nil {
 			if log.V(1) {
 				log.Errorf("Redis Cluster fail to fetch error:%v", err)
@@ -166,6 +377,11 @@ func (c *cluster) tryFetch() bool {
 }
 
 func (c *cluster) initSlotNode(nSlots *nodeSlots) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if atomic.LoadInt32(&c.state) == closed {
+		return
+	}
 	osn, ok := c.slotNode.Load().(*slotNode) // old slotNode
 	oncp := map[string]*proto.NodeConnPipe{} // old nodeConn
 	if ok && osn != nil {
@@ -176,11 +392,18 @@ func (c *cluster) initSlotNode(nSlots *nodeSlots) {
 	sn := &slotNode{nSlots: nSlots}
 	sn.nodePipe = make(map[string]*proto.NodeConnPipe)
 	masters := nSlots.getMasters()
-	for _, addr := range masters {
+	addrs := append([]string{}, masters...)
+	if c.replicaReadable() {
+		sn.reads, sn.replicas = readRoutes(nSlots, c.readPolicy)
+		for addr := range sn.replicas {
+			addrs = append(addrs, addr)
+		}
+	}
+	for _, addr := range addrs {
 		ncp, ok := oncp[addr]
 		if !ok {
 			toAddr := addr // NOTE: avoid closure
-			ncp = proto.NewNodeConnPipe(c.conns, func() proto.NodeConn {
+			ncp = proto.NewNodeConnPipe(c.name, addr, c.conns, c.bc, func() proto.NodeConn {
 				return newNodeConn(c, toAddr)
 			})
 			go c.pipeEvent(ncp.ErrorEvent())
@@ -214,7 +437,11 @@ func (c *cluster) pipeEvent(errCh <-chan error) {
 		if log.V(2) {
 			log.Errorf("Redis Cluster NodeConnPipe action error:%v", err)
 		}
-		c.action <- struct{}{}
+		select {
+		case c.action <- struct{}{}:
+		case <-c.done:
+			return
+		}
 	}
 }
 
@@ -258,5 +485,43 @@ func (c *cluster) fake(listen string) {
 
 type slotNode struct {
 	nSlots   *nodeSlots
-	nodePipe map[string]*proto.NodeConnPipe
+	nodePipe map[string]*proto.NodeConnPipe // masters and replicas
+	// reads is the candidate nodes of read-only requests by slot, nil means the master.
+	reads    [][]string
+	replicas map[string]struct{}
+}
+
+// readRoutes returns the candidate nodes of read-only requests by slot and all the replicas used.
+// The replicas which are failed or disconnected are never used.
+func readRoutes(nSlots *nodeSlots, rp proto.ReadPolicy) (reads [][]string, replicas map[string]struct{}) {
+	reads = make([][]string, slotsCount)
+	replicas = make(map[string]struct{})
+	cache := make(map[string][]string) // NOTE: the slots of the same master share the candidates
+	for slot, master := range nSlots.slots {
+		if master == "" {
+			continue
+		}
+		if cands, ok := cache[master]; ok {
+			reads[slot] = cands
+			continue
+		}
+		var cands []string
+		if rp == proto.ReadPolicyRoundRobin {
+			cands = append(cands, master)
+		}
+		for _, addr := range nSlots.slaveSlots[slot] {
+			if n, ok := nSlots.nodes[addr]; !ok || !n.isNormal() {
+				continue
+			}
+			cands = append(cands, addr)
+			replicas[addr] = struct{}{}
+		}
+		if len(cands) == 1 && cands[0] == master {
+			cands = nil
+		}
+		sort.Strings(cands)
+		cache[master] = cands
+		reads[slot] = cands
+	}
+	return
 }
@@ -0,0 +1,53 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"overlord/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var replicaNodes = []byte("" +
+	"6b22f87b78cdb181f7b9b1e0298da177606394f7 172.17.0.2:7003@17003 slave 8f02f3135c65482ac00f217df0edb6b9702691f8 0 1532770704000 4 connected\n" +
+	"dff2f7b0fbda82c72d426eeb9616d9d6455bb4ff 172.17.0.2:7004@17004 slave,fail 828c400ea2b55c43e5af67af94bec4943b7b3d93 0 1532770704538 5 connected\n" +
+	"b1798ba2171a4bd765846ddb5d5bdc9f3ca6fdf3 172.17.0.2:7000@17000 master - 0 1532770705458 1 connected 0-5460\n" +
+	"db2dd7d6fbd2a03f16f6ab61d0576edc9c3b04e2 172.17.0.2:7005@17005 slave b1798ba2171a4bd765846ddb5d5bdc9f3ca6fdf3 0 1532770704437 6 connected\n" +
+	"828c400ea2b55c43e5af67af94bec4943b7b3d93 172.17.0.2:7002@17002 master - 0 1532770704000 3 connected 10923-16383\n" +
+	"8f02f3135c65482ac00f217df0edb6b9702691f8 172.17.0.2:7001@17001 myself,master - 0 1532770703000 2 connected 5461-10922\n")
+
+func TestReadRoutesPreferReplica(t *testing.T) {
+	ns, err := parseSlots(replicaNodes)
+	assert.NoError(t, err)
+	reads, replicas := readRoutes(ns, proto.ReadPolicyPreferReplica)
+	assert.Len(t, replicas, 2)
+	assert.Equal(t, []string{"172.17.0.2:7005"}, reads[0])
+	assert.Equal(t, []string{"172.17.0.2:7003"}, reads[5461])
+	// NOTE: the only replica of 7002 is failed, reads go to master.
+	assert.Nil(t, reads[16383])
+}
+
+func TestReadRoutesRoundRobin(t *testing.T) {
+	ns, err := parseSlots(replicaNodes)
+	assert.NoError(t, err)
+	reads, replicas := readRoutes(ns, proto.ReadPolicyRoundRobin)
+	assert.Len(t, replicas, 2)
+	assert.Equal(t, []string{"172.17.0.2:7000", "172.17.0.2:7005"}, reads[5460])
+	assert.Equal(t, []string{"172.17.0.2:7001", "172.17.0.2:7003"}, reads[10922])
+	assert.Nil(t, reads[10923])
+}
+
+func TestSameSlot(t *testing.T) {
+	c := &cluster{hashTag: []byte("{}")}
+	assert.True(t, c.sameSlot([][]byte{[]byte("{user1}.a"), []byte("{user1}.b")}))
+	assert.False(t, c.sameSlot([][]byte{[]byte("user1.a"), []byte("user1.b")}))
+}
+
+func TestUpdateFetchFailed(t *testing.T) {
+	c := &cluster{servers: []string{"127.0.0.1:7000"}, dto: 100 * time.Millisecond, rto: 100 * time.Millisecond, wto: 100 * time.Millisecond}
+	assert.Equal(t, ErrClusterFetch, c.Update([]string{"127.0.0.1:1"}))
+	assert.Equal(t, []string{"127.0.0.1:7000"}, c.seeds(), "the seeds are kept when fail to fetch")
+	c.state = closed
+	assert.Equal(t, ErrClusterClosed, c.Update([]string{"127.0.0.1:1"}))
+}
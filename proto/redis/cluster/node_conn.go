@@ -7,6 +7,7 @@ import (
 
 	"overlord/lib/conv"
 	"overlord/lib/log"
+	"overlord/lib/prom"
 	"overlord/proto"
 	"overlord/proto/redis"
 
@@ -25,8 +26,9 @@ var (
 )
 
 type nodeConn struct {
-	c  *cluster
-	nc proto.NodeConn
+	c    *cluster
+	addr string
+	nc   proto.NodeConn
 
 	sb strings.Builder
 
@@ -34,9 +36,14 @@ type nodeConn struct {
 }
 
 func newNodeConn(c *cluster, addr string) (nc proto.NodeConn) {
+	rnc := redis.NewNodeConn
+	if c.replicaReadable() {
+		rnc = redis.NewReadOnlyNodeConn // NOTE: READONLY has no effect on master
+	}
 	nc = &nodeConn{
-		c:  c,
-		nc: redis.NewNodeConn(c.name, addr, c.dto, c.rto, c.wto),
+		c:    c,
+		addr: addr,
+		nc:   rnc(c.name, addr, c.auth, c.dto, c.rto, c.wto),
 	}
 	return
 }
@@ -59,7 +66,7 @@ func (nc *nodeConn) Read(m *proto.Message) (err error) {
 	}
 	req := m.Request().(*redis.Request)
 	// check request
-	if !req.IsSupport() || req.IsCtl() {
+	if !req.IsForward() {
 		return
 	}
 	reply := req.Reply()
@@ -74,6 +81,13 @@ func (nc *nodeConn) Read(m *proto.Message) (err error) {
 	nc.sb.Reset()
 	nc.sb.Write(addrBs)
 	addr := nc.sb.String()
+	if prom.On {
+		if isAsk {
+			prom.RedirectIncr(nc.c.name, nc.addr, "ASK")
+		} else {
+			prom.RedirectIncr(nc.c.name, nc.addr, "MOVED")
+		}
+	}
 	// redirect process
 	if err = nc.redirectProcess(m, req, addr, isAsk); err != nil && log.V(2) {
 		log.Errorf("Redis Cluster NodeConn redirectProcess addr:%s error:%v", addr, err)
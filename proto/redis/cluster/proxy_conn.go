@@ -30,14 +30,16 @@ type proxyConn struct {
 }
 
 // NewProxyConn creates new redis cluster Encoder and Decoder.
-func NewProxyConn(conn *libnet.Conn, fer proto.Forwarder) proto.ProxyConn {
+// When password is not empty, client must AUTH before any other command.
+// When msetDetail is true, the error of MSET partially failed contains all the failed keys and their errors.
+func NewProxyConn(conn *libnet.Conn, fer proto.Forwarder, password string, msetDetail bool) proto.ProxyConn {
 	var c *cluster
 	if fer != nil {
 		c = fer.(*cluster)
 	}
 	r := &proxyConn{
 		c:  c,
-		pc: redis.NewProxyConn(conn),
+		pc: redis.NewProxyConn(conn, password, msetDetail),
 	}
 	return r
 }
@@ -46,10 +48,35 @@ func (pc *proxyConn) Decode(msgs []*proto.Message) ([]*proto.Message, error) {
 	return pc.pc.Decode(msgs)
 }
 
+// WithRouter impl proto.Pinner.
+func (pc *proxyConn) WithRouter(router proto.Router) {
+	pc.pc.(proto.Pinner).WithRouter(router)
+}
+
+// Interrupt impl proto.Pinner.
+func (pc *proxyConn) Interrupt() {
+	pc.pc.(proto.Pinner).Interrupt()
+}
+
+// Close impl proto.Pinner.
+func (pc *proxyConn) Close() error {
+	return pc.pc.(proto.Pinner).Close()
+}
+
+// IsSubscribing impl proto.Subscriber.
+func (pc *proxyConn) IsSubscribing() bool {
+	return pc.pc.(proto.Subscriber).IsSubscribing()
+}
+
+// Subscribe impl proto.Subscriber.
+func (pc *proxyConn) Subscribe() error {
+	return pc.pc.(proto.Subscriber).Subscribe()
+}
+
 func (pc *proxyConn) Encode(m *proto.Message) (err error) {
 	if !m.IsBatch() {
 		req := m.Request().(*redis.Request)
-		if !req.IsSupport() && !req.IsCtl() {
+		if !req.IsSupport() && !req.IsCtl() && !req.IsLocal() {
 			resp := req.RESP()
 			arr := resp.Array()
 			if bytes.Equal(arr[0].Data(), cmdClusterBytes) {
@@ -18,6 +18,8 @@ var (
 	roleMyself = "myself"
 	roleMaster = "master"
 	roleSlave  = "slave"
+
+	roleReplica = "replica"
 )
 
 // parseSlots must be call as "CLSUTER NODES" response.
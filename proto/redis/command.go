@@ -0,0 +1,290 @@
+package redis
+
+import (
+	"unsafe"
+
+	"overlord/lib/conv"
+)
+
+// cmdFlag is the flags of command like the COMMAND of redis.
+type cmdFlag uint8
+
+// command flags
+const (
+	cmdRead      cmdFlag = 1 << iota // NOTE: only reads the data, which can be served by replica
+	cmdWrite                         // NOTE: may modify the data
+	cmdControl                       // NOTE: replied by proxy
+	cmdBroadcast                     // NOTE: sent to all the nodes and the replies are merged by the merge type
+	cmdSplit                         // NOTE: split into the commands of every key by step, and the replies are merged by the merge type
+	cmdBlocking                      // NOTE: sent by the temporary dedicated conn, never block the shared conns to nodes
+)
+
+// keyPos is the positions of keys in the arguments of command like the COMMAND of redis,
+// the keys are from first to last by step and the negative last is counted from the end,
+// and the keys follow the numkeys argument when numkeys is not 0, like ZINTERSTORE and EVAL.
+type keyPos struct {
+	first, last, step int
+	numkeys           int
+}
+
+// the key positions of most commands.
+var (
+	noKey    = keyPos{}
+	oneKey   = keyPos{first: 1, last: 1, step: 1}
+	twoKeys  = keyPos{first: 1, last: 2, step: 1}
+	allKeys  = keyPos{first: 1, last: -1, step: 1}
+	pairKeys = keyPos{first: 1, last: -1, step: 2} // NOTE: the keys and the values
+)
+
+// firstKey returns the position of first key.
+func (kp keyPos) firstKey() int {
+	if kp.first > 0 {
+		return kp.first
+	}
+	return kp.numkeys + 1
+}
+
+// isMulti check the command whether may contain more than one key.
+func (kp keyPos) isMulti() bool {
+	return kp.first != kp.last || kp.numkeys > 0
+}
+
+// keys returns all the keys in the arguments of command, nil when the arguments are bad.
+func (kp keyPos) keys(re *resp) (keys [][]byte) {
+	if kp.first > 0 {
+		last := kp.last
+		if last < 0 {
+			last += re.arrayn
+		}
+		for i := kp.first; i <= last && i < re.arrayn; i += kp.step {
+			keys = append(keys, bulkValue(re.array[i].data))
+		}
+	}
+	if kp.numkeys > 0 && kp.numkeys < re.arrayn {
+		n, err := conv.Btoi(bulkValue(re.array[kp.numkeys].data))
+		if err != nil || n < 0 || kp.numkeys+int(n) >= re.arrayn {
+			return nil
+		}
+		for i := kp.numkeys + 1; i <= kp.numkeys+int(n); i++ {
+			keys = append(keys, bulkValue(re.array[i].data))
+		}
+	}
+	return
+}
+
+// command is the metadata of redis command.
+type command struct {
+	flags cmdFlag
+	kp    keyPos
+	mType mergeType
+	split []byte   // NOTE: the command of every key split, the same as the command when nil
+	reply respType // NOTE: the RESP3 type which the reply of node is upgraded into for RESP3 client
+}
+
+// is check the command whether has the flag.
+func (c command) is(flag cmdFlag) bool {
+	return c.flags&flag != 0
+}
+
+// lookup the metadata of command, false means not supported.
+func lookup(cmd []byte) (c command, ok bool) {
+	c, ok = commands[*((*string)(unsafe.Pointer(&cmd)))]
+	return
+}
+
+var (
+	// commands are all the commands supported by proxy, the others are replied not support.
+	// NOTE: the transactions and pub/sub are served by proxyConn, MIGRATE, MOVE, OBJECT, RANDOMKEY, WAIT, ECHO,
+	// SLOWLOG, SELECT, TIME and CONFIG are never supported.
+	commands = map[string]command{
+		// control
+		"4\r\nQUIT":  {flags: cmdControl, kp: noKey},
+		"4\r\nPING":  {flags: cmdControl, kp: noKey},
+		"4\r\nAUTH":  {flags: cmdControl, kp: noKey},
+		"5\r\nHELLO": {flags: cmdControl, kp: noKey, reply: respMap},
+
+		// keys
+		"3\r\nDEL":       {flags: cmdWrite | cmdSplit, kp: allKeys, mType: mergeTypeCount},
+		"6\r\nUNLINK":    {flags: cmdWrite | cmdSplit, kp: allKeys, mType: mergeTypeCount},
+		"6\r\nEXISTS":    {flags: cmdRead | cmdSplit, kp: allKeys, mType: mergeTypeCount},
+		"5\r\nTOUCH":     {flags: cmdRead | cmdSplit, kp: allKeys, mType: mergeTypeCount},
+		"4\r\nDUMP":      {flags: cmdRead, kp: oneKey},
+		"4\r\nPTTL":      {flags: cmdRead, kp: oneKey},
+		"3\r\nTTL":       {flags: cmdRead, kp: oneKey},
+		"4\r\nTYPE":      {flags: cmdRead, kp: oneKey},
+		"6\r\nEXPIRE":    {flags: cmdWrite, kp: oneKey},
+		"8\r\nEXPIREAT":  {flags: cmdWrite, kp: oneKey},
+		"7\r\nPERSIST":   {flags: cmdWrite, kp: oneKey},
+		"7\r\nPEXPIRE":   {flags: cmdWrite, kp: oneKey},
+		"9\r\nPEXPIREAT": {flags: cmdWrite, kp: oneKey},
+		"7\r\nRESTORE":   {flags: cmdWrite, kp: oneKey},
+		"4\r\nSORT":      {flags: cmdWrite, kp: oneKey},
+		"6\r\nRENAME":    {flags: cmdWrite, kp: twoKeys},
+		"8\r\nRENAMENX":  {flags: cmdWrite, kp: twoKeys},
+		"4\r\nSCAN":      {flags: cmdRead, kp: noKey},
+
+		// strings
+		"3\r\nGET":          {flags: cmdRead, kp: oneKey},
+		"4\r\nMGET":         {flags: cmdRead | cmdSplit, kp: allKeys, mType: mergeTypeJoin, split: cmdGetBytes},
+		"3\r\nSET":          {flags: cmdWrite, kp: oneKey},
+		"4\r\nMSET":         {flags: cmdWrite | cmdSplit, kp: pairKeys, mType: mergeTypeOK},
+		"6\r\nMSETNX":       {flags: cmdWrite, kp: pairKeys},
+		"6\r\nGETSET":       {flags: cmdWrite, kp: oneKey},
+		"6\r\nGETDEL":       {flags: cmdWrite, kp: oneKey},
+		"5\r\nGETEX":        {flags: cmdWrite, kp: oneKey},
+		"8\r\nGETRANGE":     {flags: cmdRead, kp: oneKey},
+		"6\r\nSTRLEN":       {flags: cmdRead, kp: oneKey},
+		"6\r\nAPPEND":       {flags: cmdWrite, kp: oneKey},
+		"4\r\nDECR":         {flags: cmdWrite, kp: oneKey},
+		"6\r\nDECRBY":       {flags: cmdWrite, kp: oneKey},
+		"4\r\nINCR":         {flags: cmdWrite, kp: oneKey},
+		"6\r\nINCRBY":       {flags: cmdWrite, kp: oneKey},
+		"11\r\nINCRBYFLOAT": {flags: cmdWrite, kp: oneKey},
+		"6\r\nPSETEX":       {flags: cmdWrite, kp: oneKey},
+		"5\r\nSETEX":        {flags: cmdWrite, kp: oneKey},
+		"5\r\nSETNX":        {flags: cmdWrite, kp: oneKey},
+		"8\r\nSETRANGE":     {flags: cmdWrite, kp: oneKey},
+
+		// bits
+		"8\r\nBITCOUNT":     {flags: cmdRead, kp: oneKey},
+		"6\r\nBITPOS":       {flags: cmdRead, kp: oneKey},
+		"6\r\nGETBIT":       {flags: cmdRead, kp: oneKey},
+		"6\r\nSETBIT":       {flags: cmdWrite, kp: oneKey},
+		"8\r\nBITFIELD":     {flags: cmdWrite, kp: oneKey},
+		"11\r\nBITFIELD_RO": {flags: cmdRead, kp: oneKey},
+		"5\r\nBITOP":        {flags: cmdWrite, kp: keyPos{first: 2, last: -1, step: 1}},
+
+		// hashes
+		"4\r\nHDEL":          {flags: cmdWrite, kp: oneKey},
+		"7\r\nHEXISTS":       {flags: cmdRead, kp: oneKey},
+		"4\r\nHGET":          {flags: cmdRead, kp: oneKey},
+		"7\r\nHGETALL":       {flags: cmdRead, kp: oneKey, reply: respMap},
+		"7\r\nHINCRBY":       {flags: cmdWrite, kp: oneKey},
+		"12\r\nHINCRBYFLOAT": {flags: cmdWrite, kp: oneKey},
+		"5\r\nHKEYS":         {flags: cmdRead, kp: oneKey},
+		"4\r\nHLEN":          {flags: cmdRead, kp: oneKey},
+		"5\r\nHMGET":         {flags: cmdRead, kp: oneKey},
+		"5\r\nHMSET":         {flags: cmdWrite, kp: oneKey},
+		"4\r\nHSET":          {flags: cmdWrite, kp: oneKey},
+		"6\r\nHSETNX":        {flags: cmdWrite, kp: oneKey},
+		"7\r\nHSTRLEN":       {flags: cmdRead, kp: oneKey},
+		"5\r\nHVALS":         {flags: cmdRead, kp: oneKey},
+		"5\r\nHSCAN":         {flags: cmdRead, kp: oneKey},
+		"10\r\nHRANDFIELD":   {flags: cmdRead, kp: oneKey},
+
+		// lists
+		"6\r\nLINDEX":      {flags: cmdRead, kp: oneKey},
+		"4\r\nLLEN":        {flags: cmdRead, kp: oneKey},
+		"6\r\nLRANGE":      {flags: cmdRead, kp: oneKey},
+		"4\r\nLPOS":        {flags: cmdRead, kp: oneKey},
+		"7\r\nLINSERT":     {flags: cmdWrite, kp: oneKey},
+		"4\r\nLPOP":        {flags: cmdWrite, kp: oneKey},
+		"5\r\nLPUSH":       {flags: cmdWrite, kp: oneKey},
+		"6\r\nLPUSHX":      {flags: cmdWrite, kp: oneKey},
+		"4\r\nLREM":        {flags: cmdWrite, kp: oneKey},
+		"4\r\nLSET":        {flags: cmdWrite, kp: oneKey},
+		"5\r\nLTRIM":       {flags: cmdWrite, kp: oneKey},
+		"4\r\nRPOP":        {flags: cmdWrite, kp: oneKey},
+		"5\r\nRPUSH":       {flags: cmdWrite, kp: oneKey},
+		"6\r\nRPUSHX":      {flags: cmdWrite, kp: oneKey},
+		"9\r\nRPOPLPUSH":   {flags: cmdWrite, kp: twoKeys},
+		"5\r\nBLPOP":       {flags: cmdWrite | cmdBlocking, kp: keyPos{first: 1, last: -2, step: 1}},
+		"5\r\nBRPOP":       {flags: cmdWrite | cmdBlocking, kp: keyPos{first: 1, last: -2, step: 1}},
+		"10\r\nBRPOPLPUSH": {flags: cmdWrite | cmdBlocking, kp: twoKeys},
+
+		// sets
+		"4\r\nSADD":         {flags: cmdWrite, kp: oneKey},
+		"5\r\nSCARD":        {flags: cmdRead, kp: oneKey},
+		"9\r\nSISMEMBER":    {flags: cmdRead, kp: oneKey},
+		"10\r\nSMISMEMBER":  {flags: cmdRead, kp: oneKey},
+		"8\r\nSMEMBERS":     {flags: cmdRead, kp: oneKey, reply: respSet},
+		"11\r\nSRANDMEMBER": {flags: cmdRead, kp: oneKey},
+		"5\r\nSSCAN":        {flags: cmdRead, kp: oneKey},
+		"4\r\nSPOP":         {flags: cmdWrite, kp: oneKey},
+		"4\r\nSREM":         {flags: cmdWrite, kp: oneKey},
+		"5\r\nSMOVE":        {flags: cmdWrite, kp: twoKeys},
+		"5\r\nSDIFF":        {flags: cmdRead, kp: allKeys, reply: respSet},
+		"6\r\nSINTER":       {flags: cmdRead, kp: allKeys, reply: respSet},
+		"6\r\nSUNION":       {flags: cmdRead, kp: allKeys, reply: respSet},
+		"10\r\nSDIFFSTORE":  {flags: cmdWrite, kp: allKeys},
+		"11\r\nSINTERSTORE": {flags: cmdWrite, kp: allKeys},
+		"11\r\nSUNIONSTORE": {flags: cmdWrite, kp: allKeys},
+
+		// sorted sets
+		"4\r\nZADD":              {flags: cmdWrite, kp: oneKey},
+		"5\r\nZCARD":             {flags: cmdRead, kp: oneKey},
+		"6\r\nZCOUNT":            {flags: cmdRead, kp: oneKey},
+		"7\r\nZINCRBY":           {flags: cmdWrite, kp: oneKey, reply: respDouble},
+		"9\r\nZLEXCOUNT":         {flags: cmdRead, kp: oneKey},
+		"6\r\nZRANGE":            {flags: cmdRead, kp: oneKey},
+		"11\r\nZRANGEBYLEX":      {flags: cmdRead, kp: oneKey},
+		"13\r\nZRANGEBYSCORE":    {flags: cmdRead, kp: oneKey},
+		"5\r\nZRANK":             {flags: cmdRead, kp: oneKey},
+		"9\r\nZREVRANGE":         {flags: cmdRead, kp: oneKey},
+		"14\r\nZREVRANGEBYLEX":   {flags: cmdRead, kp: oneKey},
+		"16\r\nZREVRANGEBYSCORE": {flags: cmdRead, kp: oneKey},
+		"8\r\nZREVRANK":          {flags: cmdRead, kp: oneKey},
+		"6\r\nZSCORE":            {flags: cmdRead, kp: oneKey, reply: respDouble},
+		"7\r\nZMSCORE":           {flags: cmdRead, kp: oneKey},
+		"5\r\nZSCAN":             {flags: cmdRead, kp: oneKey},
+		"11\r\nZRANDMEMBER":      {flags: cmdRead, kp: oneKey},
+		"4\r\nZREM":              {flags: cmdWrite, kp: oneKey},
+		"14\r\nZREMRANGEBYLEX":   {flags: cmdWrite, kp: oneKey},
+		"15\r\nZREMRANGEBYRANK":  {flags: cmdWrite, kp: oneKey},
+		"16\r\nZREMRANGEBYSCORE": {flags: cmdWrite, kp: oneKey},
+		"7\r\nZPOPMIN":           {flags: cmdWrite, kp: oneKey},
+		"7\r\nZPOPMAX":           {flags: cmdWrite, kp: oneKey},
+		"8\r\nBZPOPMIN":          {flags: cmdWrite | cmdBlocking, kp: keyPos{first: 1, last: -2, step: 1}},
+		"8\r\nBZPOPMAX":          {flags: cmdWrite | cmdBlocking, kp: keyPos{first: 1, last: -2, step: 1}},
+		"11\r\nZINTERSTORE":      {flags: cmdWrite, kp: keyPos{first: 1, last: 1, step: 1, numkeys: 2}},
+		"11\r\nZUNIONSTORE":      {flags: cmdWrite, kp: keyPos{first: 1, last: 1, step: 1, numkeys: 2}},
+
+		// hyperloglogs
+		"5\r\nPFADD":   {flags: cmdWrite, kp: oneKey},
+		"7\r\nPFCOUNT": {flags: cmdRead, kp: allKeys},
+		"7\r\nPFMERGE": {flags: cmdWrite, kp: allKeys},
+
+		// geo
+		"6\r\nGEOADD":                {flags: cmdWrite, kp: oneKey},
+		"7\r\nGEODIST":               {flags: cmdRead, kp: oneKey},
+		"7\r\nGEOHASH":               {flags: cmdRead, kp: oneKey},
+		"6\r\nGEOPOS":                {flags: cmdRead, kp: oneKey},
+		"9\r\nGEORADIUS":             {flags: cmdWrite, kp: oneKey}, // NOTE: write for STORE
+		"17\r\nGEORADIUSBYMEMBER":    {flags: cmdWrite, kp: oneKey},
+		"12\r\nGEORADIUS_RO":         {flags: cmdRead, kp: oneKey},
+		"20\r\nGEORADIUSBYMEMBER_RO": {flags: cmdRead, kp: oneKey},
+		"9\r\nGEOSEARCH":             {flags: cmdRead, kp: oneKey},
+		"14\r\nGEOSEARCHSTORE":       {flags: cmdWrite, kp: twoKeys},
+
+		// streams
+		"4\r\nXADD":        {flags: cmdWrite, kp: oneKey},
+		"4\r\nXLEN":        {flags: cmdRead, kp: oneKey},
+		"6\r\nXRANGE":      {flags: cmdRead, kp: oneKey},
+		"9\r\nXREVRANGE":   {flags: cmdRead, kp: oneKey},
+		"4\r\nXDEL":        {flags: cmdWrite, kp: oneKey},
+		"5\r\nXTRIM":       {flags: cmdWrite, kp: oneKey},
+		"4\r\nXACK":        {flags: cmdWrite, kp: oneKey},
+		"6\r\nXCLAIM":      {flags: cmdWrite, kp: oneKey},
+		"10\r\nXAUTOCLAIM": {flags: cmdWrite, kp: oneKey},
+		"8\r\nXPENDING":    {flags: cmdRead, kp: oneKey},
+		"6\r\nXSETID":      {flags: cmdWrite, kp: oneKey},
+		"6\r\nXGROUP":      {flags: cmdWrite, kp: keyPos{first: 2, last: 2, step: 1}},
+		"5\r\nXINFO":       {flags: cmdRead, kp: keyPos{first: 2, last: 2, step: 1}},
+		"5\r\nXREAD":       {flags: cmdRead | cmdBlocking, kp: noKey}, // NOTE: the keys follow STREAMS
+		"10\r\nXREADGROUP": {flags: cmdWrite | cmdBlocking, kp: noKey},
+
+		// scripting and pub/sub
+		"4\r\nEVAL":    {flags: cmdWrite, kp: keyPos{numkeys: 2}},
+		"7\r\nEVALSHA": {flags: cmdWrite, kp: keyPos{numkeys: 2}},
+		"7\r\nPUBLISH": {flags: cmdWrite, kp: oneKey}, // NOTE: the channel is routed as key
+
+		// broadcast
+		"6\r\nDBSIZE":   {flags: cmdBroadcast, kp: noKey, mType: mergeTypeCount},
+		"4\r\nKEYS":     {flags: cmdBroadcast, kp: noKey, mType: mergeTypeConcat},
+		"7\r\nFLUSHDB":  {flags: cmdBroadcast, kp: noKey, mType: mergeTypeOK},
+		"8\r\nFLUSHALL": {flags: cmdBroadcast, kp: noKey, mType: mergeTypeOK},
+		"4\r\nINFO":     {flags: cmdBroadcast, kp: noKey, mType: mergeTypeSection},
+		"6\r\nSCRIPT":   {flags: cmdBroadcast, kp: noKey, mType: mergeTypeFirst}, // NOTE: LOAD replies the same sha1, FLUSH replies OK, EXISTS is merged by all
+
+	}
+)
@@ -0,0 +1,88 @@
+package redis
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"strconv"
+)
+
+var (
+	cmdHelloBytes = []byte("5\r\nHELLO")
+
+	helloAuthBytes    = []byte("AUTH")
+	helloSetNameBytes = []byte("SETNAME")
+
+	// NOTE: the version which RESP3 is supported since, the nodes may be of any version.
+	helloFields = [][]byte{
+		[]byte("6\r\nserver"), []byte("5\r\nredis"),
+		[]byte("7\r\nversion"), []byte("5\r\n6.0.0"),
+		[]byte("5\r\nproto"), nil,
+		[]byte("2\r\nid"), zeroBytes,
+		[]byte("4\r\nmode"), []byte("10\r\nstandalone"),
+		[]byte("4\r\nrole"), []byte("6\r\nmaster"),
+		[]byte("7\r\nmodules"), nil,
+	}
+
+	protoVerDataBytes  = []byte("ERR Protocol version is not an integer or out of range")
+	noProtoDataBytes   = []byte("NOPROTO unsupported protocol version")
+	wrongPassDataBytes = []byte("WRONGPASS invalid username-password pair")
+	helloArgsDataBytes = []byte("ERR syntax error")
+)
+
+// hello negotiate the protocol version of client and make the reply, which is a map for RESP3 client.
+// NOTE: the conns to nodes are shared by the clients of both versions and always speak RESP2,
+// the replies of nodes are upgraded into RESP3 by proxy when encoding for RESP3 client.
+func (pc *proxyConn) hello(r *Request) {
+	ver := pc.protover
+	if r.resp.arrayn > 1 {
+		v, err := strconv.Atoi(string(bulkValue(r.resp.array[1].data)))
+		if err != nil {
+			r.withLocalReply(respError, protoVerDataBytes)
+			return
+		}
+		if v != 2 && v != 3 {
+			r.withLocalReply(respError, noProtoDataBytes)
+			return
+		}
+		ver = v
+	}
+	authed := pc.authed
+	for i := 2; i < r.resp.arrayn; i++ {
+		opt := bulkValue(r.resp.array[i].data)
+		switch {
+		case bytes.EqualFold(opt, helloAuthBytes) && i+2 < r.resp.arrayn:
+			pw := bulkValue(r.resp.array[i+2].data)
+			if pc.password != "" && subtle.ConstantTimeCompare(pw, []byte(pc.password)) != 1 {
+				r.withLocalReply(respError, wrongPassDataBytes)
+				return
+			}
+			authed = true
+			i += 2 // NOTE: the username is ignored, only the password of proxy
+		case bytes.EqualFold(opt, helloSetNameBytes) && i+1 < r.resp.arrayn:
+			i++ // NOTE: the name of client is ignored
+		default:
+			r.withLocalReply(respError, helloArgsDataBytes)
+			return
+		}
+	}
+	if pc.password != "" && !authed {
+		r.withLocalReply(respError, noAuthDataBytes)
+		return
+	}
+	pc.authed = authed
+	pc.protover = ver
+	r.protover = ver // NOTE: switched when encoding, so the replies of requests before are not upgraded
+	r.withLocalReply(respArray, []byte(strconv.Itoa(len(helloFields))))
+	for _, field := range helloFields {
+		nre := r.reply.next()
+		nre.rTp = respBulk
+		nre.data = append(nre.data, field...)
+	}
+	proto := r.reply.array[5]
+	proto.rTp = respInt
+	proto.data = strconv.AppendInt(proto.data, int64(ver), 10)
+	r.reply.array[7].rTp = respInt
+	modules := r.reply.array[13]
+	modules.rTp = respArray
+	modules.data = append(modules.data, zeroBytes...)
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"overlord/lib/bufio"
+	"overlord/lib/log"
 	libnet "overlord/lib/net"
 	"overlord/proto"
 
@@ -42,10 +43,38 @@ type nodeConn struct {
 	state int32
 }
 
-// NewNodeConn create the node conn from proxy to redis
-func NewNodeConn(cluster, addr string, dialTimeout, readTimeout, writeTimeout time.Duration) (nc proto.NodeConn) {
+// NewNodeConn create the node conn from proxy to redis.
+// When auth is not empty, AUTH will be sent first and the conn will be closed if failed.
+func NewNodeConn(cluster, addr, auth string, dialTimeout, readTimeout, writeTimeout time.Duration) (nc proto.NodeConn) {
+	return dialNodeConn(cluster, addr, auth, false, dialTimeout, readTimeout, writeTimeout)
+}
+
+// NewReadOnlyNodeConn create the node conn from proxy to redis cluster node, and send READONLY after AUTH,
+// so the replica node can serve the read requests.
+func NewReadOnlyNodeConn(cluster, addr, auth string, dialTimeout, readTimeout, writeTimeout time.Duration) (nc proto.NodeConn) {
+	return dialNodeConn(cluster, addr, auth, true, dialTimeout, readTimeout, writeTimeout)
+}
+
+func dialNodeConn(cluster, addr, auth string, readOnly bool, dialTimeout, readTimeout, writeTimeout time.Duration) (nc proto.NodeConn) {
 	conn := libnet.DialWithTimeout(addr, dialTimeout, readTimeout, writeTimeout)
-	return newNodeConn(cluster, addr, conn)
+	nc = newNodeConn(cluster, addr, conn)
+	if err := Auth(conn, auth); err != nil {
+		if log.V(1) {
+			log.Errorf("cluster(%s) redis node(%s) auth error:%v", cluster, addr, err)
+		}
+		_ = nc.Close()
+		return
+	}
+	if !readOnly {
+		return
+	}
+	if err := ReadOnly(conn); err != nil {
+		if log.V(1) {
+			log.Errorf("cluster(%s) redis node(%s) readonly error:%v", cluster, addr, err)
+		}
+		_ = nc.Close()
+	}
+	return
 }
 
 func newNodeConn(cluster, addr string, conn *libnet.Conn) proto.NodeConn {
@@ -68,10 +97,9 @@ func (nc *nodeConn) Write(m *proto.Message) (err error) {
 		err = errors.WithStack(ErrBadAssert)
 		return
 	}
-	if !req.IsSupport() || req.IsCtl() {
+	if !req.IsForward() {
 		return
 	}
-	m.MarkWrite()
 	if err = req.resp.encode(nc.bw); err != nil {
 		err = errors.WithStack(err)
 	}
@@ -95,7 +123,7 @@ func (nc *nodeConn) Read(m *proto.Message) (err error) {
 		err = errors.WithStack(ErrBadAssert)
 		return
 	}
-	if !req.IsSupport() || req.IsCtl() {
+	if !req.IsForward() {
 		return
 	}
 	for {
@@ -109,7 +137,6 @@ func (nc *nodeConn) Read(m *proto.Message) (err error) {
 			err = errors.WithStack(err)
 			return
 		}
-		m.MarkRead()
 		return
 	}
 }
@@ -34,7 +34,7 @@ func (*mockCmd) Put() {
 }
 
 func TestNodeConnNewNodeConn(t *testing.T) {
-	nc := NewNodeConn("test", "127.0.0.1:12345", time.Second, time.Second, time.Second)
+	nc := NewNodeConn("test", "127.0.0.1:12345", "", time.Second, time.Second, time.Second)
 	assert.NotNil(t, nc)
 	rnc := nc.(*nodeConn)
 	assert.NotNil(t, rnc.Bw())
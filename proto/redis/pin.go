@@ -0,0 +1,83 @@
+package redis
+
+import (
+	errs "errors"
+
+	"overlord/lib/bufio"
+	libnet "overlord/lib/net"
+	"overlord/proto"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	pinReadBufSize = 1024
+)
+
+// errors
+var (
+	ErrNoNode = errs.New("no node for the keys")
+)
+
+// pinConn is the dedicated conn of client to node, which subscribes the channels and patterns of client,
+// or sends the transaction and the blocking commands of client.
+type pinConn struct {
+	addr string
+	conn *libnet.Conn
+	br   *bufio.Reader
+	bw   *bufio.Writer
+
+	sent int // NOTE: the commands sent and not replied
+}
+
+// WithRouter impl proto.Pinner.
+func (pc *proxyConn) WithRouter(router proto.Router) {
+	pc.router = router
+}
+
+// Close impl proto.Pinner, the conn pinned by WATCH is closed.
+func (pc *proxyConn) Close() error {
+	pc.unpin()
+	return nil
+}
+
+// dial the dedicated conn of client to node by router.
+func (pc *proxyConn) dial(addr string) (*pinConn, error) {
+	conn, err := pc.router.Dial(addr)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &pinConn{
+		addr: addr,
+		conn: conn,
+		br:   bufio.NewReader(conn, bufio.NewBuffer(pinReadBufSize)),
+		bw:   bufio.NewWriter(conn),
+	}, nil
+}
+
+func (pc *proxyConn) replyError(data []byte) error {
+	_ = pc.bw.Write(respErrorBytes)
+	_ = pc.bw.Write(data)
+	return pc.bw.Write(crlfBytes)
+}
+
+// decode read one reply of node.
+func (sc *pinConn) decode(reply *resp) (err error) {
+	for {
+		mark := sc.br.Mark()
+		if err = reply.decode(sc.br); err != bufio.ErrBufferFull {
+			return
+		}
+		sc.br.AdvanceTo(mark)
+		if err = sc.br.Read(); err != nil {
+			return
+		}
+	}
+}
+
+// close the conn which may be read by another goroutine.
+func (sc *pinConn) close() {
+	if sc.conn.Conn != nil {
+		_ = sc.conn.Conn.Close() // NOTE: net.Conn is safe for concurrent use but libnet.Conn is not
+	}
+}
@@ -37,13 +37,18 @@ type pinger struct {
 }
 
 // NewPinger new pinger.
-func NewPinger(conn *libnet.Conn) proto.Pinger {
-	return &pinger{
+// When auth is not empty, AUTH will be sent first and the pinger will be closed if failed.
+func NewPinger(conn *libnet.Conn, auth string) proto.Pinger {
+	p := &pinger{
 		conn:  conn,
 		br:    bufio.NewReader(conn, bufio.NewBuffer(pingBufferSize)),
 		bw:    bufio.NewWriter(conn),
 		state: opened,
 	}
+	if err := Auth(conn, auth); err != nil {
+		_ = p.Close()
+	}
+	return p
 }
 
 func (p *pinger) Ping() (err error) {
@@ -9,14 +9,14 @@ import (
 
 func TestPingerPingOk(t *testing.T) {
 	conn := _createConn(pongBytes)
-	p := NewPinger(conn)
+	p := NewPinger(conn, "")
 	err := p.Ping()
 	assert.NoError(t, err)
 }
 
 func TestPingerClosed(t *testing.T) {
 	conn := _createRepeatConn(pongBytes, 10)
-	p := NewPinger(conn)
+	p := NewPinger(conn, "")
 	assert.NoError(t, p.Close())
 	err := p.Ping()
 	assert.Equal(t, ErrPingClosed, errors.Cause(err))
@@ -25,12 +25,12 @@ func TestPingerClosed(t *testing.T) {
 
 func TestPingerWrongResp(t *testing.T) {
 	conn := _createConn([]byte("-Error: iam more than 7 bytes\r\n"))
-	p := NewPinger(conn)
+	p := NewPinger(conn, "")
 	err := p.Ping()
 	assert.Equal(t, ErrBadPong, errors.Cause(err))
 
 	conn = _createConn([]byte("-Err\r\n"))
-	p = NewPinger(conn)
+	p = NewPinger(conn, "")
 	err = p.Ping()
 	assert.Equal(t, ErrBadPong, errors.Cause(err))
 }
@@ -39,7 +39,7 @@ func TestPingerPingErr(t *testing.T) {
 	conn := _createConn(pongBytes)
 	c := conn.Conn.(*mockConn)
 	c.err = errors.New("some error")
-	p := NewPinger(conn)
+	p := NewPinger(conn, "")
 	err := p.Ping()
 	assert.EqualError(t, err, "some error")
 }
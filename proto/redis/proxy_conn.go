@@ -2,7 +2,10 @@ package redis
 
 import (
 	"bytes"
+	"crypto/subtle"
+	"fmt"
 	"strconv"
+	"sync"
 
 	"overlord/lib/bufio"
 	"overlord/lib/conv"
@@ -13,11 +16,20 @@ import (
 )
 
 var (
-	nullBytes           = []byte("-1\r\n")
-	okBytes             = []byte("OK\r\n")
-	pongDataBytes       = []byte("PONG")
-	justOkBytes         = []byte("OK")
-	notSupportDataBytes = []byte("Error: command not support")
+	nullBytes                 = []byte("-1\r\n")
+	okBytes                   = []byte("OK\r\n")
+	pongDataBytes             = []byte("PONG")
+	justOkBytes               = []byte("OK")
+	notSupportDataBytes       = []byte("Error: command not support")
+	errPrefixBytes            = []byte("ERR ")
+	scriptNotSupportDataBytes = []byte("Error: script subcommand not support")
+	nodeSectionBytes          = []byte("# Node ")
+	badCursorDataBytes        = []byte("ERR invalid cursor")
+	crossSlotDataBytes        = []byte("CROSSSLOT Keys in request don't hash to the same slot")
+	badQuotesDataBytes        = []byte("ERR Protocol error: unbalanced quotes in request")
+	arrayTwoBytes             = []byte("*2\r\n")
+	oneBytes                  = []byte("1")
+	zeroBytes                 = []byte("0")
 )
 
 // ProxyConn is export for redis cluster.
@@ -29,20 +41,51 @@ func (pc *ProxyConn) Bw() *bufio.Writer {
 }
 
 type proxyConn struct {
+	conn      *libnet.Conn
+	pr        *peekReader
 	br        *bufio.Reader
 	bw        *bufio.Writer
 	completed bool
 
 	resp *resp
+
+	password string
+	authed   bool
+	protover int  // NOTE: the version negotiated by the HELLO decoded last
+	resp3    bool // NOTE: the replies of nodes are upgraded for the client negotiated RESP3 by HELLO
+
+	msetDetail bool
+
+	subscribing bool
+
+	router  proto.Router
+	multi   bool
+	aborted bool // NOTE: EXEC is aborted by the errors of commands queued
+	pinning bool
+	queued  []*resp
+	watched [][]byte
+	pinned  *pinConn
+
+	lock        sync.Mutex
+	interrupted bool
+	blocked     *pinConn // NOTE: closed by Interrupt concurrently
 }
 
 // NewProxyConn creates new redis Encoder and Decoder.
-func NewProxyConn(conn *libnet.Conn) proto.ProxyConn {
+// When password is not empty, client must AUTH before any other command.
+// When msetDetail is true, the error of MSET partially failed contains all the failed keys and their errors.
+func NewProxyConn(conn *libnet.Conn, password string, msetDetail bool) proto.ProxyConn {
+	pr := &peekReader{conn: conn}
 	r := &proxyConn{
-		br:        bufio.NewReader(conn, bufio.Get(1024)),
-		bw:        bufio.NewWriter(conn),
-		completed: true,
-		resp:      &resp{},
+		conn:       conn,
+		pr:         pr,
+		br:         bufio.NewReader(pr, bufio.Get(1024)),
+		bw:         bufio.NewWriter(conn),
+		completed:  true,
+		resp:       &resp{},
+		password:   password,
+		protover:   2,
+		msetDetail: msetDetail,
 	}
 	return r
 }
@@ -65,13 +108,25 @@ func (pc *proxyConn) Decode(msgs []*proto.Message) ([]*proto.Message, error) {
 			return nil, err
 		}
 		msgs[i].MarkStart()
+		if pc.subscribing {
+			return msgs[:i+1], nil // NOTE: the requests after are served by subscribe mode
+		}
+		if pc.pinning {
+			pc.pinning = false
+			return msgs[:i+1], nil // NOTE: the requests after are decoded when the pinned replied
+		}
 	}
 	return msgs, nil
 }
 
 func (pc *proxyConn) decode(m *proto.Message) (err error) {
 	mark := pc.br.Mark()
-	if err = pc.resp.decode(pc.br); err != nil {
+	if err = pc.resp.decode(pc.br); err == ErrBadQuotes {
+		r := nextReq(m)
+		r.resp.reset()
+		r.withLocalReply(respError, badQuotesDataBytes) // NOTE: the line is skipped, and the conn is still in sync
+		return nil
+	} else if err != nil {
 		if err == bufio.ErrBufferFull {
 			pc.br.AdvanceTo(mark)
 		}
@@ -84,60 +139,71 @@ func (pc *proxyConn) decode(m *proto.Message) (err error) {
 	}
 	conv.UpdateToUpper(pc.resp.array[0].data)
 	cmd := pc.resp.array[0].data // NOTE: when array, first is command
-	if bytes.Equal(cmd, cmdMSetBytes) {
-		if pc.resp.arrayn%2 == 0 {
-			err = ErrBadRequest
+	if bytes.Equal(cmd, cmdAuthBytes) {
+		r := nextReq(m)
+		r.resp.copy(pc.resp)
+		pc.auth(r)
+		return
+	}
+	if bytes.Equal(cmd, cmdHelloBytes) {
+		r := nextReq(m)
+		r.resp.copy(pc.resp)
+		pc.hello(r)
+		return
+	}
+	if pc.password != "" && !pc.authed && !bytes.Equal(cmd, cmdQuitBytes) {
+		r := nextReq(m)
+		r.resp.copy(pc.resp)
+		r.withLocalReply(respError, noAuthDataBytes)
+		return
+	}
+	if pc.tx(m, cmd) {
+		return
+	}
+	c, _ := lookup(cmd)
+	if c.is(cmdBlocking) && blocks(pc.resp) { // NOTE: XREAD without BLOCK is forwarded by the shared conns
+		r := nextReq(m)
+		r.resp.copy(pc.resp)
+		r.withPinned()
+		pc.pinning = true
+		return
+	}
+	if isSubscribe(cmd) {
+		r := nextReq(m)
+		r.resp.copy(pc.resp)
+		r.local = true // NOTE: replied by Subscribe
+		r.reply.reset()
+		pc.subscribing = true
+		return
+	}
+	if c.is(cmdSplit) {
+		err = pc.split(m, c)
+	} else if bytes.Equal(cmd, cmdScanBytes) {
+		r := nextReq(m)
+		r.resp.copy(pc.resp)
+		cursor, ok := scanCursor(r.resp)
+		if !ok {
+			r.withLocalReply(respError, badCursorDataBytes)
 			return
 		}
-		mid := pc.resp.arrayn / 2
-		for i := 0; i < mid; i++ {
-			r := nextReq(m)
-			r.mType = mergeTypeOK
-			r.resp.reset() // NOTE: *3\r\n
-			r.resp.rTp = respArray
-			r.resp.data = append(r.resp.data, arrayLenThree...)
-			// array resp: mset
-			nre1 := r.resp.next() // NOTE: $4\r\nMSET\r\n
-			nre1.reset()
-			nre1.rTp = respBulk
-			nre1.data = append(nre1.data, cmdMSetBytes...)
-			// array resp: key
-			nre2 := r.resp.next() // NOTE: $klen\r\nkey\r\n
-			nre2.copy(pc.resp.array[i*2+1])
-			// array resp: value
-			nre3 := r.resp.next() // NOTE: $vlen\r\nvalue\r\n
-			nre3.copy(pc.resp.array[i*2+2])
-		}
-	} else if bytes.Equal(cmd, cmdMGetBytes) {
-		for i := 1; i < pc.resp.arrayn; i++ {
-			r := nextReq(m)
-			r.mType = mergeTypeJoin
-			r.resp.reset() // NOTE: *2\r\n
-			r.resp.rTp = respArray
-			r.resp.data = append(r.resp.data, arrayLenTwo...)
-			// array resp: get
-			nre1 := r.resp.next() // NOTE: $3\r\nGET\r\n
-			nre1.reset()
-			nre1.rTp = respBulk
-			nre1.data = append(nre1.data, cmdGetBytes...)
-			// array resp: key
-			nre2 := r.resp.next() // NOTE: $klen\r\nkey\r\n
-			nre2.copy(pc.resp.array[i])
-		}
-	} else if bytes.Equal(cmd, cmdDelBytes) || bytes.Equal(cmd, cmdExistsBytes) {
-		for i := 1; i < pc.resp.arrayn; i++ {
-			r := nextReq(m)
-			r.mType = mergeTypeCount
-			r.resp.reset() // NOTE: *2\r\n
-			r.resp.rTp = respArray
-			r.resp.data = append(r.resp.data, arrayLenTwo...)
-			// array resp: get
-			nre1 := r.resp.next() // NOTE: $3\r\nDEL\r\n | $6\r\nEXISTS\r\n
-			nre1.copy(pc.resp.array[0])
-			// array resp: key
-			nre2 := r.resp.next() // NOTE: $klen\r\nkey\r\n
-			nre2.copy(pc.resp.array[i])
+		r.mType = mergeTypeScan
+		r.scan = true
+		r.cursor = cursor
+	} else if c.is(cmdBroadcast) {
+		mType := c.mType
+		r := nextReq(m)
+		r.resp.copy(pc.resp)
+		if bytes.Equal(cmd, cmdScriptBytes) {
+			if !isBroadcastScript(r.resp) {
+				r.withLocalReply(respError, scriptNotSupportDataBytes)
+				return
+			}
+			if bytes.Equal(r.resp.array[1].data, subCmdExistsBytes) {
+				mType = mergeTypeAll
+			}
 		}
+		r.mType = mType
+		r.broadcast = true
 	} else {
 		r := nextReq(m)
 		r.resp.copy(pc.resp)
@@ -145,6 +211,76 @@ func (pc *proxyConn) decode(m *proto.Message) (err error) {
 	return
 }
 
+// split the command into the commands of every key by step, like MSET into the MSET of every key and value,
+// and MGET into the GET of every key.
+func (pc *proxyConn) split(m *proto.Message, c command) error {
+	kp := c.kp
+	if args := pc.resp.arrayn - kp.first; args < kp.step || args%kp.step != 0 {
+		return ErrBadRequest
+	}
+	cmd := pc.resp.array[0].data
+	if c.split != nil {
+		cmd = c.split
+	}
+	for i := kp.first; i < pc.resp.arrayn; i += kp.step {
+		r := nextReq(m)
+		r.mType = c.mType
+		r.resp.reset() // NOTE: *2\r\n | *3\r\n
+		r.resp.rTp = respArray
+		r.resp.data = strconv.AppendInt(r.resp.data, int64(kp.step+1), 10)
+		// array resp: command
+		nre := r.resp.next() // NOTE: $3\r\nDEL\r\n | $3\r\nGET\r\n
+		nre.reset()
+		nre.rTp = respBulk
+		nre.data = append(nre.data, cmd...)
+		// array resp: key and value
+		for j := i; j < i+kp.step; j++ {
+			r.resp.next().copy(pc.resp.array[j]) // NOTE: $klen\r\nkey\r\n | $vlen\r\nvalue\r\n
+		}
+	}
+	return nil
+}
+
+// isBroadcastScript check the SCRIPT subcommand whether can be broadcast, only LOAD, EXISTS and FLUSH.
+func isBroadcastScript(r *resp) bool {
+	if r.arrayn < 2 {
+		return false
+	}
+	sub := r.array[1].data
+	conv.UpdateToUpper(sub)
+	return bytes.Equal(sub, subCmdLoadBytes) || bytes.Equal(sub, subCmdExistsBytes) || bytes.Equal(sub, subCmdFlushBytes)
+}
+
+// scanCursor returns the cursor of SCAN, the MATCH, COUNT and TYPE options are passed through to the node.
+func scanCursor(r *resp) (uint64, bool) {
+	if r.arrayn < 2 {
+		return 0, false
+	}
+	cursor, err := strconv.ParseUint(string(bulkValue(r.array[1].data)), 10, 64)
+	return cursor, err == nil
+}
+
+// auth check the password of AUTH command and make the reply.
+func (pc *proxyConn) auth(r *Request) {
+	if r.resp.arrayn != 2 {
+		r.withLocalReply(respError, authArgsDataBytes)
+		return
+	}
+	if pc.password == "" {
+		r.withLocalReply(respError, noPassSetDataBytes)
+		return
+	}
+	pw := r.resp.array[1].data
+	pw = pw[bytes.Index(pw, crlfBytes)+2:]
+	if subtle.ConstantTimeCompare(pw, []byte(pc.password)) != 1 {
+		pc.authed = false
+		r.withLocalReply(respError, invalidPassDataBytes)
+		return
+	}
+	pc.authed = true
+	r.withLocalReply(respString, authOKBytes)
+}
+
 func nextReq(m *proto.Message) *Request {
 	req := m.NextReq()
 	if req == nil {
@@ -154,13 +290,25 @@ func nextReq(m *proto.Message) *Request {
 	}
 	r := req.(*Request)
 	r.mType = mergeTypeNo
+	r.local = false
+	r.pinned = false
+	r.broadcast = false
+	r.node = ""
+	r.scan = false
+	r.cursor = 0
+	r.nodes = 0
+	r.protover = 0
+	r.txKeys = r.txKeys[:0]
 	return r
 }
 
 func (pc *proxyConn) Encode(m *proto.Message) (err error) {
-	if err = m.Err(); err != nil {
+	if err = m.Err(); err != nil && !subFailed(m) {
 		se := errors.Cause(err).Error()
 		pc.bw.Write(respErrorBytes)
+		if errors.Cause(err) == proto.ErrRateLimited {
+			pc.bw.Write(errPrefixBytes) // NOTE: reply as the error of redis
+		}
 		pc.bw.Write([]byte(se))
 		pc.bw.Write(crlfBytes)
 		return
@@ -169,6 +317,29 @@ func (pc *proxyConn) Encode(m *proto.Message) (err error) {
 	if !ok {
 		return ErrBadAssert
 	}
+	if req.pinned {
+		if err = pc.pin(req); err != nil {
+			return
+		}
+	}
+	if req.protover != 0 {
+		pc.resp3 = req.protover == 3
+	}
+	if pc.resp3 {
+		for _, mreq := range m.Requests() {
+			r := mreq.(*Request)
+			c, _ := r.command()
+			r.reply.upgrade(c.reply)
+		}
+	}
+	if req.IsBroadcast() {
+		// NOTE: the error replied by any node is replied.
+		for _, mreq := range m.Requests() {
+			if reply := mreq.(*Request).reply; reply.rTp == respError {
+				return errors.WithStack(reply.encode(pc.bw))
+			}
+		}
+	}
 	switch req.mType {
 	case mergeTypeOK:
 		err = pc.mergeOK(m)
@@ -176,8 +347,20 @@ func (pc *proxyConn) Encode(m *proto.Message) (err error) {
 		err = pc.mergeJoin(m)
 	case mergeTypeCount:
 		err = pc.mergeCount(m)
+	case mergeTypeConcat:
+		err = pc.mergeConcat(m)
+	case mergeTypeSection:
+		err = pc.mergeSection(m)
+	case mergeTypeFirst:
+		err = req.reply.encode(pc.bw)
+	case mergeTypeScan:
+		err = pc.mergeScan(req)
+	case mergeTypeAll:
+		err = pc.mergeAll(m)
 	default:
-		if !req.IsSupport() {
+		if req.IsLocal() {
+			// NOTE: reply already made by proxy
+		} else if !req.IsSupport() {
 			req.reply.rTp = respError
 			req.reply.data = req.reply.data[:0]
 			req.reply.data = append(req.reply.data, notSupportDataBytes...)
@@ -201,9 +384,64 @@ func (pc *proxyConn) Encode(m *proto.Message) (err error) {
 	return
 }
 
+// subFailed reports whether the error is of the sub messages of MSET failed by node, which are merged as the failed keys.
+func subFailed(m *proto.Message) bool {
+	if !m.IsBatch() {
+		return false
+	}
+	if req, ok := m.Request().(*Request); !ok || req.mType != mergeTypeOK {
+		return false
+	}
+	for _, sub := range m.Batch() {
+		if sub.Err() != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeOK reply OK when all the nodes replied OK, otherwise the first error replied by node or failed by forwarding,
+// or the failed keys with their errors in detail mode.
 func (pc *proxyConn) mergeOK(m *proto.Message) (err error) {
-	_ = pc.bw.Write(respStringBytes)
-	err = pc.bw.Write(okBytes)
+	reqs := m.Requests()
+	var subs []*proto.Message
+	if m.IsBatch() {
+		subs = m.Batch()
+	}
+	var (
+		failed  []*Request
+		reasons [][]byte
+	)
+	for i, mreq := range reqs {
+		req := mreq.(*Request)
+		if subs != nil && subs[i].Err() != nil {
+			failed = append(failed, req)
+			reasons = append(reasons, []byte(errors.Cause(subs[i].Err()).Error()))
+		} else if req.reply.rTp == respError {
+			failed = append(failed, req)
+			reasons = append(reasons, req.reply.data)
+		}
+	}
+	if len(failed) == 0 {
+		_ = pc.bw.Write(respStringBytes)
+		err = pc.bw.Write(okBytes)
+		return
+	}
+	_ = pc.bw.Write(respErrorBytes)
+	if !pc.msetDetail {
+		_ = pc.bw.Write(reasons[0])
+		err = pc.bw.Write(crlfBytes)
+		return
+	}
+	detail := fmt.Sprintf("ERR %s failed %d of %d keys:", failed[0].CmdString(), len(failed), len(reqs))
+	for i, req := range failed {
+		if i > 0 {
+			detail += ";"
+		}
+		detail += fmt.Sprintf(" %q %s", req.Key(), reasons[i]) // NOTE: the key quoted never breaks the line
+	}
+	_ = pc.bw.Write([]byte(detail))
+	err = pc.bw.Write(crlfBytes)
 	return
 }
 
@@ -214,6 +452,9 @@ func (pc *proxyConn) mergeCount(m *proto.Message) (err error) {
 		if !ok {
 			return ErrBadAssert
 		}
+		if req.reply.rTp == respError {
+			return req.reply.encode(pc.bw) // NOTE: the error of node
+		}
 		ival, err := conv.Btoi(req.reply.data)
 		if err != nil {
 			return ErrBadCount
@@ -228,6 +469,11 @@ func (pc *proxyConn) mergeCount(m *proto.Message) (err error) {
 
 func (pc *proxyConn) mergeJoin(m *proto.Message) (err error) {
 	reqs := m.Requests()
+	if len(reqs) == 0 && pc.resp3 {
+		_ = pc.bw.Write(respNullBytes)
+		err = pc.bw.Write(crlfBytes)
+		return
+	}
 	_ = pc.bw.Write(respArrayBytes)
 	if len(reqs) == 0 {
 		err = pc.bw.Write(nullBytes)
@@ -249,6 +495,116 @@ func (pc *proxyConn) mergeJoin(m *proto.Message) (err error) {
 	return
 }
 
+// mergeConcat reply the array of all the elements of array replies.
+func (pc *proxyConn) mergeConcat(m *proto.Message) (err error) {
+	reqs := m.Requests()
+	var n int
+	for _, mreq := range reqs {
+		n += mreq.(*Request).reply.arrayn
+	}
+	_ = pc.bw.Write(respArrayBytes)
+	_ = pc.bw.Write([]byte(strconv.Itoa(n)))
+	if err = pc.bw.Write(crlfBytes); err != nil {
+		return
+	}
+	for _, mreq := range reqs {
+		reply := mreq.(*Request).reply
+		for i := 0; i < reply.arrayn; i++ {
+			if err = reply.array[i].encode(pc.bw); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// mergeSection reply the bulk joined by the bulk replies of nodes, every reply is leading by the section of node.
+func (pc *proxyConn) mergeSection(m *proto.Message) (err error) {
+	var data []byte
+	for _, mreq := range m.Requests() {
+		req := mreq.(*Request)
+		bulk := bulkValue(req.reply.data)
+		data = append(data, nodeSectionBytes...)
+		data = append(data, req.node...)
+		data = append(data, crlfBytes...)
+		data = append(data, bulk...)
+		data = append(data, crlfBytes...)
+	}
+	_ = pc.bw.Write(respBulkBytes)
+	_ = pc.bw.Write([]byte(strconv.Itoa(len(data))))
+	_ = pc.bw.Write(crlfBytes)
+	_ = pc.bw.Write(data)
+	err = pc.bw.Write(crlfBytes)
+	return
+}
+
+// mergeScan reply the cursor of node encoded with the index of node, and the cursor of next node when the node scanned over.
+func (pc *proxyConn) mergeScan(req *Request) (err error) {
+	reply := req.reply
+	if reply.rTp != respArray || reply.arrayn != 2 {
+		return reply.encode(pc.bw) // NOTE: the error of node
+	}
+	cursor, perr := strconv.ParseUint(string(bulkValue(reply.array[0].data)), 10, 64)
+	if perr != nil || cursor > scanCursorMask {
+		_ = pc.bw.Write(respErrorBytes)
+		_ = pc.bw.Write(badCursorDataBytes)
+		return pc.bw.Write(crlfBytes)
+	}
+	idx := req.cursor >> scanNodeShift
+	if cursor != 0 {
+		cursor |= idx << scanNodeShift
+	} else if int(idx)+1 < req.nodes {
+		cursor = (idx + 1) << scanNodeShift
+	}
+	cs := strconv.FormatUint(cursor, 10)
+	_ = pc.bw.Write(arrayTwoBytes)
+	_ = pc.bw.Write(respBulkBytes)
+	_ = pc.bw.Write([]byte(strconv.Itoa(len(cs))))
+	_ = pc.bw.Write(crlfBytes)
+	_ = pc.bw.Write([]byte(cs))
+	_ = pc.bw.Write(crlfBytes)
+	return reply.array[1].encode(pc.bw)
+}
+
+// mergeAll reply the array of integers which is 1 only when the integers of all the nodes are 1, like SCRIPT EXISTS.
+func (pc *proxyConn) mergeAll(m *proto.Message) (err error) {
+	reqs := m.Requests()
+	first := reqs[0].(*Request).reply
+	_ = pc.bw.Write(respArrayBytes)
+	_ = pc.bw.Write([]byte(strconv.Itoa(first.arrayn)))
+	if err = pc.bw.Write(crlfBytes); err != nil {
+		return
+	}
+	for i := 0; i < first.arrayn; i++ {
+		all := true
+		for _, mreq := range reqs {
+			reply := mreq.(*Request).reply
+			if i >= reply.arrayn || !bytes.Equal(reply.array[i].data, oneBytes) {
+				all = false
+				break
+			}
+		}
+		_ = pc.bw.Write(respIntBytes)
+		if all {
+			_ = pc.bw.Write(oneBytes)
+		} else {
+			_ = pc.bw.Write(zeroBytes)
+		}
+		if err = pc.bw.Write(crlfBytes); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// bulkValue returns the value of bulk data trimmed the size.
+func bulkValue(data []byte) []byte {
+	if idx := bytes.Index(data, crlfBytes); idx != -1 {
+		return data[idx+2:]
+	}
+	return data
+}
+
 func (pc *proxyConn) Flush() (err error) {
 	return pc.bw.Flush()
 }
@@ -2,8 +2,11 @@ package redis
 
 import (
 	"errors"
+	"fmt"
+	"io"
 	"testing"
 
+	"overlord/lib/bufio"
 	"overlord/proto"
 
 	"github.com/stretchr/testify/assert"
@@ -12,7 +15,7 @@ import (
 func TestDecodeBasicOk(t *testing.T) {
 	data := "*2\r\n$3\r\nGET\r\n$4\r\nbaka\r\n"
 	conn := _createConn([]byte(data))
-	pc := NewProxyConn(conn)
+	pc := NewProxyConn(conn, "", false)
 
 	msgs := proto.GetMsgs(1)
 	nmsgs, err := pc.Decode(msgs)
@@ -33,7 +36,7 @@ func TestDecodeBasicOk(t *testing.T) {
 func TestDecodeComplexOk(t *testing.T) {
 	data := "*3\r\n$4\r\nMGET\r\n$4\r\nbaka\r\n$4\r\nkaba\r\n*5\r\n$4\r\nMSET\r\n$1\r\na\r\n$1\r\nb\r\n$3\r\neee\r\n$5\r\n12345\r\n*3\r\n$4\r\nMGET\r\n$4\r\nenen\r\n$4\r\nnime\r\n*2\r\n$3\r\nGET\r\n$5\r\nabcde\r\n*3\r\n$3\r\nDEL\r\n$1\r\na\r\n$1\r\nb\r\n"
 	conn := _createConn([]byte(data))
-	pc := NewProxyConn(conn)
+	pc := NewProxyConn(conn, "", false)
 	// test reuse command
 	msgs := proto.GetMsgs(16)
 	msgs[1].WithRequest(getReq())
@@ -151,7 +154,7 @@ func TestEncodeNotSupportCtl(t *testing.T) {
 	}
 	msg.WithRequest(req)
 	conn := _createConn([]byte(nil))
-	pc := NewProxyConn(conn)
+	pc := NewProxyConn(conn, "", false)
 	err := pc.Encode(msg)
 	assert.NoError(t, err)
 	assert.Equal(t, req.reply.data, notSupportDataBytes)
@@ -201,6 +204,21 @@ func TestEncodeMergeOk(t *testing.T) {
 			},
 			Expect: "+OK\r\n",
 		},
+		{
+			Name:  "mergeOKFailed",
+			MType: mergeTypeOK,
+			Reply: []*resp{
+				&resp{
+					rTp:  respString,
+					data: []byte("OK"),
+				},
+				&resp{
+					rTp:  respError,
+					data: []byte("OOM command not allowed"),
+				},
+			},
+			Expect: "-OOM command not allowed\r\n",
+		},
 		{
 			Name:  "mergeCount",
 			MType: mergeTypeCount,
@@ -216,6 +234,21 @@ func TestEncodeMergeOk(t *testing.T) {
 			},
 			Expect: ":2\r\n",
 		},
+		{
+			Name:  "mergeCountFailed",
+			MType: mergeTypeCount,
+			Reply: []*resp{
+				&resp{
+					rTp:  respInt,
+					data: []byte("1"),
+				},
+				&resp{
+					rTp:  respError,
+					data: []byte("LOADING Redis is loading the dataset in memory"),
+				},
+			},
+			Expect: "-LOADING Redis is loading the dataset in memory\r\n",
+		},
 		{
 			Name:  "mergeJoin",
 			MType: mergeTypeJoin,
@@ -249,7 +282,7 @@ func TestEncodeMergeOk(t *testing.T) {
 				msg.Batch()
 			}
 			conn, buf := _createDownStreamConn()
-			pc := NewProxyConn(conn)
+			pc := NewProxyConn(conn, "", false)
 			err := pc.Encode(msg)
 			if !assert.NoError(t, err) {
 				return
@@ -278,7 +311,7 @@ func TestEncodeWithError(t *testing.T) {
 	msg.Done()
 
 	conn, buf := _createDownStreamConn()
-	pc := NewProxyConn(conn)
+	pc := NewProxyConn(conn, "", false)
 	err := pc.Encode(msg)
 	assert.Error(t, err)
 	assert.Equal(t, mockErr, err)
@@ -290,6 +323,12 @@ func TestEncodeWithError(t *testing.T) {
 	size, err := buf.Read(data)
 	assert.NoError(t, err)
 	assert.Equal(t, "-baka error\r\n", string(data[:size]))
+
+	msg.WithError(proto.ErrRateLimited)
+	pc.Encode(msg)
+	assert.NoError(t, pc.Flush())
+	size, _ = buf.Read(data)
+	assert.Equal(t, "-ERR rate limit exceeded\r\n", string(data[:size]))
 }
 
 func TestEncodeWithPing(t *testing.T) {
@@ -310,7 +349,7 @@ func TestEncodeWithPing(t *testing.T) {
 	msg.WithRequest(req)
 
 	conn, buf := _createDownStreamConn()
-	pc := NewProxyConn(conn)
+	pc := NewProxyConn(conn, "", false)
 	err := pc.Encode(msg)
 	assert.NoError(t, err)
 	err = pc.Flush()
@@ -321,3 +360,431 @@ func TestEncodeWithPing(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "+PONG\r\n", string(data[:size]))
 }
+
+func TestDecodeAndEncodeWithAuth(t *testing.T) {
+	data := "*2\r\n$3\r\nGET\r\n$1\r\na\r\n" +
+		"*2\r\n$4\r\nAUTH\r\n$5\r\nwrong\r\n" +
+		"*2\r\n$4\r\nauth\r\n$6\r\nfoobar\r\n" +
+		"*2\r\n$3\r\nGET\r\n$1\r\na\r\n"
+	conn := _createConn([]byte(data))
+	pc := NewProxyConn(conn, "foobar", false)
+	msgs, err := pc.Decode(proto.GetMsgs(4))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 4)
+
+	req := msgs[0].Request().(*Request)
+	assert.True(t, req.IsLocal())
+	assert.False(t, req.IsForward())
+	req = msgs[1].Request().(*Request)
+	assert.True(t, req.IsLocal())
+	req = msgs[2].Request().(*Request)
+	assert.True(t, req.IsLocal())
+	req = msgs[3].Request().(*Request)
+	assert.False(t, req.IsLocal())
+	assert.True(t, req.IsForward())
+
+	for _, msg := range msgs[:3] {
+		assert.NoError(t, pc.Encode(msg))
+	}
+	assert.NoError(t, pc.Flush())
+	buf := conn.Conn.(*mockConn).wbuf
+	assert.Equal(t, "-NOAUTH Authentication required.\r\n-ERR invalid password\r\n+OK\r\n", buf.String())
+}
+
+func TestDecodeAuthWithoutPassword(t *testing.T) {
+	conn := _createConn([]byte("*2\r\n$4\r\nAUTH\r\n$6\r\nfoobar\r\n*1\r\n$4\r\nAUTH\r\n"))
+	pc := NewProxyConn(conn, "", false)
+	msgs, err := pc.Decode(proto.GetMsgs(2))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 2)
+	for _, msg := range msgs {
+		assert.NoError(t, pc.Encode(msg))
+	}
+	assert.NoError(t, pc.Flush())
+	buf := conn.Conn.(*mockConn).wbuf
+	assert.Equal(t, "-ERR Client sent AUTH, but no password is set\r\n-ERR wrong number of arguments for 'auth' command\r\n", buf.String())
+}
+
+func TestDecodeAndEncodeBroadcast(t *testing.T) {
+	data := "*1\r\n$6\r\nDBSIZE\r\n" +
+		"*2\r\n$4\r\nKEYS\r\n$1\r\n*\r\n" +
+		"*1\r\n$4\r\nINFO\r\n" +
+		"*1\r\n$7\r\nFLUSHDB\r\n" +
+		"*3\r\n$6\r\nSCRIPT\r\n$4\r\nload\r\n$8\r\nreturn 1\r\n" +
+		"*2\r\n$6\r\nSCRIPT\r\n$4\r\nKILL\r\n"
+	conn := _createConn([]byte(data))
+	pc := NewProxyConn(conn, "", false)
+	msgs, err := pc.Decode(proto.GetMsgs(6))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 6)
+	replies := [][]*resp{
+		{{rTp: respInt, data: []byte("3")}, {rTp: respInt, data: []byte("4")}},
+		{
+			{rTp: respArray, data: []byte("1"), array: []*resp{{rTp: respBulk, data: []byte("1\r\na")}}, arrayn: 1},
+			{rTp: respArray, data: []byte("2"), array: []*resp{{rTp: respBulk, data: []byte("1\r\nb")}, {rTp: respBulk, data: []byte("1\r\nc")}}, arrayn: 2},
+		},
+		{{rTp: respBulk, data: []byte("3\r\nv:1")}, {rTp: respBulk, data: []byte("3\r\nv:2")}},
+		{{rTp: respString, data: []byte("OK")}, {rTp: respError, data: []byte("ERR flush failed")}},
+		{{rTp: respBulk, data: []byte("3\r\nsha")}, {rTp: respBulk, data: []byte("3\r\nsha")}},
+	}
+	for i, msg := range msgs[:5] {
+		req := msg.Request().(*Request)
+		assert.True(t, req.IsBroadcast())
+		req.Broadcast(msg, []string{"n1", "n2"})
+		assert.Len(t, msg.Batch(), 2)
+		for j, mreq := range msg.Requests() {
+			assert.Equal(t, req.CmdString(), mreq.CmdString())
+			mreq.(*Request).reply = replies[i][j]
+		}
+		assert.NoError(t, pc.Encode(msg))
+	}
+	req := msgs[5].Request().(*Request)
+	assert.True(t, req.IsLocal())
+	assert.False(t, req.IsBroadcast())
+	assert.NoError(t, pc.Encode(msgs[5]))
+	assert.NoError(t, pc.Flush())
+	buf := conn.Conn.(*mockConn).wbuf
+	assert.Equal(t, ":7\r\n"+
+		"*3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n"+
+		"$32\r\n# Node n1\r\nv:1\r\n# Node n2\r\nv:2\r\n\r\n"+
+		"-ERR flush failed\r\n"+
+		"$3\r\nsha\r\n"+
+		"-Error: script subcommand not support\r\n", buf.String())
+}
+
+func TestDecodeAndEncodeScan(t *testing.T) {
+	data := "*6\r\n$4\r\nSCAN\r\n$15\r\n281474976710663\r\n$5\r\nCOUNT\r\n$2\r\n10\r\n$4\r\nTYPE\r\n$6\r\nstring\r\n" +
+		"*2\r\n$4\r\nSCAN\r\n$15\r\n281474976710663\r\n" +
+		"*2\r\n$4\r\nSCAN\r\n$1\r\n0\r\n" +
+		"*2\r\n$4\r\nSCAN\r\n$2\r\n-1\r\n"
+	conn := _createConn([]byte(data))
+	pc := NewProxyConn(conn, "", false)
+	msgs, err := pc.Decode(proto.GetMsgs(4))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 4)
+
+	// NOTE: node 1 with cursor 7, the next cursor of node is replied.
+	req := msgs[0].Request().(*Request)
+	assert.True(t, req.IsScan())
+	idx, ok := req.Scan(2)
+	assert.True(t, ok)
+	assert.Equal(t, 1, idx)
+	assert.Equal(t, "1\r\n7", string(req.resp.array[1].data))
+	assert.Equal(t, "6\r\nstring", string(req.resp.array[5].data), "options passed through")
+	req.reply = &resp{rTp: respArray, data: []byte("2"), arrayn: 2, array: []*resp{
+		{rTp: respBulk, data: []byte("2\r\n12")},
+		{rTp: respArray, data: []byte("1"), arrayn: 1, array: []*resp{{rTp: respBulk, data: []byte("1\r\na")}}},
+	}}
+	assert.NoError(t, pc.Encode(msgs[0]))
+	// NOTE: the last node scanned over.
+	req = msgs[1].Request().(*Request)
+	_, ok = req.Scan(2)
+	assert.True(t, ok)
+	req.reply = &resp{rTp: respArray, data: []byte("2"), arrayn: 2, array: []*resp{
+		{rTp: respBulk, data: []byte("1\r\n0")},
+		{rTp: respArray, data: []byte("0")},
+	}}
+	assert.NoError(t, pc.Encode(msgs[1]))
+	// NOTE: node 0 scanned over and the next node is started.
+	req = msgs[2].Request().(*Request)
+	idx, ok = req.Scan(2)
+	assert.True(t, ok)
+	assert.Equal(t, 0, idx)
+	req.reply = &resp{rTp: respArray, data: []byte("2"), arrayn: 2, array: []*resp{
+		{rTp: respBulk, data: []byte("1\r\n0")},
+		{rTp: respArray, data: []byte("0")},
+	}}
+	assert.NoError(t, pc.Encode(msgs[2]))
+	req = msgs[3].Request().(*Request)
+	assert.False(t, req.IsScan())
+	assert.NoError(t, pc.Encode(msgs[3]))
+	assert.NoError(t, pc.Flush())
+	buf := conn.Conn.(*mockConn).wbuf
+	assert.Equal(t, "*2\r\n$15\r\n281474976710668\r\n*1\r\n$1\r\na\r\n"+
+		"*2\r\n$1\r\n0\r\n*0\r\n"+
+		"*2\r\n$15\r\n281474976710656\r\n*0\r\n"+
+		"-ERR invalid cursor\r\n", buf.String())
+}
+
+func TestEvalShaAndScript(t *testing.T) {
+	data := "*4\r\n$7\r\nevalsha\r\n$40\r\nE0E1F9FABFC9D4800C877A703B823AC0578FF8DB\r\n$1\r\n1\r\n$3\r\nkey\r\n" +
+		"*3\r\n$6\r\nSCRIPT\r\n$4\r\nload\r\n$8\r\nreturn 1\r\n" +
+		"*3\r\n$6\r\nSCRIPT\r\n$6\r\nexists\r\n$1\r\nx\r\n" +
+		"*2\r\n$6\r\nSCRIPT\r\n$5\r\nFLUSH\r\n"
+	conn := _createConn([]byte(data))
+	pc := NewProxyConn(conn, "", false)
+	msgs, err := pc.Decode(proto.GetMsgs(4))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 4)
+
+	req := msgs[0].Request().(*Request)
+	assert.Equal(t, "key", string(req.Key()))
+	_, ok := req.NoScript()
+	assert.False(t, ok)
+	req.reply = &resp{rTp: respError, data: []byte("NOSCRIPT No matching script. Please use EVAL.")}
+	sha, ok := req.NoScript()
+	assert.True(t, ok)
+	assert.Equal(t, "e0e1f9fabfc9d4800c877a703b823ac0578ff8db", sha)
+	req.WithScript([]byte("return 1"))
+	assert.Equal(t, "4\r\nEVAL", string(req.resp.array[0].data))
+	assert.Equal(t, "8\r\nreturn 1", string(req.resp.array[1].data))
+	assert.Equal(t, "key", string(req.Key()))
+	req.reply = &resp{rTp: respInt, data: []byte("1")}
+	sha, script, ok := req.Script()
+	assert.True(t, ok)
+	assert.Equal(t, "e0e1f9fabfc9d4800c877a703b823ac0578ff8db", sha)
+	assert.Equal(t, "return 1", string(script))
+
+	req = msgs[1].Request().(*Request)
+	sha, _, ok = req.Script()
+	assert.True(t, ok)
+	assert.Equal(t, "e0e1f9fabfc9d4800c877a703b823ac0578ff8db", sha)
+
+	req = msgs[2].Request().(*Request)
+	assert.True(t, req.IsBroadcast())
+	req.Broadcast(msgs[2], []string{"n1", "n2"})
+	assert.Len(t, msgs[2].Batch(), 2)
+	replies := [][]string{{"1", "0"}, {"1", "1"}}
+	for i, mreq := range msgs[2].Requests() {
+		reply := &resp{rTp: respArray, data: []byte("2"), arrayn: 2}
+		for _, v := range replies[i] {
+			reply.array = append(reply.array, &resp{rTp: respInt, data: []byte(v)})
+		}
+		mreq.(*Request).reply = reply
+	}
+	assert.NoError(t, pc.Encode(msgs[2]))
+	assert.True(t, msgs[3].Request().(*Request).IsScriptFlush())
+	assert.NoError(t, pc.Flush())
+	buf := conn.Conn.(*mockConn).wbuf
+	assert.Equal(t, "*2\r\n:1\r\n:0\r\n", buf.String())
+}
+
+func TestEncodeMSetDetail(t *testing.T) {
+	data := "*7\r\n$4\r\nMSET\r\n$1\r\na\r\n$1\r\n1\r\n$3\r\nb\r\n\r\n$1\r\n2\r\n$1\r\nc\r\n$1\r\n3\r\n" +
+		"*5\r\n$6\r\nMSETNX\r\n$3\r\n{a}\r\n$1\r\n1\r\n$4\r\n{a}b\r\n$1\r\n2\r\n" +
+		"*5\r\n$4\r\nMSET\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nc\r\n$1\r\n3\r\n"
+	conn := _createConn([]byte(data))
+	pc := NewProxyConn(conn, "", true)
+	msgs, err := pc.Decode(proto.GetMsgs(3))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 3)
+	assert.Len(t, msgs[0].Batch(), 3)
+	replies := []*resp{
+		{rTp: respError, data: []byte("OOM command not allowed")},
+		{rTp: respError, data: []byte("MOVED 1 127.0.0.1:7000")},
+		{rTp: respString, data: []byte("OK")},
+	}
+	for i, mreq := range msgs[0].Requests() {
+		mreq.(*Request).reply = replies[i]
+	}
+	assert.NoError(t, pc.Encode(msgs[0]))
+
+	req := msgs[1].Request().(*Request)
+	assert.False(t, msgs[1].IsBatch(), "MSETNX never split")
+	assert.Equal(t, [][]byte{[]byte("{a}"), []byte("{a}b")}, req.Keys())
+	req.reply = &resp{rTp: respInt, data: []byte("1")}
+	assert.NoError(t, pc.Encode(msgs[1]))
+
+	subs := msgs[2].Batch()
+	subs[0].Request().(*Request).reply = &resp{rTp: respString, data: []byte("OK")}
+	subs[1].WithError(io.EOF) // NOTE: failed by forwarding without reply
+	assert.NoError(t, pc.Encode(msgs[2]))
+	assert.NoError(t, pc.Flush())
+	buf := conn.Conn.(*mockConn).wbuf
+	assert.Equal(t, "-ERR MSET failed 2 of 3 keys: \"a\" OOM command not allowed; \"b\\r\\n\" MOVED 1 127.0.0.1:7000\r\n:1\r\n"+
+		"-ERR MSET failed 1 of 2 keys: \"c\" EOF\r\n", buf.String())
+}
+
+func TestDecodeSubscribe(t *testing.T) {
+	data := "*2\r\n$3\r\nGET\r\n$1\r\na\r\n*2\r\n$9\r\nsubscribe\r\n$2\r\nch\r\n*2\r\n$3\r\nGET\r\n$1\r\nb\r\n"
+	conn := _createConn([]byte(data))
+	pc := NewProxyConn(conn, "", false)
+	msgs, err := pc.Decode(proto.GetMsgs(4))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 2, "the requests after are served by subscribe mode")
+	sub := pc.(proto.Subscriber)
+	assert.True(t, sub.IsSubscribing())
+	assert.False(t, msgs[1].Request().(*Request).IsForward())
+	assert.NoError(t, pc.Encode(msgs[1]))
+
+	assert.NoError(t, sub.Subscribe(), "pub/sub not supported")
+	assert.False(t, sub.IsSubscribing())
+	buf := conn.Conn.(*mockConn).wbuf
+	assert.Equal(t, "-Error: command not support\r\n", buf.String())
+
+	msgs, err = pc.Decode(proto.GetMsgs(4))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+	assert.Equal(t, []byte("b"), msgs[0].Request().Key())
+}
+
+func TestDecodeTx(t *testing.T) {
+	data := "*1\r\n$5\r\nmulti\r\n*2\r\n$3\r\nGET\r\n$1\r\na\r\n*1\r\n$4\r\nKEYS\r\n*1\r\n$4\r\nEXEC\r\n*2\r\n$3\r\nGET\r\n$1\r\nb\r\n"
+	conn := _createConn([]byte(data))
+	pc := NewProxyConn(conn, "", false)
+	msgs, err := pc.Decode(proto.GetMsgs(8))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 4, "the requests after EXEC are decoded when EXEC replied")
+	for _, msg := range msgs {
+		assert.False(t, msg.Request().(*Request).IsForward(), "held by proxy")
+		assert.NoError(t, pc.Encode(msg))
+	}
+	assert.NoError(t, pc.Flush())
+	buf := conn.Conn.(*mockConn).wbuf
+	assert.Equal(t, "+OK\r\n+QUEUED\r\n-ERR command not allowed in transaction\r\n-EXECABORT Transaction discarded because of previous errors.\r\n", buf.String())
+
+	msgs, err = pc.Decode(proto.GetMsgs(8))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+	assert.True(t, msgs[0].Request().(*Request).IsForward(), "transaction reset by EXEC")
+}
+
+func TestDecodeXRead(t *testing.T) {
+	data := "*6\r\n$5\r\nXREAD\r\n$5\r\nCOUNT\r\n$1\r\n1\r\n$7\r\nSTREAMS\r\n$1\r\na\r\n$1\r\n0\r\n" +
+		"*6\r\n$5\r\nXREAD\r\n$5\r\nBLOCK\r\n$1\r\n0\r\n$7\r\nSTREAMS\r\n$1\r\na\r\n$1\r\n$\r\n"
+	conn := _createConn([]byte(data))
+	pc := NewProxyConn(conn, "", false)
+	msgs, err := pc.Decode(proto.GetMsgs(4))
+	assert.NoError(t, err)
+	if !assert.Len(t, msgs, 2) {
+		return
+	}
+	req := msgs[0].Request().(*Request)
+	assert.True(t, req.IsForward(), "never blocked without BLOCK")
+	assert.Equal(t, "a", string(req.Key()))
+	assert.Equal(t, [][]byte{[]byte("a")}, req.Keys())
+	assert.False(t, msgs[1].Request().(*Request).IsForward(), "blocked by the dedicated conn")
+}
+
+func TestDecodeSplit(t *testing.T) {
+	data := "*4\r\n$6\r\nUNLINK\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n*3\r\n$5\r\nTOUCH\r\n$1\r\na\r\n$1\r\nb\r\n"
+	conn := _createConn([]byte(data))
+	pc := NewProxyConn(conn, "", false)
+	msgs, err := pc.Decode(proto.GetMsgs(4))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 2)
+	for i, keys := range [][]string{{"a", "b", "c"}, {"a", "b"}} {
+		reqs := msgs[i].Requests()
+		if !assert.Len(t, reqs, len(keys)) {
+			continue
+		}
+		for j, req := range reqs {
+			assert.Equal(t, []string{"UNLINK", "TOUCH"}[i], req.CmdString())
+			assert.Equal(t, keys[j], string(req.Key()))
+			assert.Equal(t, mergeTypeCount, req.(*Request).mType)
+		}
+	}
+
+	conn = _createConn([]byte("*1\r\n$4\r\nMGET\r\n"))
+	pc = NewProxyConn(conn, "", false)
+	_, err = pc.Decode(proto.GetMsgs(1))
+	assert.Equal(t, ErrBadRequest, err, "no keys")
+}
+
+func TestDecodeAndEncodeHello(t *testing.T) {
+	data := "*2\r\n$3\r\nGET\r\n$1\r\na\r\n" +
+		"*2\r\n$5\r\nhello\r\n$1\r\n3\r\n" +
+		"*2\r\n$7\r\nHGETALL\r\n$1\r\nh\r\n" +
+		"*2\r\n$3\r\nGET\r\n$1\r\na\r\n" +
+		"*2\r\n$5\r\nHELLO\r\n$1\r\n4\r\n" +
+		"*2\r\n$5\r\nHELLO\r\n$1\r\n2\r\n" +
+		"*2\r\n$3\r\nGET\r\n$1\r\na\r\n"
+	conn := _createConn([]byte(data))
+	pc := NewProxyConn(conn, "", false)
+	msgs, err := pc.Decode(proto.GetMsgs(8))
+	assert.NoError(t, err)
+	if !assert.Len(t, msgs, 7) {
+		return
+	}
+	for _, msg := range msgs {
+		req := msg.Request().(*Request)
+		if req.IsLocal() {
+			continue
+		}
+		if req.CmdString() == "HGETALL" {
+			req.reply.copy(&resp{rTp: respArray, data: []byte("2"), arrayn: 2,
+				array: []*resp{{rTp: respBulk, data: []byte("1\r\nf")}, {rTp: respBulk, data: []byte("1\r\nv")}}})
+		} else {
+			req.reply.copy(&resp{rTp: respBulk}) // NOTE: nil bulk
+		}
+	}
+	for _, msg := range msgs {
+		assert.NoError(t, pc.Encode(msg))
+	}
+	assert.NoError(t, pc.Flush())
+	fields := "$6\r\nserver\r\n$5\r\nredis\r\n$7\r\nversion\r\n$5\r\n6.0.0\r\n$5\r\nproto\r\n:%d\r\n" +
+		"$2\r\nid\r\n:0\r\n$4\r\nmode\r\n$10\r\nstandalone\r\n$4\r\nrole\r\n$6\r\nmaster\r\n$7\r\nmodules\r\n*0\r\n"
+	expect := "$-1\r\n" + // NOTE: replied before HELLO
+		"%7\r\n" + fmt.Sprintf(fields, 3) +
+		"%1\r\n$1\r\nf\r\n$1\r\nv\r\n" +
+		"_\r\n" +
+		"-NOPROTO unsupported protocol version\r\n" +
+		"*14\r\n" + fmt.Sprintf(fields, 2) +
+		"$-1\r\n"
+	assert.Equal(t, expect, conn.Conn.(*mockConn).wbuf.String())
+}
+
+func TestDecodeHelloWithAuth(t *testing.T) {
+	data := "*2\r\n$5\r\nHELLO\r\n$1\r\n3\r\n" +
+		"*5\r\n$5\r\nHELLO\r\n$1\r\n3\r\n$4\r\nAUTH\r\n$7\r\ndefault\r\n$5\r\nwrong\r\n" +
+		"*2\r\n$5\r\nHELLO\r\n$3\r\nxyz\r\n" +
+		"*7\r\n$5\r\nHELLO\r\n$1\r\n3\r\n$4\r\nauth\r\n$7\r\ndefault\r\n$6\r\nfoobar\r\n$7\r\nSETNAME\r\n$1\r\nc\r\n" +
+		"*2\r\n$3\r\nGET\r\n$1\r\na\r\n"
+	conn := _createConn([]byte(data))
+	pc := NewProxyConn(conn, "foobar", false)
+	msgs, err := pc.Decode(proto.GetMsgs(8))
+	assert.NoError(t, err)
+	if !assert.Len(t, msgs, 5) {
+		return
+	}
+	for _, msg := range msgs[:3] {
+		assert.NoError(t, pc.Encode(msg))
+	}
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, "-NOAUTH Authentication required.\r\n-WRONGPASS invalid username-password pair\r\n"+
+		"-ERR Protocol version is not an integer or out of range\r\n", conn.Conn.(*mockConn).wbuf.String())
+	req := msgs[3].Request().(*Request)
+	assert.Equal(t, respArray, req.reply.rTp)
+	assert.Equal(t, 3, req.protover)
+	assert.True(t, msgs[4].Request().(*Request).IsForward(), "authed by HELLO")
+}
+
+func TestDecodeAndEncodeInline(t *testing.T) {
+	data := "PING\r\nmget a \"b c\"\nset 'k\r\nGET a\r\n"
+	conn := _createConn([]byte(data))
+	pc := NewProxyConn(conn, "", false)
+	msgs, err := pc.Decode(proto.GetMsgs(8))
+	assert.NoError(t, err)
+	if !assert.Len(t, msgs, 4) {
+		return
+	}
+	assert.Equal(t, "PING", msgs[0].Request().CmdString())
+	reqs := msgs[1].Requests()
+	if assert.Len(t, reqs, 2, "split as multibulk") {
+		assert.Equal(t, "GET", reqs[0].CmdString())
+		assert.Equal(t, "a", string(reqs[0].Key()))
+		assert.Equal(t, "b c", string(reqs[1].Key()))
+	}
+	req := msgs[3].Request().(*Request)
+	assert.True(t, req.IsForward())
+	assert.Equal(t, "*2\r\n$3\r\nGET\r\n$1\r\na\r\n", func() string {
+		c := _createConn(nil)
+		bw := bufio.NewWriter(c)
+		_ = req.resp.encode(bw)
+		_ = bw.Flush()
+		return c.Conn.(*mockConn).wbuf.String()
+	}(), "the same request as multibulk")
+
+	for _, msg := range msgs[:3:3] {
+		if msg.IsBatch() {
+			msg.Batch()
+			for _, r := range msg.Requests() {
+				r.(*Request).reply.copy(&resp{rTp: respBulk, data: []byte("1\r\nx")})
+			}
+		}
+		assert.NoError(t, pc.Encode(msg))
+	}
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, "+PONG\r\n*2\r\n$1\r\nx\r\n$1\r\nx\r\n-ERR Protocol error: unbalanced quotes in request\r\n",
+		conn.Conn.(*mockConn).wbuf.String())
+}
@@ -0,0 +1,495 @@
+package redis
+
+import (
+	"bytes"
+	errs "errors"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"overlord/lib/bufio"
+	"overlord/lib/conv"
+	"overlord/lib/log"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	subAcksSize = 64
+)
+
+// errors
+var (
+	ErrSubscribeFailed = errs.New("redis node subscribe failed")
+)
+
+var (
+	cmdSubscribeBytes    = []byte("9\r\nSUBSCRIBE")
+	cmdUnsubscribeBytes  = []byte("11\r\nUNSUBSCRIBE")
+	cmdPSubscribeBytes   = []byte("10\r\nPSUBSCRIBE")
+	cmdPUnsubscribeBytes = []byte("12\r\nPUNSUBSCRIBE")
+
+	subscribeBytes    = []byte("9\r\nsubscribe")
+	unsubscribeBytes  = []byte("11\r\nunsubscribe")
+	psubscribeBytes   = []byte("10\r\npsubscribe")
+	punsubscribeBytes = []byte("12\r\npunsubscribe")
+	messageBytes      = []byte("7\r\nmessage")
+	pmessageBytes     = []byte("8\r\npmessage")
+	subPongBytes      = []byte("4\r\npong")
+	emptyBulkBytes    = []byte("0\r\n")
+	arrayThreeBytes   = []byte("*3\r\n")
+	threeBytes        = []byte("3\r\n")
+
+	subArgsDataBytes       = []byte("ERR wrong number of arguments for subscribe command")
+	subNotAllowedDataBytes = []byte("ERR only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT allowed in this context")
+	subNoNodeDataBytes     = []byte("ERR no node to subscribe")
+)
+
+// isSubscribe check the command whether turns the conn into subscribe mode.
+// NOTE: UNSUBSCRIBE and PUNSUBSCRIBE are replied by subscribe mode too, and the conn turns back at once.
+func isSubscribe(cmd []byte) bool {
+	return bytes.Equal(cmd, cmdSubscribeBytes) || bytes.Equal(cmd, cmdPSubscribeBytes) ||
+		bytes.Equal(cmd, cmdUnsubscribeBytes) || bytes.Equal(cmd, cmdPUnsubscribeBytes)
+}
+
+// IsSubscribing impl proto.Subscriber.
+func (pc *proxyConn) IsSubscribing() bool {
+	return pc.subscribing
+}
+
+// Subscribe impl proto.Subscriber, the command turned into subscribe mode is still in pc.resp
+// for Decode returns at once after it.
+func (pc *proxyConn) Subscribe() (err error) {
+	pc.subscribing = false
+	pc.completed = false // NOTE: the requests buffered after the mode are decoded first
+	if pc.router == nil {
+		_ = pc.replyError(notSupportDataBytes)
+		return pc.bw.Flush()
+	}
+	ps := newPubSub(pc)
+	defer ps.close()
+	cmd := pc.resp
+	for {
+		quit, serr := ps.serve(cmd)
+		ps.lock.Lock()
+		err = pc.bw.Flush()
+		ps.lock.Unlock()
+		if serr != nil {
+			return serr
+		}
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if quit {
+			return io.EOF
+		}
+		if ps.count() == 0 {
+			return // NOTE: all unsubscribed and back to the request/response mode
+		}
+		if cmd, err = ps.next(); err != nil {
+			return
+		}
+	}
+}
+
+// pubsub is the subscribe mode of client conn, the channels and patterns are subscribed on the dedicated node conns
+// of the client, and the messages published are streamed back by the readers of node conns asynchronously.
+// NOTE: the subscription is confirmed by proxy after the nodes confirmed, and the count is of all the nodes.
+type pubsub struct {
+	pc *proxyConn
+
+	// NOTE: only used by the goroutine of client
+	conns    map[string]*pinConn // NOTE: keyed by node addr
+	channels map[string]*pinConn
+	patterns map[string][]*pinConn
+
+	lock sync.Mutex // NOTE: guard the writer of client shared with the readers
+	acks chan error
+	done chan struct{}
+	once sync.Once
+	err  error
+	wg   sync.WaitGroup
+}
+
+func newPubSub(pc *proxyConn) *pubsub {
+	return &pubsub{
+		pc:       pc,
+		conns:    make(map[string]*pinConn),
+		channels: make(map[string]*pinConn),
+		patterns: make(map[string][]*pinConn),
+		acks:     make(chan error, subAcksSize),
+		done:     make(chan struct{}),
+	}
+}
+
+// serve the command of client in subscribe mode, the replies are flushed by caller.
+func (ps *pubsub) serve(cmd *resp) (quit bool, err error) {
+	if cmd.arrayn < 1 {
+		ps.replyError(subNotAllowedDataBytes)
+		return
+	}
+	conv.UpdateToUpper(cmd.array[0].data)
+	name := cmd.array[0].data
+	switch {
+	case bytes.Equal(name, cmdSubscribeBytes):
+		err = ps.subscribe(cmd, false)
+	case bytes.Equal(name, cmdPSubscribeBytes):
+		err = ps.subscribe(cmd, true)
+	case bytes.Equal(name, cmdUnsubscribeBytes):
+		err = ps.unsubscribe(cmd, false)
+	case bytes.Equal(name, cmdPUnsubscribeBytes):
+		err = ps.unsubscribe(cmd, true)
+	case bytes.Equal(name, cmdPingBytes) && ps.pc.resp3:
+		ps.lock.Lock()
+		if cmd.arrayn > 1 {
+			err = cmd.array[1].encode(ps.pc.bw) // NOTE: RESP3 client is replied as the request/response mode
+		} else {
+			_ = ps.pc.bw.Write(respStringBytes)
+			_ = ps.pc.bw.Write(pongDataBytes)
+			err = ps.pc.bw.Write(crlfBytes)
+		}
+		ps.lock.Unlock()
+	case bytes.Equal(name, cmdPingBytes):
+		ps.lock.Lock()
+		_ = ps.pc.bw.Write(arrayTwoBytes)
+		ps.writeBulk(subPongBytes)
+		if cmd.arrayn > 1 {
+			err = cmd.array[1].encode(ps.pc.bw)
+		} else {
+			ps.writeBulk(emptyBulkBytes)
+		}
+		ps.lock.Unlock()
+	case bytes.Equal(name, cmdQuitBytes):
+		ps.lock.Lock()
+		_ = ps.pc.bw.Write(respStringBytes)
+		err = ps.pc.bw.Write(okBytes)
+		ps.lock.Unlock()
+		quit = true
+	default:
+		ps.replyError(subNotAllowedDataBytes)
+	}
+	return
+}
+
+// subscribe the channels or patterns not subscribed on the nodes, and reply the confirmations after the nodes confirmed.
+func (ps *pubsub) subscribe(cmd *resp, pattern bool) (err error) {
+	if cmd.arrayn < 2 {
+		ps.replyError(subArgsDataBytes)
+		return
+	}
+	var (
+		names = make([][]byte, 0, cmd.arrayn-1)
+		subs  = make([][]*pinConn, 0, cmd.arrayn-1)
+		sent  = make(map[string]struct{})
+	)
+	for _, arg := range cmd.array[1:cmd.arrayn] {
+		name := bulkValue(arg.data)
+		names = append(names, name)
+		subs = append(subs, nil)
+		if _, ok := sent[string(name)]; ok || ps.subscribed(name, pattern) {
+			continue
+		}
+		sent[string(name)] = struct{}{}
+		var addrs []string
+		if pattern {
+			addrs = ps.pc.router.PatternNodes()
+		} else if addr, ok := ps.pc.router.KeyNode(name); ok {
+			addrs = []string{addr}
+		}
+		for _, addr := range addrs {
+			sc, err := ps.pinConn(addr)
+			if err != nil {
+				return err
+			}
+			if err = sc.writeSub(cmd.array[0].data, name); err != nil {
+				return err
+			}
+			subs[len(subs)-1] = append(subs[len(subs)-1], sc)
+		}
+	}
+	if err = ps.wait(); err != nil {
+		return
+	}
+	kind := subscribeBytes
+	if pattern {
+		kind = psubscribeBytes
+	}
+	for i, name := range names {
+		if len(subs[i]) == 0 && !ps.subscribed(name, pattern) {
+			ps.replyError(subNoNodeDataBytes)
+			continue
+		}
+		if len(subs[i]) != 0 {
+			if pattern {
+				ps.patterns[string(name)] = subs[i]
+			} else {
+				ps.channels[string(name)] = subs[i][0]
+			}
+		}
+		ps.reply(kind, name)
+	}
+	return
+}
+
+// unsubscribe the channels or patterns on the nodes, all the subscribed when no argument,
+// and reply the confirmations after the nodes confirmed.
+func (ps *pubsub) unsubscribe(cmd *resp, pattern bool) (err error) {
+	var names [][]byte
+	for _, arg := range cmd.array[1:cmd.arrayn] {
+		names = append(names, bulkValue(arg.data))
+	}
+	if len(names) == 0 {
+		names = ps.subscriptions(pattern)
+	}
+	kind := unsubscribeBytes
+	if pattern {
+		kind = punsubscribeBytes
+	}
+	if len(names) == 0 {
+		ps.reply(kind, nil)
+		return
+	}
+	sent := make(map[string]struct{})
+	for _, name := range names {
+		if _, ok := sent[string(name)]; ok {
+			continue
+		}
+		sent[string(name)] = struct{}{}
+		var scs []*pinConn
+		if pattern {
+			scs = ps.patterns[string(name)]
+		} else if sc, ok := ps.channels[string(name)]; ok {
+			scs = []*pinConn{sc}
+		}
+		for _, sc := range scs {
+			if err = sc.writeSub(cmd.array[0].data, name); err != nil {
+				return
+			}
+		}
+	}
+	if err = ps.wait(); err != nil {
+		return
+	}
+	for _, name := range names {
+		if pattern {
+			delete(ps.patterns, string(name))
+		} else {
+			delete(ps.channels, string(name))
+		}
+		ps.reply(kind, name)
+	}
+	return
+}
+
+func (ps *pubsub) subscribed(name []byte, pattern bool) (ok bool) {
+	if pattern {
+		_, ok = ps.patterns[string(name)]
+	} else {
+		_, ok = ps.channels[string(name)]
+	}
+	return
+}
+
+// subscriptions returns the channels or patterns subscribed in order.
+func (ps *pubsub) subscriptions(pattern bool) (names [][]byte) {
+	var ss []string
+	if pattern {
+		for name := range ps.patterns {
+			ss = append(ss, name)
+		}
+	} else {
+		for name := range ps.channels {
+			ss = append(ss, name)
+		}
+	}
+	sort.Strings(ss)
+	for _, name := range ss {
+		names = append(names, []byte(name))
+	}
+	return
+}
+
+func (ps *pubsub) count() int {
+	return len(ps.channels) + len(ps.patterns)
+}
+
+// pinConn returns the conn of node, and dial the new one with the reader started when not exists.
+func (ps *pubsub) pinConn(addr string) (*pinConn, error) {
+	if sc, ok := ps.conns[addr]; ok {
+		return sc, nil
+	}
+	sc, err := ps.pc.dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	sc.conn.SetReadTimeout(0) // NOTE: the messages may be published rarely
+	ps.conns[addr] = sc
+	ps.wg.Add(1)
+	go ps.read(sc)
+	return sc, nil
+}
+
+// wait flush the commands sent to nodes and wait for all the confirmations.
+func (ps *pubsub) wait() (err error) {
+	var n int
+	for _, sc := range ps.conns {
+		if sc.sent == 0 {
+			continue
+		}
+		n, sc.sent = n+sc.sent, 0
+		if err = sc.bw.Flush(); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case err = <-ps.acks:
+			if err != nil {
+				return
+			}
+		case <-ps.done:
+			return ps.err
+		}
+	}
+	return
+}
+
+// next read the next command of client, the error of nodes is returned when the client conn woken up by it.
+func (ps *pubsub) next() (*resp, error) {
+	pc := ps.pc
+	for {
+		mark := pc.br.Mark()
+		err := pc.resp.decode(pc.br)
+		if err == nil {
+			return pc.resp, nil
+		} else if err != bufio.ErrBufferFull {
+			return nil, err
+		}
+		pc.br.AdvanceTo(mark)
+		if err = pc.br.Read(); err != nil {
+			select {
+			case <-ps.done:
+				return nil, ps.err
+			default:
+				return nil, err
+			}
+		}
+	}
+}
+
+// read stream the messages published back to client, and the confirmations to the goroutine of client.
+func (ps *pubsub) read(sc *pinConn) {
+	defer ps.wg.Done()
+	reply := &resp{}
+	for {
+		if err := sc.decode(reply); err != nil {
+			ps.fail(errors.WithStack(err))
+			return
+		}
+		if (reply.rTp == respArray || reply.rTp == respPush) && reply.arrayn > 0 &&
+			(bytes.Equal(reply.array[0].data, messageBytes) || bytes.Equal(reply.array[0].data, pmessageBytes)) {
+			reply.rTp = respArray
+			if ps.pc.resp3 {
+				reply.rTp = respPush
+			}
+			ps.lock.Lock()
+			_ = reply.encode(ps.pc.bw)
+			err := ps.pc.bw.Flush()
+			ps.lock.Unlock()
+			if err != nil {
+				ps.fail(errors.WithStack(err))
+				return
+			}
+			continue
+		}
+		var err error
+		if reply.rTp == respError {
+			err = errors.Wrapf(ErrSubscribeFailed, "node(%s) %s", sc.addr, reply.data)
+		}
+		select {
+		case ps.acks <- err:
+		case <-ps.done:
+			return
+		}
+	}
+}
+
+// fail stop the subscribe mode by the error of node, and wake up the client conn blocking read.
+func (ps *pubsub) fail(err error) {
+	ps.once.Do(func() {
+		if log.V(2) {
+			log.Warnf("redis subscribe mode of client failed:%v", err)
+		}
+		ps.err = err
+		close(ps.done)
+		_ = ps.pc.conn.SetReadDeadline(time.Now())
+	})
+}
+
+func (ps *pubsub) close() {
+	ps.once.Do(func() {
+		close(ps.done)
+	})
+	for _, sc := range ps.conns {
+		sc.close()
+	}
+	ps.wg.Wait()
+}
+
+// reply the confirmation with the count of subscriptions, nil name means nothing unsubscribed.
+func (ps *pubsub) reply(kind, name []byte) {
+	ps.lock.Lock()
+	if ps.pc.resp3 {
+		_ = ps.pc.bw.Write(respPushBytes) // NOTE: the confirmations are pushed to RESP3 client
+		_ = ps.pc.bw.Write(threeBytes)
+	} else {
+		_ = ps.pc.bw.Write(arrayThreeBytes)
+	}
+	ps.writeBulk(kind)
+	if name == nil && ps.pc.resp3 {
+		_ = ps.pc.bw.Write(respNullBytes)
+		_ = ps.pc.bw.Write(crlfBytes)
+	} else if name == nil {
+		_ = ps.pc.bw.Write(respBulkBytes)
+		_ = ps.pc.bw.Write(nullBytes)
+	} else {
+		_ = ps.pc.bw.Write(respBulkBytes)
+		_ = ps.pc.bw.Write([]byte(strconv.Itoa(len(name))))
+		_ = ps.pc.bw.Write(crlfBytes)
+		_ = ps.pc.bw.Write(name)
+		_ = ps.pc.bw.Write(crlfBytes)
+	}
+	_ = ps.pc.bw.Write(respIntBytes)
+	_ = ps.pc.bw.Write([]byte(strconv.Itoa(ps.count())))
+	_ = ps.pc.bw.Write(crlfBytes)
+	ps.lock.Unlock()
+}
+
+func (ps *pubsub) replyError(data []byte) {
+	ps.lock.Lock()
+	_ = ps.pc.replyError(data)
+	ps.lock.Unlock()
+}
+
+// writeBulk must be called with lock held, the data is the bulk decoded like "len\r\nvalue".
+func (ps *pubsub) writeBulk(data []byte) {
+	_ = ps.pc.bw.Write(respBulkBytes)
+	_ = ps.pc.bw.Write(data)
+	_ = ps.pc.bw.Write(crlfBytes)
+}
+
+// writeSub write the command of one channel or pattern, which is flushed by wait.
+func (sc *pinConn) writeSub(cmd, name []byte) error {
+	_ = sc.bw.Write(arrayTwoBytes)
+	_ = sc.bw.Write(respBulkBytes)
+	_ = sc.bw.Write(cmd)
+	_ = sc.bw.Write(crlfBytes)
+	_ = sc.bw.Write(respBulkBytes)
+	_ = sc.bw.Write([]byte(strconv.Itoa(len(name))))
+	_ = sc.bw.Write(crlfBytes)
+	_ = sc.bw.Write(name)
+	sc.sent++
+	return errors.WithStack(sc.bw.Write(crlfBytes))
+}
@@ -2,18 +2,19 @@ package redis
 
 import (
 	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
 	errs "errors"
+	"strconv"
 	"sync"
-	"unsafe"
+
+	"overlord/proto"
 )
 
 var (
 	emptyBytes = []byte("")
 	crlfBytes  = []byte("\r\n")
 
-	arrayLenTwo   = []byte("2")
-	arrayLenThree = []byte("3")
-
 	cmdEvalBytes   = []byte("4\r\nEVAL")
 	cmdQuitBytes   = []byte("4\r\nQUIT")
 	cmdPingBytes   = []byte("4\r\nPING")
@@ -22,27 +23,34 @@ var (
 	cmdGetBytes    = []byte("3\r\nGET")
 	cmdDelBytes    = []byte("3\r\nDEL")
 	cmdExistsBytes = []byte("6\r\nEXISTS")
+	cmdScriptBytes = []byte("6\r\nSCRIPT")
+	cmdScanBytes   = []byte("4\r\nSCAN")
 
-	reqSupportCmdMap = map[string]struct{}{}
-	reqControlCmdMap = map[string]struct{}{}
-)
+	cmdFlushDBBytes  = []byte("7\r\nFLUSHDB")
+	cmdFlushAllBytes = []byte("8\r\nFLUSHALL")
 
-func init() {
-	supports := append(readCmds, writeCmds...)
-	supports = append(supports, controlCmds...)
-	for _, key := range supports {
-		reqSupportCmdMap[key] = struct{}{}
-	}
-	for _, key := range controlCmds {
-		reqControlCmdMap[key] = struct{}{}
-	}
-}
+	subCmdLoadBytes   = []byte("4\r\nLOAD")
+	subCmdFlushBytes  = []byte("5\r\nFLUSH")
+	subCmdExistsBytes = []byte("6\r\nEXISTS")
+
+	cmdEvalShaBytes = []byte("7\r\nEVALSHA")
+	noScriptBytes   = []byte("NOSCRIPT")
+)
 
 // errors
 var (
-	ErrBadAssert  = errs.New("bad assert for redis")
-	ErrBadCount   = errs.New("bad count number")
-	ErrBadRequest = errs.New("bad request")
+	ErrBadAssert    = errs.New("bad assert for redis")
+	ErrBadCount     = errs.New("bad count number")
+	ErrBadRequest   = errs.New("bad request")
+	ErrBadQuotes    = errs.New("unbalanced quotes in request")
+	ErrInlineTooBig = errs.New("too big inline request")
+)
+
+// The cursor of SCAN replied by proxy is the index of node in the high bits and the cursor of node in the low bits,
+// so 0 still means the start and the end of the whole scan.
+const (
+	scanNodeShift  = 48
+	scanCursorMask = 1<<scanNodeShift - 1
 )
 
 // mergeType is used to decript the merge operation.
@@ -54,6 +62,11 @@ const (
 	mergeTypeCount
 	mergeTypeOK
 	mergeTypeJoin
+	mergeTypeConcat  // NOTE: the elements of array replies are concatenated
+	mergeTypeSection // NOTE: the bulk replies are joined by node sections
+	mergeTypeFirst   // NOTE: all the nodes reply the same, the first is replied
+	mergeTypeScan    // NOTE: the cursor of node reply is encoded with the index of node
+	mergeTypeAll     // NOTE: the integers of array replies are 1 only when all the nodes reply 1
 )
 
 // Request is the type of a complete redis command
@@ -61,6 +74,21 @@ type Request struct {
 	resp  *resp
 	reply *resp
 	mType mergeType
+	// local means the reply is made by proxy and never forward to backend.
+	local bool
+	// pinned means the reply is made by the dedicated conn of client when encoding, like WATCH and EXEC.
+	pinned bool
+	// broadcast means the request is sent to all the nodes, and node is the one of the copy sent to.
+	broadcast bool
+	node      string
+	// scan means the request scans the nodes one by one, cursor is of proxy and nodes is the count of nodes scanned.
+	scan   bool
+	cursor uint64
+	nodes  int
+	// protover is the protocol version of client switched by HELLO when encoding, 0 means not switched.
+	protover int
+	// txKeys is the keys written by the commands queued of EXEC.
+	txKeys [][]byte
 }
 
 var reqPool = &sync.Pool{
@@ -109,12 +137,15 @@ func (r *Request) Key() []byte {
 	}
 
 	k := r.resp.array[1]
-	// SUPPORT EVAL command
-	const evalArgsMinCount int = 4
-	if r.resp.arrayn >= evalArgsMinCount {
-		if bytes.Equal(r.resp.array[0].data, cmdEvalBytes) {
-			// find the 4th key with index 3
-			k = r.resp.array[3]
+	// NOTE: the first key of multi-key commands, like the 4th of EVAL and the 3rd of BITOP.
+	if c, ok := r.command(); ok {
+		if c.is(cmdBlocking) {
+			if keys := blockKeys(r.resp); len(keys) > 0 {
+				return keys[0] // NOTE: the first stream of XREAD without BLOCK
+			}
+		}
+		if first := c.kp.firstKey(); first < r.resp.arrayn {
+			k = r.resp.array[first]
 		}
 	}
 
@@ -125,11 +156,47 @@ func (r *Request) Key() []byte {
 	return k.data[pos:]
 }
 
+// Keys returns all the keys of multi-key command, nil when the command is not multi-key or the arguments are bad.
+func (r *Request) Keys() (keys [][]byte) {
+	c, ok := r.command()
+	if !ok || r.local {
+		return
+	}
+	if c.is(cmdBlocking) {
+		return blockKeys(r.resp) // NOTE: the streams of XREAD without BLOCK
+	}
+	if !c.kp.isMulti() {
+		return
+	}
+	return c.kp.keys(r.resp)
+}
+
+// WithCrossSlot reply the error by proxy that the keys are not in the same node or slot.
+func (r *Request) WithCrossSlot() {
+	r.withLocalReply(respError, crossSlotDataBytes)
+}
+
+func (r *Request) command() (c command, ok bool) {
+	if r.resp.arrayn < 1 {
+		return
+	}
+	return lookup(r.resp.array[0].data)
+}
+
 // Put the resource back to pool
 func (r *Request) Put() {
 	r.resp.reset()
 	r.reply.reset()
 	r.mType = mergeTypeNo
+	r.local = false
+	r.pinned = false
+	r.broadcast = false
+	r.node = ""
+	r.scan = false
+	r.cursor = 0
+	r.nodes = 0
+	r.protover = 0
+	r.txKeys = r.txKeys[:0]
 	reqPool.Put(r)
 }
 
@@ -145,160 +212,162 @@ func (r *Request) Reply() *RESP {
 
 // IsSupport check command support.
 func (r *Request) IsSupport() bool {
-	if r.resp.arrayn < 1 {
-		return false
-	}
-	key := *((*string)(unsafe.Pointer(&r.resp.array[0].data)))
-	_, ok := reqSupportCmdMap[key]
+	_, ok := r.command()
 	return ok
 }
 
 // IsCtl is control command.
 func (r *Request) IsCtl() bool {
-	if r.resp.arrayn < 1 {
-		return false
+	c, ok := r.command()
+	return ok && c.is(cmdControl)
+}
+
+// IsReadOnly check the command whether only reads the data, which can be served by replica.
+func (r *Request) IsReadOnly() bool {
+	c, ok := r.command()
+	return ok && c.is(cmdRead)
+}
+
+// IsGet check the request whether is GET of single key, which reply can be cached by proxy.
+func (r *Request) IsGet() bool {
+	return r.resp.arrayn == 2 && bytes.Equal(r.resp.array[0].data, cmdGetBytes)
+}
+
+// HitReply returns the bulk data of GET reply when hit.
+func (r *Request) HitReply() ([]byte, bool) {
+	if r.local || r.reply.rTp != respBulk || len(r.reply.data) == 0 { // NOTE: nil bulk is empty data
+		return nil, false
 	}
-	key := *((*string)(unsafe.Pointer(&r.resp.array[0].data)))
-	_, ok := reqControlCmdMap[key]
-	return ok
+	return r.reply.data, true
 }
 
-var (
-	readCmds = []string{
-		"4\r\nDUMP",
-		"6\r\nEXISTS",
-		"4\r\nPTTL",
-		"3\r\nTTL",
-		"4\r\nTYPE",
-		"8\r\nBITCOUNT",
-		"6\r\nBITPOS",
-		"3\r\nGET",
-		"6\r\nGETBIT",
-		"8\r\nGETRANGE",
-		"4\r\nMGET",
-		"6\r\nSTRLEN",
-		"7\r\nHEXISTS",
-		"4\r\nHGET",
-		"7\r\nHGETALL",
-		"5\r\nHKEYS",
-		"4\r\nHLEN",
-		"5\r\nHMGET",
-		"7\r\nHSTRLEN",
-		"5\r\nHVALS",
-		"5\r\nHSCAN",
-		"5\r\nSCARD",
-		"5\r\nSDIFF",
-		"6\r\nSINTER",
-		"9\r\nSISMEMBER",
-		"8\r\nSMEMBERS",
-		"11\r\nSRANDMEMBER",
-		"6\r\nSUNION",
-		"5\r\nSSCAN",
-		"5\r\nZCARD",
-		"6\r\nZCOUNT",
-		"9\r\nZLEXCOUNT",
-		"6\r\nZRANGE",
-		"11\r\nZRANGEBYLEX",
-		"13\r\nZRANGEBYSCORE",
-		"5\r\nZRANK",
-		"9\r\nZREVRANGE",
-		"14\r\nZREVRANGEBYLEX",
-		"16\r\nZREVRANGEBYSCORE",
-		"8\r\nZREVRANK",
-		"6\r\nZSCORE",
-		"5\r\nZSCAN",
-		"6\r\nLINDEX",
-		"4\r\nLLEN",
-		"6\r\nLRANGE",
-		"7\r\nPFCOUNT",
+// WithHitReply set the bulk data of GET reply which is cached by proxy, and the request never forward.
+func (r *Request) WithHitReply(data []byte) {
+	r.withLocalReply(respBulk, data)
+}
+
+// IsLocal check the reply whether made by proxy.
+func (r *Request) IsLocal() bool {
+	return r.local
+}
+
+// IsForward check the request whether need to forward to backend.
+func (r *Request) IsForward() bool {
+	return !r.local && r.IsSupport() && !r.IsCtl()
+}
+
+// IsBroadcast impl proto.Broadcaster.
+func (r *Request) IsBroadcast() bool {
+	return r.broadcast && !r.local
+}
+
+// Broadcast impl proto.Broadcaster, the copies of request for the other nodes are appended into message.
+func (r *Request) Broadcast(m *proto.Message, nodes []string) {
+	r.node = nodes[0]
+	for _, node := range nodes[1:] {
+		nr := nextReq(m)
+		nr.resp.copy(r.resp)
+		nr.mType = r.mType
+		nr.broadcast = true
+		nr.node = node
 	}
-	writeCmds = []string{
-		"3\r\nDEL",
-		"6\r\nEXPIRE",
-		"8\r\nEXPIREAT",
-		"7\r\nPERSIST",
-		"7\r\nPEXPIRE",
-		"9\r\nPEXPIREAT",
-		"7\r\nRESTORE",
-		"4\r\nSORT",
-		"6\r\nAPPEND",
-		"4\r\nDECR",
-		"6\r\nDECRBY",
-		"6\r\nGETSET",
-		"4\r\nINCR",
-		"6\r\nINCRBY",
-		"11\r\nINCRBYFLOAT",
-		"4\r\nMSET",
-		"6\r\nPSETEX",
-		"3\r\nSET",
-		"6\r\nSETBIT",
-		"5\r\nSETEX",
-		"5\r\nSETNX",
-		"8\r\nSETRANGE",
-		"4\r\nHDEL",
-		"7\r\nHINCRBY",
-		"12\r\nHINCRBYFLOAT",
-		"5\r\nHMSET",
-		"4\r\nHSET",
-		"6\r\nHSETNX",
-		"7\r\nLINSERT",
-		"4\r\nLPOP",
-		"5\r\nLPUSH",
-		"6\r\nLPUSHX",
-		"4\r\nLREM",
-		"4\r\nLSET",
-		"5\r\nLTRIM",
-		"4\r\nRPOP",
-		"9\r\nRPOPLPUSH",
-		"5\r\nRPUSH",
-		"6\r\nRPUSHX",
-		"4\r\nSADD",
-		"5\r\nSMOVE",
-		"4\r\nSPOP",
-		"4\r\nSREM",
-		"4\r\nZADD",
-		"7\r\nZINCRBY",
-		"11\r\nZINTERSTORE",
-		"4\r\nZREM",
-		"14\r\nZREMRANGEBYLEX",
-		"15\r\nZREMRANGEBYRANK",
-		"16\r\nZREMRANGEBYSCORE",
-		"5\r\nPFADD",
-		"7\r\nPFMERGE",
-		"4\r\nEVAL",
+}
+
+// IsScan impl proto.Scanner.
+func (r *Request) IsScan() bool {
+	return r.scan && !r.local
+}
+
+// Scan impl proto.Scanner, the cursor of request is rewritten to the cursor of node.
+func (r *Request) Scan(nodes int) (int, bool) {
+	idx := int(r.cursor >> scanNodeShift)
+	if idx >= nodes {
+		r.withLocalReply(respError, badCursorDataBytes)
+		return 0, false
 	}
-	notSupportCmds = []string{
-		"6\r\nMSETNX",
-		"10\r\nSDIFFSTORE",
-		"11\r\nSINTERSTORE",
-		"11\r\nSUNIONSTORE",
-		"11\r\nZUNIONSTORE",
-		"5\r\nBLPOP",
-		"5\r\nBRPOP",
-		"10\r\nBRPOPLPUSH",
-		"4\r\nKEYS",
-		"7\r\nMIGRATE",
-		"4\r\nMOVE",
-		"6\r\nOBJECT",
-		"9\r\nRANDOMKEY",
-		"6\r\nRENAME",
-		"8\r\nRENAMENX",
-		"4\r\nSCAN",
-		"4\r\nWAIT",
-		"5\r\nBITOP",
-		"7\r\nEVALSHA",
-		"4\r\nAUTH",
-		"4\r\nECHO",
-		"4\r\nINFO",
-		"5\r\nPROXY",
-		"7\r\nSLOWLOG",
-		"6\r\nSELECT",
-		"4\r\nTIME",
-		"6\r\nCONFIG",
-		"8\r\nCOMMANDS",
+	r.nodes = nodes
+	cursor := strconv.AppendUint(nil, r.cursor&scanCursorMask, 10)
+	arg := r.resp.array[1]
+	arg.reset()
+	arg.rTp = respBulk
+	arg.data = strconv.AppendInt(arg.data, int64(len(cursor)), 10)
+	arg.data = append(arg.data, crlfBytes...)
+	arg.data = append(arg.data, cursor...)
+	return idx, true
+}
+
+// Script returns the lower case sha1 and the body of script which is loaded into node by EVAL or SCRIPT LOAD.
+func (r *Request) Script() (sha string, script []byte, ok bool) {
+	if r.local || r.resp.arrayn < 2 || r.reply.rTp == respError {
+		return
 	}
-	controlCmds = []string{
-		"4\r\nQUIT",
-		"4\r\nPING",
+	cmd := r.resp.array[0].data
+	if bytes.Equal(cmd, cmdEvalBytes) {
+		script = bulkValue(r.resp.array[1].data)
+	} else if bytes.Equal(cmd, cmdScriptBytes) && r.resp.arrayn == 3 && bytes.Equal(r.resp.array[1].data, subCmdLoadBytes) {
+		script = bulkValue(r.resp.array[2].data)
+	} else {
+		return
 	}
-)
+	sum := sha1.Sum(script)
+	return hex.EncodeToString(sum[:]), script, true
+}
+
+// TxKeys returns the keys written by the commands queued when the request is EXEC, which is executed when encoding.
+func (r *Request) TxKeys() [][]byte {
+	return r.txKeys
+}
+
+// IsFlush check the request whether is FLUSHDB or FLUSHALL, which removes all the keys.
+func (r *Request) IsFlush() bool {
+	return !r.local && r.resp.arrayn >= 1 &&
+		(bytes.Equal(r.resp.array[0].data, cmdFlushDBBytes) || bytes.Equal(r.resp.array[0].data, cmdFlushAllBytes))
+}
+
+// IsScriptFlush check the request whether is SCRIPT FLUSH.
+func (r *Request) IsScriptFlush() bool {
+	return !r.local && r.resp.arrayn >= 2 && bytes.Equal(r.resp.array[0].data, cmdScriptBytes) &&
+		bytes.Equal(r.resp.array[1].data, subCmdFlushBytes)
+}
+
+// NoScript returns the lower case sha1 of EVALSHA which is replied NOSCRIPT by node.
+func (r *Request) NoScript() (sha string, ok bool) {
+	if r.local || r.resp.arrayn < 2 || !bytes.Equal(r.resp.array[0].data, cmdEvalShaBytes) {
+		return
+	}
+	if r.reply.rTp != respError || !bytes.HasPrefix(r.reply.data, noScriptBytes) {
+		return
+	}
+	return string(bytes.ToLower(bulkValue(r.resp.array[1].data))), true
+}
+
+// WithScript rewrite EVALSHA to EVAL with the script and reset the reply, so the request can be forwarded again
+// and the script is loaded into node.
+func (r *Request) WithScript(script []byte) {
+	cmd := r.resp.array[0]
+	cmd.reset()
+	cmd.rTp = respBulk
+	cmd.data = append(cmd.data, cmdEvalBytes...)
+	arg := r.resp.array[1]
+	arg.reset()
+	arg.rTp = respBulk
+	arg.data = strconv.AppendInt(arg.data, int64(len(script)), 10)
+	arg.data = append(arg.data, crlfBytes...)
+	arg.data = append(arg.data, script...)
+	r.reply.reset()
+}
+
+// withPinned mark the request replied by the dedicated conn and never forward.
+func (r *Request) withPinned() {
+	r.local = true
+	r.pinned = true
+	r.reply.reset()
+}
+
+// withLocalReply set the reply by proxy and mark the request never forward.
+func (r *Request) withLocalReply(rTp respType, data []byte) {
+	r.local = true
+	r.reply.reset()
+	r.reply.rTp = rTp
+	r.reply.data = append(r.reply.data, data...)
+}
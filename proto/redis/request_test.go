@@ -2,6 +2,7 @@ package redis
 
 import (
 	"testing"
+	"time"
 
 	"overlord/lib/bufio"
 
@@ -24,6 +25,167 @@ func TestRequestNewRequest(t *testing.T) {
 	assert.Equal(t, "mylist", string(req.Key()))
 	assert.True(t, req.IsSupport())
 	assert.False(t, req.IsCtl())
+	assert.True(t, req.IsReadOnly())
+}
+
+func TestRequestIsReadOnly(t *testing.T) {
+	for _, tc := range []struct {
+		data     string
+		readOnly bool
+	}{
+		{"*2\r\n$3\r\nGET\r\n$1\r\na\r\n", true},
+		{"*2\r\n$6\r\nEXISTS\r\n$1\r\na\r\n", true},
+		{"*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\nb\r\n", false},
+		{"*2\r\n$4\r\nINCR\r\n$1\r\na\r\n", false},
+		{"*1\r\n$4\r\nPING\r\n", false},
+	} {
+		conn := _createConn([]byte(tc.data))
+		br := bufio.NewReader(conn, bufio.Get(1024))
+		br.Read()
+		req := getReq()
+		assert.NoError(t, req.resp.decode(br))
+		assert.Equal(t, tc.readOnly, req.IsReadOnly(), tc.data)
+	}
+}
+
+func TestRequestIsFlush(t *testing.T) {
+	for _, tc := range []struct {
+		data  string
+		flush bool
+	}{
+		{"*1\r\n$7\r\nFLUSHDB\r\n", true},
+		{"*2\r\n$8\r\nFLUSHALL\r\n$5\r\nASYNC\r\n", true},
+		{"*2\r\n$6\r\nSCRIPT\r\n$5\r\nFLUSH\r\n", false},
+		{"*2\r\n$3\r\nDEL\r\n$1\r\na\r\n", false},
+	} {
+		conn := _createConn([]byte(tc.data))
+		br := bufio.NewReader(conn, bufio.Get(1024))
+		br.Read()
+		req := getReq()
+		assert.NoError(t, req.resp.decode(br))
+		assert.Equal(t, tc.flush, req.IsFlush(), tc.data)
+	}
+}
+
+func TestRequestHitReply(t *testing.T) {
+	conn := _createConn([]byte("*2\r\n$3\r\nGET\r\n$1\r\na\r\n$5\r\nhello\r\n$-1\r\n"))
+	br := bufio.NewReader(conn, bufio.Get(1024))
+	br.Read()
+	req := getReq()
+	assert.NoError(t, req.resp.decode(br))
+	assert.True(t, req.IsGet())
+	_, ok := req.HitReply()
+	assert.False(t, ok)
+
+	assert.NoError(t, req.reply.decode(br))
+	data, ok := req.HitReply()
+	assert.True(t, ok)
+	assert.Equal(t, "5\r\nhello", string(data))
+	assert.True(t, req.IsForward())
+
+	assert.NoError(t, req.reply.decode(br))
+	_, ok = req.HitReply()
+	assert.False(t, ok, "nil bulk is miss")
+
+	req.WithHitReply(data)
+	assert.False(t, req.IsForward())
+	assert.Equal(t, "5\r\nhello", string(req.reply.data))
+	_, ok = req.HitReply()
+	assert.False(t, ok, "local reply never cached again")
+}
+
+func TestRequestKeys(t *testing.T) {
+	for _, tc := range []struct {
+		data string
+		key  string
+		keys []string
+	}{
+		{"*3\r\n$6\r\nRENAME\r\n$1\r\na\r\n$1\r\nb\r\n", "a", []string{"a", "b"}},
+		{"*4\r\n$5\r\nSDIFF\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n", "a", []string{"a", "b", "c"}},
+		{"*5\r\n$5\r\nBITOP\r\n$3\r\nAND\r\n$1\r\nd\r\n$1\r\na\r\n$1\r\nb\r\n", "d", []string{"d", "a", "b"}},
+		{"*7\r\n$11\r\nZINTERSTORE\r\n$1\r\nd\r\n$1\r\n2\r\n$1\r\na\r\n$1\r\nb\r\n$7\r\nWEIGHTS\r\n$1\r\n1\r\n", "d", []string{"d", "a", "b"}},
+		{"*5\r\n$4\r\nEVAL\r\n$8\r\nreturn 1\r\n$1\r\n2\r\n$1\r\na\r\n$1\r\nb\r\n", "a", []string{"a", "b"}},
+		{"*3\r\n$4\r\nEVAL\r\n$8\r\nreturn 1\r\n$1\r\n0\r\n", "return 1", nil},
+		{"*4\r\n$4\r\nEVAL\r\n$8\r\nreturn 1\r\n$1\r\n2\r\n$1\r\na\r\n", "a", nil},
+		{"*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\nb\r\n", "a", nil},
+		{"*5\r\n$6\r\nXGROUP\r\n$6\r\nCREATE\r\n$1\r\ns\r\n$1\r\ng\r\n$1\r\n$\r\n", "s", nil},
+		{"*6\r\n$14\r\nGEOSEARCHSTORE\r\n$1\r\nd\r\n$1\r\ns\r\n$10\r\nFROMMEMBER\r\n$1\r\nm\r\n$5\r\nBYBOX\r\n", "d", []string{"d", "s"}},
+	} {
+		conn := _createConn([]byte(tc.data))
+		br := bufio.NewReader(conn, bufio.Get(1024))
+		br.Read()
+		req := getReq()
+		assert.NoError(t, req.resp.decode(br))
+		assert.Equal(t, tc.key, string(req.Key()), tc.data)
+		var keys []string
+		for _, key := range req.Keys() {
+			keys = append(keys, string(key))
+		}
+		assert.Equal(t, tc.keys, keys, tc.data)
+	}
+}
+
+func TestRequestCommands(t *testing.T) {
+	for _, tc := range []struct {
+		data     string
+		support  bool
+		readOnly bool
+	}{
+		{"*5\r\n$4\r\nXADD\r\n$1\r\ns\r\n$1\r\n*\r\n$1\r\nf\r\n$1\r\nv\r\n", true, false},
+		{"*4\r\n$6\r\nXRANGE\r\n$1\r\ns\r\n$1\r\n-\r\n$1\r\n+\r\n", true, true},
+		{"*4\r\n$7\r\nGEODIST\r\n$1\r\ng\r\n$1\r\na\r\n$1\r\nb\r\n", true, true},
+		{"*5\r\n$8\r\nBITFIELD\r\n$1\r\nb\r\n$3\r\nGET\r\n$2\r\nu8\r\n$1\r\n0\r\n", true, false},
+		{"*2\r\n$7\r\nZPOPMIN\r\n$1\r\nz\r\n", true, false},
+		{"*2\r\n$6\r\nGETDEL\r\n$1\r\na\r\n", true, false},
+		{"*2\r\n$10\r\nHRANDFIELD\r\n$1\r\nh\r\n", true, true},
+		{"*3\r\n$4\r\nLPOS\r\n$1\r\nl\r\n$1\r\na\r\n", true, true},
+		{"*3\r\n$10\r\nSMISMEMBER\r\n$1\r\ns\r\n$1\r\na\r\n", true, true},
+		{"*2\r\n$6\r\nSELECT\r\n$1\r\n1\r\n", false, false},
+	} {
+		conn := _createConn([]byte(tc.data))
+		br := bufio.NewReader(conn, bufio.Get(1024))
+		br.Read()
+		req := getReq()
+		assert.NoError(t, req.resp.decode(br))
+		assert.Equal(t, tc.support, req.IsSupport(), tc.data)
+		assert.Equal(t, tc.readOnly, req.IsReadOnly(), tc.data)
+	}
+}
+
+func TestRequestBlockKeys(t *testing.T) {
+	for _, tc := range []struct {
+		data    string
+		keys    []string
+		timeout time.Duration
+		ok      bool
+	}{
+		{"*4\r\n$5\r\nBLPOP\r\n$1\r\na\r\n$1\r\nb\r\n$3\r\n0.5\r\n", []string{"a", "b"}, 1500 * time.Millisecond, true},
+		{"*4\r\n$10\r\nBRPOPLPUSH\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\n0\r\n", []string{"a", "b"}, 0, true},
+		{"*3\r\n$10\r\nBRPOPLPUSH\r\n$1\r\na\r\n$1\r\n0\r\n", nil, 0, true},
+		{"*3\r\n$5\r\nBRPOP\r\n$1\r\na\r\n$1\r\nx\r\n", []string{"a"}, 0, false},
+		{"*8\r\n$5\r\nXREAD\r\n$5\r\nblock\r\n$3\r\n100\r\n$7\r\nSTREAMS\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\n$\r\n$1\r\n$\r\n",
+			[]string{"a", "b"}, 1100 * time.Millisecond, true},
+		{"*8\r\n$10\r\nXREADGROUP\r\n$5\r\nGROUP\r\n$7\r\nstreams\r\n$1\r\nc\r\n$5\r\nCOUNT\r\n$1\r\n1\r\n$7\r\nSTREAMS\r\n$1\r\na\r\n",
+			nil, time.Second, true},
+		{"*9\r\n$10\r\nXREADGROUP\r\n$5\r\nGROUP\r\n$7\r\nstreams\r\n$1\r\nc\r\n$5\r\nCOUNT\r\n$1\r\n1\r\n$7\r\nSTREAMS\r\n$1\r\na\r\n$1\r\n>\r\n",
+			[]string{"a"}, time.Second, true},
+	} {
+		conn := _createConn([]byte(tc.data))
+		br := bufio.NewReader(conn, bufio.Get(1024))
+		br.Read()
+		req := getReq()
+		assert.NoError(t, req.resp.decode(br))
+		c, _ := lookup(req.resp.array[0].data)
+		assert.True(t, c.is(cmdBlocking))
+		var keys []string
+		for _, key := range blockKeys(req.resp) {
+			keys = append(keys, string(key))
+		}
+		assert.Equal(t, tc.keys, keys, tc.data)
+		timeout, ok := blockTimeout(req.resp, time.Second)
+		assert.Equal(t, tc.ok, ok, tc.data)
+		assert.Equal(t, tc.timeout, timeout, tc.data)
+	}
 }
 
 func BenchmarkCmdTypeCheck(b *testing.B) {
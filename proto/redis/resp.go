@@ -2,7 +2,6 @@ package redis
 
 import (
 	"bytes"
-	"fmt"
 	"strconv"
 
 	"overlord/lib/bufio"
@@ -20,6 +19,17 @@ const (
 	respInt     respType = ':'
 	respBulk    respType = '$'
 	respArray   respType = '*'
+	// RESP3 types negotiated by HELLO 3.
+	respNull      respType = '_'
+	respBool      respType = '#'
+	respDouble    respType = ','
+	respBigInt    respType = '('
+	respBlobError respType = '!'
+	respVerbatim  respType = '='
+	respMap       respType = '%'
+	respSet       respType = '~'
+	respAttr      respType = '|'
+	respPush      respType = '>'
 )
 
 var (
@@ -28,10 +38,17 @@ var (
 	respIntBytes    = []byte(":")
 	respBulkBytes   = []byte("$")
 	respArrayBytes  = []byte("*")
+	respNullBytes   = []byte("_")
+	respPushBytes   = []byte(">")
 
 	nullDataBytes = []byte("-1")
+
+	respTypeBytes = []byte("+-:$*_#,(!=%~|>")
 )
 
+// NOTE: same as PROTO_INLINE_MAX_SIZE of redis.
+const inlineMaxSize = 64 * 1024
+
 // RESP is resp export type.
 type RESP = resp
 
@@ -104,47 +121,185 @@ func (r *resp) next() *resp {
 func (r *resp) decode(br *bufio.Reader) (err error) {
 	r.reset()
 	// start read
-	line, err := br.ReadLine()
+	line, err := readLine(br)
 	if err != nil {
 		return err
 	}
 	rTp := line[0]
+	if rTp != respArray && !bytes.HasSuffix(line, crlfBytes) {
+		rTp = respUnknown // NOTE: the inline command may end with LF only, like typed by nc
+	}
 	r.rTp = rTp
 	switch rTp {
-	case respString, respInt, respError:
+	case respString, respInt, respError, respNull, respBool, respDouble, respBigInt:
 		r.data = append(r.data, line[1:len(line)-2]...)
-	case respBulk:
+	case respBulk, respBlobError, respVerbatim:
 		err = r.decodeBulk(line, br)
-	case respArray:
+	case respArray, respMap, respSet, respPush:
 		err = r.decodeArray(line, br)
+	case respAttr:
+		// NOTE: the attributes are auxiliary data of the reply following, which are never replied to client.
+		if err = r.decodeArray(line, br); err == nil {
+			err = r.decode(br)
+		}
 	default:
 		err = r.decodeInline(line)
 	}
 	return
 }
 
+// readLine read the first line of the next resp, the blank lines before are skipped and the inline line over
+// inlineMaxSize is rejected as redis.
+func readLine(br *bufio.Reader) (line []byte, err error) {
+	for {
+		if line, err = br.ReadSlice('\n'); err == bufio.ErrBufferFull {
+			if bs := br.Buffer().Bytes(); len(bs) > inlineMaxSize && !isRespType(bs[0]) {
+				err = ErrInlineTooBig
+			}
+			return
+		} else if err != nil {
+			return
+		}
+		if len(line) > inlineMaxSize && !isRespType(line[0]) {
+			return nil, ErrInlineTooBig
+		}
+		if !isBlank(line) {
+			return
+		}
+	}
+}
+
+func isBlank(line []byte) bool {
+	for _, c := range line {
+		if !isSpace(c) {
+			return false
+		}
+	}
+	return true
+}
+
+func isRespType(c byte) bool {
+	return bytes.IndexByte(respTypeBytes, c) != -1
+}
+
+// decodeInline decode the inline command like "GET foo" into the array of bulks as the multibulk command.
 func (r *resp) decodeInline(line []byte) (err error) {
-	fields := bytes.Fields(line)
-	flen := len(fields)
-	if flen == 0 {
-		err = ErrBadRequest
-		return
+	args, ok := splitArgs(bytes.TrimRight(line, "\r\n"))
+	if !ok {
+		return ErrBadQuotes
 	}
-	r.arrayn = flen
-	r.data = []byte(strconv.Itoa(flen))
-	r.array = make([]*resp, flen)
 	r.rTp = respArray
-	for i, field := range fields {
-		r.array[i] = &resp{
-			rTp:    respBulk,
-			data:   []byte(fmt.Sprintf("%d\r\n%c", len(field), field)),
-			array:  nil,
-			arrayn: 0,
-		}
+	r.data = strconv.AppendInt(r.data, int64(len(args)), 10)
+	for _, arg := range args {
+		nre := r.next()
+		nre.rTp = respBulk
+		nre.data = strconv.AppendInt(nre.data, int64(len(arg)), 10)
+		nre.data = append(nre.data, crlfBytes...)
+		nre.data = append(nre.data, arg...)
 	}
 	return
 }
 
+// splitArgs split the inline command into the arguments like sdssplitargs of redis, the argument can be quoted
+// by double quotes with the escapes like "\n" and "\x0a", or by single quotes with only the escape "\'",
+// false means the quotes are unbalanced or the closing quote is not followed by space.
+func splitArgs(line []byte) (args [][]byte, ok bool) {
+	p, n := 0, len(line)
+	for {
+		for p < n && isSpace(line[p]) {
+			p++
+		}
+		if p == n {
+			return args, true
+		}
+		var (
+			arg       = []byte{}
+			inq, insq bool
+			done      bool
+		)
+		for !done {
+			switch {
+			case inq:
+				if p == n {
+					return nil, false
+				}
+				if line[p] == '\\' && p+3 < n && line[p+1] == 'x' && isHex(line[p+2]) && isHex(line[p+3]) {
+					arg = append(arg, unhex(line[p+2])<<4|unhex(line[p+3]))
+					p += 3
+				} else if line[p] == '\\' && p+1 < n {
+					p++
+					switch c := line[p]; c {
+					case 'n':
+						arg = append(arg, '\n')
+					case 'r':
+						arg = append(arg, '\r')
+					case 't':
+						arg = append(arg, '\t')
+					case 'b':
+						arg = append(arg, '\b')
+					case 'a':
+						arg = append(arg, '\a')
+					default:
+						arg = append(arg, c)
+					}
+				} else if line[p] == '"' {
+					if p+1 < n && !isSpace(line[p+1]) {
+						return nil, false
+					}
+					done = true
+				} else {
+					arg = append(arg, line[p])
+				}
+			case insq:
+				if p == n {
+					return nil, false
+				}
+				if line[p] == '\\' && p+1 < n && line[p+1] == '\'' {
+					p++
+					arg = append(arg, '\'')
+				} else if line[p] == '\'' {
+					if p+1 < n && !isSpace(line[p+1]) {
+						return nil, false
+					}
+					done = true
+				} else {
+					arg = append(arg, line[p])
+				}
+			case p == n || isSpace(line[p]):
+				done = true
+			case line[p] == '"':
+				inq = true
+			case line[p] == '\'':
+				insq = true
+			default:
+				arg = append(arg, line[p])
+			}
+			if p < n {
+				p++
+			}
+		}
+		args = append(args, arg)
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	}
+	return c - 'A' + 10
+}
+
 func (r *resp) decodeBulk(line []byte, br *bufio.Reader) (err error) {
 	ls := len(line)
 	sBs := line[1 : ls-2]
@@ -181,6 +336,9 @@ func (r *resp) decodeArray(line []byte, br *bufio.Reader) (err error) {
 		return
 	}
 	r.data = append(r.data, sBs...)
+	if r.rTp == respMap || r.rTp == respAttr {
+		size *= 2 // NOTE: the keys and the values
+	}
 	mark := br.Mark()
 	for i := 0; i < int(size); i++ {
 		nre := r.next()
@@ -193,27 +351,48 @@ func (r *resp) decodeArray(line []byte, br *bufio.Reader) (err error) {
 	return
 }
 
+// upgrade the RESP2 reply of node into RESP3 by the reply type of command, like the map of HGETALL,
+// and the nil bulk and nil array are upgraded into null.
+func (r *resp) upgrade(rTp respType) {
+	switch r.rTp {
+	case respBulk:
+		if len(r.data) == 0 {
+			r.rTp = respNull
+		} else if rTp == respDouble {
+			r.rTp = respDouble
+			r.data = append(r.data[:0], bulkValue(r.data)...)
+		}
+	case respArray:
+		if len(r.data) == 0 {
+			r.rTp = respNull
+			return
+		}
+		if rTp == respMap && r.arrayn%2 == 0 {
+			r.rTp = respMap
+			r.data = strconv.AppendInt(r.data[:0], int64(r.arrayn/2), 10)
+		} else if rTp == respSet {
+			r.rTp = respSet
+		}
+		for i := 0; i < r.arrayn; i++ {
+			r.array[i].upgrade(respUnknown)
+		}
+	}
+}
+
 func (r *resp) encode(w *bufio.Writer) (err error) {
 	switch r.rTp {
-	case respInt, respString, respError:
+	case respInt, respString, respError, respNull, respBool, respDouble, respBigInt:
 		err = r.encodePlain(w)
-	case respBulk:
+	case respBulk, respBlobError, respVerbatim:
 		err = r.encodeBulk(w)
-	case respArray:
+	case respArray, respMap, respSet, respPush:
 		err = r.encodeArray(w)
 	}
 	return
 }
 
 func (r *resp) encodePlain(w *bufio.Writer) (err error) {
-	switch r.rTp {
-	case respInt:
-		_ = w.Write(respIntBytes)
-	case respError:
-		_ = w.Write(respErrorBytes)
-	case respString:
-		_ = w.Write(respStringBytes)
-	}
+	_ = w.Write([]byte{r.rTp})
 	if len(r.data) > 0 {
 		_ = w.Write(r.data)
 	}
@@ -222,7 +401,7 @@ func (r *resp) encodePlain(w *bufio.Writer) (err error) {
 }
 
 func (r *resp) encodeBulk(w *bufio.Writer) (err error) {
-	_ = w.Write(respBulkBytes)
+	_ = w.Write([]byte{r.rTp})
 	if len(r.data) > 0 {
 		_ = w.Write(r.data)
 	} else {
@@ -233,7 +412,7 @@ func (r *resp) encodeBulk(w *bufio.Writer) (err error) {
 }
 
 func (r *resp) encodeArray(w *bufio.Writer) (err error) {
-	_ = w.Write(respArrayBytes)
+	_ = w.Write([]byte{r.rTp})
 	if len(r.data) > 0 {
 		_ = w.Write(r.data)
 	} else {
@@ -1,6 +1,8 @@
 package redis
 
 import (
+	"strconv"
+	"strings"
 	"testing"
 
 	"overlord/lib/bufio"
@@ -79,6 +81,33 @@ func TestRespDecode(t *testing.T) {
 				[]byte("2"),
 			},
 		},
+		{
+			Name:       "map",
+			Bytes:      []byte("%2\r\n+a\r\n:1\r\n+b\r\n_\r\n"),
+			ExpectTp:   respMap,
+			ExpectLen:  4,
+			ExpectData: []byte("2"),
+			ExpectArr: [][]byte{
+				[]byte("a"),
+				[]byte("1"),
+				[]byte("b"),
+				nil,
+			},
+		},
+		{
+			Name:       "attribute",
+			Bytes:      []byte("|1\r\n+ttl\r\n:3600\r\n,3.14\r\n"),
+			ExpectTp:   respDouble,
+			ExpectLen:  0,
+			ExpectData: []byte("3.14"),
+		},
+		{
+			Name:       "verbatim",
+			Bytes:      []byte("=8\r\ntxt:info\r\n"),
+			ExpectTp:   respVerbatim,
+			ExpectLen:  0,
+			ExpectData: []byte("8\r\ntxt:info"),
+		},
 	}
 	for _, tt := range ts {
 		t.Run(tt.Name, func(t *testing.T) {
@@ -245,6 +274,147 @@ func TestRespEncode(t *testing.T) {
 	}
 }
 
+func TestRespDecodeAndEncodeRESP3(t *testing.T) {
+	for _, data := range []string{
+		"_\r\n",
+		"#t\r\n",
+		",-inf\r\n",
+		"(3492890328409238509324850943850943825024385\r\n",
+		"!21\r\nSYNTAX invalid syntax\r\n",
+		"=15\r\ntxt:Some string\r\n",
+		"%1\r\n$3\r\nkey\r\n*2\r\n#f\r\n_\r\n",
+		"~2\r\n+a\r\n,1.5\r\n",
+		">3\r\n$7\r\nmessage\r\n$2\r\nch\r\n$1\r\nx\r\n",
+	} {
+		r := &resp{}
+		br := bufio.NewReader(_createConn([]byte(data)), bufio.Get(1024))
+		br.Read()
+		if !assert.NoError(t, r.decode(br), data) {
+			continue
+		}
+		conn := _createConn(nil)
+		bw := bufio.NewWriter(conn)
+		assert.NoError(t, r.encode(bw))
+		bw.Flush()
+		assert.Equal(t, data, conn.Conn.(*mockConn).wbuf.String())
+	}
+}
+
+func TestRespUpgrade(t *testing.T) {
+	ts := []struct {
+		Name   string
+		Data   string
+		Type   respType
+		Expect string
+	}{
+		{Name: "nil bulk", Data: "$-1\r\n", Expect: "_\r\n"},
+		{Name: "nil array", Data: "*-1\r\n", Expect: "_\r\n"},
+		{Name: "double", Data: "$4\r\n1.25\r\n", Type: respDouble, Expect: ",1.25\r\n"},
+		{Name: "map", Data: "*4\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$1\r\n2\r\n", Type: respMap, Expect: "%2\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$1\r\n2\r\n"},
+		{Name: "set", Data: "*1\r\n$1\r\na\r\n", Type: respSet, Expect: "~1\r\n$1\r\na\r\n"},
+		{Name: "nested", Data: "*2\r\n$-1\r\n$1\r\na\r\n", Type: respSet, Expect: "~2\r\n_\r\n$1\r\na\r\n"},
+		{Name: "bulk", Data: "$1\r\na\r\n", Expect: "$1\r\na\r\n"},
+		{Name: "error", Data: "-WRONGTYPE\r\n", Type: respMap, Expect: "-WRONGTYPE\r\n"},
+	}
+	for _, tt := range ts {
+		t.Run(tt.Name, func(t *testing.T) {
+			r := &resp{}
+			br := bufio.NewReader(_createConn([]byte(tt.Data)), bufio.Get(1024))
+			br.Read()
+			if !assert.NoError(t, r.decode(br)) {
+				return
+			}
+			r.upgrade(tt.Type)
+			conn := _createConn(nil)
+			bw := bufio.NewWriter(conn)
+			assert.NoError(t, r.encode(bw))
+			bw.Flush()
+			assert.Equal(t, tt.Expect, conn.Conn.(*mockConn).wbuf.String())
+		})
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	ts := []struct {
+		Line   string
+		Expect []string
+		OK     bool
+	}{
+		{Line: "", Expect: nil, OK: true},
+		{Line: "  \t ", Expect: nil, OK: true},
+		{Line: "PING", Expect: []string{"PING"}, OK: true},
+		{Line: " set  foo\tbar ", Expect: []string{"set", "foo", "bar"}, OK: true},
+		{Line: `set "hello world" ""`, Expect: []string{"set", "hello world", ""}, OK: true},
+		{Line: `set k "a\nb\x41\x4a\"\\c\xzz"`, Expect: []string{"set", "k", "a\nbAJ\"\\cxzz"}, OK: true},
+		{Line: `set k 'it\'s "x" \n'`, Expect: []string{"set", "k", `it's "x" \n`}, OK: true},
+		{Line: `set k foo"bar baz"`, Expect: []string{"set", "k", "foobar baz"}, OK: true},
+		{Line: `set k "foo`, OK: false},
+		{Line: `set k 'foo`, OK: false},
+		{Line: `set k "foo"bar`, OK: false},
+		{Line: `set k 'foo'bar`, OK: false},
+	}
+	for _, tt := range ts {
+		args, ok := splitArgs([]byte(tt.Line))
+		assert.Equal(t, tt.OK, ok, tt.Line)
+		var ss []string
+		for _, arg := range args {
+			ss = append(ss, string(arg))
+		}
+		assert.Equal(t, tt.Expect, ss, tt.Line)
+	}
+}
+
+func TestRespDecodeInline(t *testing.T) {
+	br := bufio.NewReader(_createConn([]byte("\r\n\nGET 'foo bar'\nPING\r\nset k \"v\r\n*1\r\n$4\r\nPING\r\nGET")), bufio.Get(1024))
+	br.Read()
+	r := &resp{}
+	assert.NoError(t, r.decode(br), "blank lines skipped")
+	assert.Equal(t, respArray, r.rTp)
+	assert.Equal(t, []byte("2"), r.data)
+	if assert.Equal(t, 2, r.arrayn) {
+		assert.Equal(t, []byte("3\r\nGET"), r.array[0].data)
+		assert.Equal(t, []byte("7\r\nfoo bar"), r.array[1].data)
+	}
+	assert.NoError(t, r.decode(br))
+	assert.Equal(t, 1, r.arrayn)
+	assert.Equal(t, []byte("4\r\nPING"), r.array[0].data)
+	assert.Equal(t, ErrBadQuotes, r.decode(br))
+	assert.NoError(t, r.decode(br), "multibulk after inline")
+	assert.Equal(t, 1, r.arrayn)
+	assert.Equal(t, bufio.ErrBufferFull, r.decode(br), "not ended")
+}
+
+func TestRespDecodeInlineBlankLines(t *testing.T) {
+	br := bufio.NewReader(_createConn([]byte(strings.Repeat(" \r\n\n", 100000)+"PING\r\n")), bufio.Get(1024))
+	for br.Read() == nil && len(br.Buffer().Bytes()) < 300000 {
+	}
+	r := &resp{}
+	assert.NoError(t, r.decode(br), "blank lines skipped without recursion")
+	assert.Equal(t, 1, r.arrayn)
+	assert.Equal(t, []byte("4\r\nPING"), r.array[0].data)
+}
+
+func TestRespDecodeInlineTooBig(t *testing.T) {
+	line := "GET " + strings.Repeat("a", inlineMaxSize)
+	br := bufio.NewReader(_createConn([]byte(line)), bufio.Get(1024))
+	for br.Read() == nil && len(br.Buffer().Bytes()) < len(line) {
+	}
+	r := &resp{}
+	assert.Equal(t, ErrInlineTooBig, r.decode(br), "not ended")
+
+	br = bufio.NewReader(_createConn([]byte(line+"\r\n")), bufio.Get(1024))
+	for br.Read() == nil && len(br.Buffer().Bytes()) < len(line)+2 {
+	}
+	assert.Equal(t, ErrInlineTooBig, r.decode(br), "ended")
+
+	bulk := "*2\r\n$3\r\nGET\r\n$" + strconv.Itoa(inlineMaxSize) + "\r\n" + strings.Repeat("a", inlineMaxSize) + "\r\n"
+	br = bufio.NewReader(_createConn([]byte(bulk)), bufio.Get(1024))
+	for br.Read() == nil && len(br.Buffer().Bytes()) < len(bulk) {
+	}
+	assert.NoError(t, r.decode(br), "multibulk is not limited")
+	assert.Equal(t, 2, r.arrayn)
+}
+
 func TestRESPExportFunc(t *testing.T) {
 	var r = &RESP{
 		rTp:  respString,
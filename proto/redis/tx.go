@@ -0,0 +1,267 @@
+package redis
+
+import (
+	"bytes"
+
+	"overlord/proto"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	cmdMultiBytes   = []byte("5\r\nMULTI")
+	cmdExecBytes    = []byte("4\r\nEXEC")
+	cmdDiscardBytes = []byte("7\r\nDISCARD")
+	cmdWatchBytes   = []byte("5\r\nWATCH")
+	cmdUnwatchBytes = []byte("7\r\nUNWATCH")
+
+	multiRespBytes = []byte("*1\r\n$5\r\nMULTI\r\n")
+	execRespBytes  = []byte("*1\r\n$4\r\nEXEC\r\n")
+
+	queuedBytes             = []byte("QUEUED")
+	multiNestedDataBytes    = []byte("ERR MULTI calls can not be nested")
+	execNoMultiDataBytes    = []byte("ERR EXEC without MULTI")
+	discardNoMultiDataBytes = []byte("ERR DISCARD without MULTI")
+	watchInMultiDataBytes   = []byte("ERR WATCH inside MULTI is not allowed")
+	watchArgsDataBytes      = []byte("ERR wrong number of arguments for 'watch' command")
+	txNotAllowedDataBytes   = []byte("ERR command not allowed in transaction")
+	execAbortDataBytes      = []byte("EXECABORT Transaction discarded because of previous errors.")
+)
+
+// isTx check the command whether controls the transaction.
+func isTx(cmd []byte) bool {
+	return bytes.Equal(cmd, cmdMultiBytes) || bytes.Equal(cmd, cmdExecBytes) || bytes.Equal(cmd, cmdDiscardBytes) ||
+		bytes.Equal(cmd, cmdWatchBytes) || bytes.Equal(cmd, cmdUnwatchBytes)
+}
+
+// tx make the request of the command controls transaction, or queue the command after MULTI,
+// returns false when the command is not of transaction.
+// NOTE: the commands queued are held by proxy until EXEC, then sent as a block by the conn pinned to the node of keys,
+// and WATCH pins the conn at once, so the keys are watched from WATCH as redis.
+func (pc *proxyConn) tx(m *proto.Message, cmd []byte) bool {
+	if (!pc.multi && !isTx(cmd)) || bytes.Equal(cmd, cmdQuitBytes) {
+		return false
+	}
+	r := nextReq(m)
+	r.resp.copy(pc.resp)
+	switch {
+	case bytes.Equal(cmd, cmdMultiBytes):
+		if pc.multi {
+			r.withLocalReply(respError, multiNestedDataBytes)
+			return true
+		}
+		pc.multi = true
+		r.withLocalReply(respString, justOkBytes)
+	case bytes.Equal(cmd, cmdExecBytes):
+		if !pc.multi {
+			r.withLocalReply(respError, execNoMultiDataBytes)
+			return true
+		}
+		r.withPinned()
+		pc.pinning = true
+		for _, q := range pc.queued {
+			if c, _ := lookup(q.array[0].data); !c.is(cmdRead) {
+				for _, key := range cmdKeys(q) {
+					r.txKeys = append(r.txKeys, append([]byte(nil), key...))
+				}
+			}
+		}
+	case bytes.Equal(cmd, cmdDiscardBytes):
+		if !pc.multi {
+			r.withLocalReply(respError, discardNoMultiDataBytes)
+			return true
+		}
+		pc.resetTx()
+		r.withLocalReply(respString, justOkBytes)
+	case bytes.Equal(cmd, cmdWatchBytes) && pc.multi:
+		r.withLocalReply(respError, watchInMultiDataBytes)
+	case bytes.Equal(cmd, cmdWatchBytes):
+		if r.resp.arrayn < 2 {
+			r.withLocalReply(respError, watchArgsDataBytes)
+			return true
+		}
+		r.withPinned()
+		pc.pinning = true
+	case bytes.Equal(cmd, cmdUnwatchBytes) && !pc.multi:
+		pc.unpin()
+		r.withLocalReply(respString, justOkBytes)
+	default:
+		if !txQueueable(r) {
+			pc.aborted = true
+			r.withLocalReply(respError, txNotAllowedDataBytes)
+			return true
+		}
+		n := len(pc.queued)
+		if n < cap(pc.queued) {
+			pc.queued = pc.queued[:n+1]
+		} else {
+			pc.queued = append(pc.queued, &resp{})
+		}
+		pc.queued[n].copy(pc.resp)
+		r.withLocalReply(respString, queuedBytes)
+	}
+	return true
+}
+
+// txQueueable check the command whether can be queued in transaction, the commands split by proxy like MSET
+// are sent as is, but the broadcast and SCAN are of all the nodes.
+func txQueueable(r *Request) bool {
+	cmd := r.resp.array[0].data
+	if bytes.Equal(cmd, cmdUnwatchBytes) || bytes.Equal(cmd, cmdPingBytes) {
+		return true
+	}
+	if !r.IsForward() || isSubscribe(cmd) || bytes.Equal(cmd, cmdScanBytes) {
+		return false
+	}
+	c, _ := lookup(cmd)
+	return !c.is(cmdBroadcast)
+}
+
+// pin make the reply of WATCH, EXEC or the blocking command by the dedicated conn, the error means the pinned conn broken
+// and the client conn should be closed, for the keys watched are lost or the transaction may be executed.
+func (pc *proxyConn) pin(r *Request) error {
+	switch cmd := r.resp.array[0].data; {
+	case bytes.Equal(cmd, cmdWatchBytes):
+		return pc.watch(r)
+	case bytes.Equal(cmd, cmdExecBytes):
+		return pc.exec(r)
+	}
+	return pc.block(r)
+}
+
+func (pc *proxyConn) watch(r *Request) (err error) {
+	if pc.router == nil {
+		r.withLocalReply(respError, notSupportDataBytes)
+		return
+	}
+	keys := append([][]byte(nil), pc.watched...)
+	for i := 1; i < r.resp.arrayn; i++ {
+		keys = append(keys, bulkValue(r.resp.array[i].data))
+	}
+	if !pc.router.SameNode(keys) {
+		r.WithCrossSlot()
+		return
+	}
+	sc, perr := pc.pinTo(keys[0])
+	if perr != nil {
+		r.withPinError(perr)
+		return
+	}
+	_ = r.resp.encode(sc.bw)
+	if err = sc.bw.Flush(); err != nil {
+		return errors.WithStack(err)
+	}
+	if err = sc.decode(r.reply); err != nil {
+		return errors.WithStack(err)
+	}
+	if r.reply.rTp != respError {
+		for _, key := range keys[len(pc.watched):] {
+			pc.watched = append(pc.watched, append([]byte(nil), key...))
+		}
+	}
+	return
+}
+
+// exec send MULTI, the commands queued and EXEC by the pinned conn, and the reply of EXEC is replied.
+// All the keys watched and queued must be in the same node or slot, otherwise the transaction is discarded.
+func (pc *proxyConn) exec(r *Request) (err error) {
+	defer pc.resetTx()
+	if pc.aborted {
+		r.withLocalReply(respError, execAbortDataBytes)
+		return
+	}
+	if pc.router == nil {
+		r.withLocalReply(respError, notSupportDataBytes)
+		return
+	}
+	if len(pc.queued) == 0 && pc.pinned == nil {
+		r.withLocalReply(respArray, zeroBytes) // NOTE: *0
+		return
+	}
+	keys := append([][]byte(nil), pc.watched...)
+	for _, q := range pc.queued {
+		keys = append(keys, cmdKeys(q)...)
+	}
+	if len(keys) > 1 && !pc.router.SameNode(keys) {
+		r.WithCrossSlot()
+		return
+	}
+	key := emptyBytes
+	if len(keys) > 0 {
+		key = keys[0]
+	} else if q := pc.queued[0]; q.arrayn > 1 {
+		key = bulkValue(q.array[1].data) // NOTE: like EVAL without keys
+	}
+	sc, perr := pc.pinTo(key)
+	if perr != nil {
+		r.withPinError(perr)
+		return
+	}
+	_ = sc.bw.Write(multiRespBytes)
+	for _, q := range pc.queued {
+		_ = q.encode(sc.bw)
+	}
+	_ = sc.bw.Write(execRespBytes)
+	if err = sc.bw.Flush(); err != nil {
+		return errors.WithStack(err)
+	}
+	for i := 0; i < len(pc.queued)+2; i++ { // NOTE: the last is the reply of EXEC
+		if err = sc.decode(r.reply); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return
+}
+
+// withPinError reply the error of the dedicated conn which is not dialed.
+func (r *Request) withPinError(err error) {
+	r.withLocalReply(respError, errPrefixBytes)
+	r.reply.data = append(r.reply.data, errors.Cause(err).Error()...)
+}
+
+// pinTo returns the pinned conn, or dial the new one to the node of key.
+func (pc *proxyConn) pinTo(key []byte) (*pinConn, error) {
+	if pc.pinned != nil {
+		return pc.pinned, nil
+	}
+	sc, err := pc.dialKey(key)
+	if err != nil {
+		return nil, err
+	}
+	pc.pinned = sc
+	return sc, nil
+}
+
+// dialKey dial the dedicated conn to the node of key.
+func (pc *proxyConn) dialKey(key []byte) (*pinConn, error) {
+	addr, ok := pc.router.KeyNode(key)
+	if !ok {
+		return nil, errors.WithStack(ErrNoNode)
+	}
+	return pc.dial(addr)
+}
+
+// resetTx discard the commands queued and the keys watched.
+func (pc *proxyConn) resetTx() {
+	pc.multi, pc.aborted = false, false
+	pc.queued = pc.queued[:0]
+	pc.unpin()
+}
+
+// unpin close the pinned conn, so the keys watched are released.
+func (pc *proxyConn) unpin() {
+	pc.watched = pc.watched[:0]
+	if pc.pinned != nil {
+		pc.pinned.close()
+		pc.pinned = nil
+	}
+}
+
+// cmdKeys returns all the keys of command by the key positions.
+func cmdKeys(re *resp) [][]byte {
+	c, _ := lookup(re.array[0].data)
+	if c.is(cmdBlocking) {
+		return blockKeys(re)
+	}
+	return c.kp.keys(re)
+}
@@ -0,0 +1,32 @@
+package proto
+
+// ForwarderState is the snapshot of forwarder, used by admin.
+type ForwarderState struct {
+	Nodes []*NodeState `json:"nodes"`
+	Slots []*SlotRange `json:"slots,omitempty"`
+}
+
+// NodeState is the snapshot of backend node.
+type NodeState struct {
+	Name         string   `json:"name"`
+	Addr         string   `json:"addr"`
+	Role         string   `json:"role,omitempty"`     // master or replica
+	Replicas     []string `json:"replicas,omitempty"` // replicas of the group
+	Down         []string `json:"down,omitempty"`     // addrs failed to ping
+	Failover     string   `json:"failover,omitempty"` // replica serving the writes when primary is down
+	Weight       int      `json:"weight,omitempty"`
+	Ejected      bool     `json:"ejected"`
+	Manual       bool     `json:"manual,omitempty"` // ejected by admin
+	PingFailures int      `json:"ping_failures"`
+	Conns        int32    `json:"conns"`
+	Breaker      string   `json:"breaker,omitempty"` // circuit breaker state when enabled
+}
+
+// SlotRange is the continuous slots served by the same node.
+type SlotRange struct {
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Node  string `json:"node"`
+	// Replicas serve the reads of slots when the read policy is not master_only.
+	Replicas []string `json:"replicas,omitempty"`
+}
@@ -2,11 +2,16 @@ package proto
 
 import (
 	errs "errors"
+
+	libnet "overlord/lib/net"
 )
 
 // errors
 var (
-	ErrNoSupportCacheType = errs.New("unsupported cache type")
+	ErrNoSupportCacheType  = errs.New("unsupported cache type")
+	ErrNoSupportReadPolicy = errs.New("unsupported read policy")
+	// ErrRateLimited is replied by proxy when the requests exceed the rate limit.
+	ErrRateLimited = errs.New("rate limit exceeded")
 )
 
 // CacheType memcache or redis
@@ -21,6 +26,25 @@ const (
 	CacheTypeRedisCluster   CacheType = "redis_cluster"
 )
 
+// ReadPolicy decides which nodes the read-only requests are forwarded to.
+type ReadPolicy string
+
+// Read policy: reads are always forwarded to master by default.
+const (
+	ReadPolicyMasterOnly    ReadPolicy = "master_only"
+	ReadPolicyPreferReplica ReadPolicy = "prefer_replica"
+	ReadPolicyRoundRobin    ReadPolicy = "round_robin"
+)
+
+// CheckReadPolicy check the read policy whether supported, empty means the default master_only.
+func CheckReadPolicy(rp ReadPolicy) error {
+	switch rp {
+	case "", ReadPolicyMasterOnly, ReadPolicyPreferReplica, ReadPolicyRoundRobin:
+		return nil
+	}
+	return ErrNoSupportReadPolicy
+}
+
 // Request request interface.
 type Request interface {
 	CmdString() string
@@ -29,6 +53,68 @@ type Request interface {
 	Put()
 }
 
+// Broadcaster is implemented by the requests which are sent to all the nodes of cluster,
+// and the replies are merged by ProxyConn.
+type Broadcaster interface {
+	// IsBroadcast check the request whether need to be sent to all the nodes.
+	IsBroadcast() bool
+	// Broadcast copy the request for every node into the message in order, so the message is batch when more than one node.
+	Broadcast(m *Message, nodes []string)
+}
+
+// Scanner is implemented by the requests which scan all the nodes of cluster one by one by the cursor,
+// the cursor replied by ProxyConn encodes the index of node and the cursor of node.
+type Scanner interface {
+	// IsScan check the request whether scans the nodes.
+	IsScan() bool
+	// Scan returns the index of node to be scanned in the nodes of stable order, and rewrite the request for the node.
+	// False means the cursor is invalid for the nodes, and the request is replied with error by proxy.
+	Scan(nodes int) (int, bool)
+}
+
+// MultiKeyer is implemented by the requests which may contain more than one key,
+// and all the keys must be in the same node, or the same slot of redis cluster.
+type MultiKeyer interface {
+	// Keys returns all the keys of request, nil when the request is not multi-key.
+	Keys() [][]byte
+	// WithCrossSlot reply the error by proxy that the keys are not in the same node or slot.
+	WithCrossSlot()
+}
+
+// Pinner is implemented by ProxyConn which pins the requests of client to the dedicated conns of nodes,
+// like the subscribe mode, the transactions and the blocking commands of redis.
+type Pinner interface {
+	// WithRouter set the router of dedicated conns before any request decoded, nil means not supported.
+	WithRouter(router Router)
+	// Interrupt fail the request blocked by the dedicated conn, safe for concurrent use.
+	Interrupt()
+	// Close close the dedicated conns when the client conn closed.
+	Close() error
+}
+
+// Subscriber is implemented by ProxyConn which can turn into the subscribe mode of pub/sub,
+// the requests and replies are no longer one by one in the mode, so the conn is served by Subscribe.
+type Subscriber interface {
+	// IsSubscribing check the conn whether turned into subscribe mode by the requests decoded last.
+	IsSubscribing() bool
+	// Subscribe serve the subscribe mode until all the channels and patterns unsubscribed,
+	// the error means the client quit or the conn broken.
+	// NOTE: the client conn should never time out reading in the mode.
+	Subscribe() error
+}
+
+// Router is implemented by forwarders which route the dedicated conns of client to nodes.
+type Router interface {
+	// KeyNode returns the node which the key or the channel is forwarded to.
+	KeyNode(key []byte) (string, bool)
+	// PatternNodes returns the nodes which the patterns are subscribed on, so all the messages published can be matched.
+	PatternNodes() []string
+	// SameNode check all the keys whether in the same node, or the same slot of redis cluster.
+	SameNode(keys [][]byte) bool
+	// Dial dial the dedicated conn of client to node with the timeouts of cluster.
+	Dial(addr string) (*libnet.Conn, error)
+}
+
 // ProxyConn decode bytes from client and encode write to conn.
 type ProxyConn interface {
 	Decode([]*Message) ([]*Message, error)
@@ -53,5 +139,7 @@ type Pinger interface {
 // Forwarder is the interface for backend run and process the messages.
 type Forwarder interface {
 	Forward([]*Message) error
+	// Update apply the new servers config without interrupting forwarding.
+	Update(servers []string) error
 	Close() error
 }
@@ -0,0 +1,173 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	errs "errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"overlord/lib/log"
+	"overlord/proto"
+)
+
+// admin errors
+var (
+	ErrAdminNoSuchCluster = errs.New("admin no such cluster")
+	ErrAdminNotSupport    = errs.New("admin not support the cluster")
+	ErrAdminDisabled      = errs.New("admin api disabled without admin_token")
+	ErrAdminUnauthorized  = errs.New("admin token mismatch")
+)
+
+const (
+	adminSecretMask  = "******"
+	adminTokenPrefix = "Bearer "
+)
+
+// stater is implemented by forwarders which can report the state.
+type stater interface {
+	State() *proto.ForwarderState
+}
+
+// ejecter is implemented by forwarders which can eject and re-add node by hand.
+type ejecter interface {
+	Eject(node string) error
+	Readd(node string) error
+}
+
+// ClusterState is the admin report of cluster.
+type ClusterState struct {
+	Config *ClusterConfig        `json:"config"`
+	State  *proto.ForwarderState `json:"state,omitempty"`
+}
+
+// RegisterAdmin register the admin handlers into mux:
+//
+//	GET  /admin/clusters[?name=cluster]         report the clusters state.
+//	POST /admin/eject?cluster=name&node=node    eject node from the hash ring by hand.
+//	POST /admin/readd?cluster=name&node=node    re-add node into the hash ring.
+//
+// The POST api require the header "Authorization: Bearer <admin_token>", and are disabled when admin_token is empty.
+func (p *Proxy) RegisterAdmin(mux *http.ServeMux) {
+	mux.HandleFunc("/admin/clusters", p.adminClusters)
+	mux.HandleFunc("/admin/eject", p.adminEject)
+	mux.HandleFunc("/admin/readd", p.adminReadd)
+}
+
+// ClusterStates returns the clusters state order by name, all clusters returned when name is empty.
+func (p *Proxy) ClusterStates(name string) ([]*ClusterState, error) {
+	p.lock.Lock()
+	type pair struct {
+		cc *ClusterConfig
+		f  proto.Forwarder
+	}
+	var pairs []pair
+	for cname, cc := range p.ccs {
+		if name != "" && cname != name {
+			continue
+		}
+		pairs = append(pairs, pair{cc: cc, f: p.forwarders[cname]})
+	}
+	p.lock.Unlock()
+	if name != "" && len(pairs) == 0 {
+		return nil, ErrAdminNoSuchCluster
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].cc.Name < pairs[j].cc.Name })
+	css := make([]*ClusterState, 0, len(pairs))
+	for _, pr := range pairs {
+		cc := *pr.cc // NOTE: copy for hiding the secrets
+		if cc.RedisAuth != "" {
+			cc.RedisAuth = adminSecretMask
+		}
+		if cc.Password != "" {
+			cc.Password = adminSecretMask
+		}
+		cs := &ClusterState{Config: &cc}
+		if st, ok := pr.f.(stater); ok {
+			cs.State = st.State()
+		}
+		css = append(css, cs)
+	}
+	return css, nil
+}
+
+func (p *Proxy) getEjecter(name string) (ejecter, error) {
+	p.lock.Lock()
+	f, ok := p.forwarders[name]
+	p.lock.Unlock()
+	if !ok {
+		return nil, ErrAdminNoSuchCluster
+	}
+	ej, ok := f.(ejecter)
+	if !ok {
+		return nil, ErrAdminNotSupport
+	}
+	return ej, nil
+}
+
+func (p *Proxy) adminClusters(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	css, err := p.ClusterStates(r.FormValue("name"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(css); err != nil && log.V(2) {
+		log.Errorf("admin encode clusters state error:%v", err)
+	}
+}
+
+func (p *Proxy) adminEject(w http.ResponseWriter, r *http.Request) {
+	p.adminNode(w, r, "eject", ejecter.Eject)
+}
+
+func (p *Proxy) adminReadd(w http.ResponseWriter, r *http.Request) {
+	p.adminNode(w, r, "readd", ejecter.Readd)
+}
+
+func (p *Proxy) adminNode(w http.ResponseWriter, r *http.Request, op string, do func(ejecter, string) error) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if err := p.adminAuth(r); err == ErrAdminDisabled {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if err != nil {
+		log.Warnf("admin cluster %s unauthorized from %s", op, r.RemoteAddr)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	cluster, node := r.FormValue("cluster"), r.FormValue("node")
+	ej, err := p.getEjecter(cluster)
+	if err == ErrAdminNoSuchCluster {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err = do(ej, node); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	log.Infof("admin cluster(%s) %s node:%s from %s", cluster, op, node, r.RemoteAddr)
+	w.Write([]byte("OK\n"))
+}
+
+func (p *Proxy) adminAuth(r *http.Request) error {
+	if p.c.AdminToken == "" {
+		return ErrAdminDisabled
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, adminTokenPrefix) ||
+		subtle.ConstantTimeCompare([]byte(auth[len(adminTokenPrefix):]), []byte(p.c.AdminToken)) != 1 {
+		return ErrAdminUnauthorized
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"overlord/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestAdmin(t *testing.T) (*Proxy, *defaultForwarder, *httptest.Server) {
+	f := newTestForwarder("127.0.0.1:21301:1 mc1", "127.0.0.1:21302:2 mc2")
+	f.cc.RedisAuth = "secret"
+	p := &Proxy{
+		c:          &Config{AdminToken: "token"},
+		ccs:        map[string]*ClusterConfig{f.cc.Name: f.cc},
+		forwarders: map[string]proto.Forwarder{f.cc.Name: f},
+	}
+	mux := http.NewServeMux()
+	p.RegisterAdmin(mux)
+	return p, f, httptest.NewServer(mux)
+}
+
+func adminPost(url, token string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return http.DefaultClient.Do(req)
+}
+
+func TestAdminClusters(t *testing.T) {
+	_, f, ts := newTestAdmin(t)
+	defer ts.Close()
+	defer f.Close()
+
+	resp, err := http.Get(ts.URL + "/admin/clusters")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var css []*ClusterState
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&css))
+	if !assert.Len(t, css, 1) {
+		return
+	}
+	assert.Equal(t, "test-forwarder", css[0].Config.Name)
+	assert.Equal(t, adminSecretMask, css[0].Config.RedisAuth)
+	assert.Equal(t, "secret", f.cc.RedisAuth)
+	if assert.Len(t, css[0].State.Nodes, 2) {
+		assert.Equal(t, "mc1", css[0].State.Nodes[0].Name)
+		assert.Equal(t, "127.0.0.1:21301", css[0].State.Nodes[0].Addr)
+		assert.Equal(t, int32(1), css[0].State.Nodes[0].Conns)
+		assert.Equal(t, 2, css[0].State.Nodes[1].Weight)
+		assert.False(t, css[0].State.Nodes[1].Ejected)
+	}
+
+	resp, err = http.Get(ts.URL + "/admin/clusters?name=none")
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	}
+}
+
+func TestAdminEjectAndReadd(t *testing.T) {
+	p, f, ts := newTestAdmin(t)
+	defer ts.Close()
+	defer f.Close()
+
+	resp, err := http.Get(ts.URL + "/admin/eject?cluster=test-forwarder&node=mc1")
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+	resp, err = adminPost(ts.URL+"/admin/eject?cluster=test-forwarder&node=127.0.0.1:21301", "token")
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	css, err := p.ClusterStates("test-forwarder")
+	assert.NoError(t, err)
+	assert.True(t, css[0].State.Nodes[0].Ejected)
+	assert.True(t, css[0].State.Nodes[0].Manual)
+	nodes, _ := f.ring.Nodes()
+	assert.Equal(t, []string{"mc2"}, nodes)
+
+	// NOTE: manual ejected node never be re-added by pinger
+	pg := &pinger{node: "127.0.0.1:21301", name: "mc1", stop: make(chan struct{})}
+	assert.True(t, f.readdNode(pg))
+	nodes, _ = f.ring.Nodes()
+	assert.Equal(t, []string{"mc2"}, nodes)
+
+	resp, err = adminPost(ts.URL+"/admin/readd?cluster=test-forwarder&node=mc1", "token")
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	nodes, _ = f.ring.Nodes()
+	assert.Equal(t, []string{"mc1", "mc2"}, nodes)
+
+	resp, err = adminPost(ts.URL+"/admin/eject?cluster=test-forwarder&node=mc3", "token")
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	}
+	resp, err = adminPost(ts.URL+"/admin/eject?cluster=none&node=mc1", "token")
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	}
+}
+
+func TestAdminToken(t *testing.T) {
+	p, f, ts := newTestAdmin(t)
+	defer ts.Close()
+	defer f.Close()
+
+	for _, token := range []string{"", "bad", "token2"} {
+		resp, err := adminPost(ts.URL+"/admin/eject?cluster=test-forwarder&node=mc1", token)
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, token)
+		}
+	}
+	p.c.AdminToken = ""
+	resp, err := adminPost(ts.URL+"/admin/eject?cluster=test-forwarder&node=mc1", "")
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode, "disabled")
+	}
+	nodes, _ := f.ring.Nodes()
+	assert.Equal(t, []string{"mc1", "mc2"}, nodes)
+}
@@ -0,0 +1,240 @@
+package proxy
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"overlord/lib/log"
+	"overlord/lib/prom"
+	"overlord/proto"
+	"overlord/proto/memcache"
+)
+
+const (
+	// backupMaxQueued is the max batches of writes queued for copying to backup cluster, the more are dropped.
+	backupMaxQueued = 1024
+)
+
+// fallbacker is implemented by forwarders which forward the failed or missed reads to another cluster,
+// like the backup cluster or the L2 cluster.
+type fallbacker interface {
+	Fallback(msgs []*proto.Message, wg *sync.WaitGroup)
+}
+
+// backupRef wraps the backup forwarder for atomic.Value never stores nil.
+type backupRef struct {
+	f proto.Forwarder
+}
+
+// backupForwarder copies the writes to the backup cluster asynchronously,
+// and the reads failed or missed by primary fall back to the backup cluster.
+type backupForwarder struct {
+	*defaultForwarder
+
+	backup atomic.Value // NOTE: linked by proxy, see Proxy.linkBackups
+	copies *asyncQueue  // NOTE: the writes are copied in order, so the backup never keeps the stale value
+}
+
+func newBackupForwarder(cc *ClusterConfig) proto.Forwarder {
+	f := &backupForwarder{
+		defaultForwarder: newDefaultForwarder(cc).(*defaultForwarder),
+		copies:           newAsyncQueue(backupMaxQueued),
+	}
+	f.backup.Store(backupRef{})
+	return f
+}
+
+// setBackup link the backup forwarder, nil means the backup cluster absent.
+// NOTE: the backup of backup cluster is never used, so the writes never be copied again.
+func (f *backupForwarder) setBackup(b proto.Forwarder) {
+	if bf, ok := b.(*backupForwarder); ok {
+		b = bf.defaultForwarder
+	}
+	f.backup.Store(backupRef{f: b})
+}
+
+func (f *backupForwarder) getBackup() proto.Forwarder {
+	return f.backup.Load().(backupRef).f
+}
+
+// Forward impl proto.Forwarder, the writes are copied before forwarding for the reply overwrites the request.
+func (f *backupForwarder) Forward(msgs []*proto.Message) error {
+	if backup := f.getBackup(); backup != nil {
+		f.copyWrites(backup, msgs)
+	}
+	return f.defaultForwarder.Forward(msgs)
+}
+
+func (f *backupForwarder) copyWrites(backup proto.Forwarder, msgs []*proto.Message) {
+	var copies []*proto.Message
+	for _, m := range msgs {
+		if m.IsBatch() {
+			continue // NOTE: only retrieval commands can be batch
+		}
+		req, ok := m.Request().(*memcache.MCRequest)
+		if !ok || !(req.IsWrite() || req.IsFlush()) {
+			continue
+		}
+		cm := proto.NewMessage()
+		cm.Type = m.Type
+		cm.WithRequest(req.Clone())
+		copies = append(copies, cm)
+	}
+	if len(copies) == 0 {
+		return
+	}
+	ok := f.copies.push(backup, copies, func(copies []*proto.Message) {
+		for _, cm := range copies {
+			if err := cm.Err(); err != nil && log.V(3) {
+				log.Warnf("cluster(%s) backup cluster(%s) write error:%v", f.cc.Name, f.cc.BackupCluster, err)
+			}
+		}
+		if prom.On {
+			prom.BackupIncr(f.cc.Name, "write")
+		}
+	})
+	if !ok {
+		if prom.On {
+			prom.BackupIncr(f.cc.Name, "drop")
+		}
+		if log.V(3) {
+			log.Warnf("cluster(%s) backup cluster(%s) too many queued writes and drop", f.cc.Name, f.cc.BackupCluster)
+		}
+	}
+}
+
+// asyncBatch is the messages forwarded in background, done is called after replied.
+type asyncBatch struct {
+	to   proto.Forwarder
+	msgs []*proto.Message
+	wg   *sync.WaitGroup
+	done func([]*proto.Message)
+}
+
+// asyncQueue forward the messages in background by the order pushed, the batches are forwarded one by one
+// by the forwarding goroutine, and waited and released by the other, so the later never overtakes.
+// NOTE: the messages of the same key are pushed into the same node conn, so they are written to node in order.
+type asyncQueue struct {
+	batches chan *asyncBatch
+	pending chan *asyncBatch
+
+	lock   sync.RWMutex
+	closed bool
+}
+
+func newAsyncQueue(size int) *asyncQueue {
+	q := &asyncQueue{
+		batches: make(chan *asyncBatch, size),
+		pending: make(chan *asyncBatch, size),
+	}
+	go q.forward()
+	go q.release()
+	return q
+}
+
+// push the messages into queue, it returns false and the messages are released when the queue is full or closed.
+func (q *asyncQueue) push(to proto.Forwarder, msgs []*proto.Message, done func([]*proto.Message)) (ok bool) {
+	b := &asyncBatch{to: to, msgs: msgs, wg: &sync.WaitGroup{}, done: done}
+	q.lock.RLock()
+	if !q.closed {
+		select {
+		case q.batches <- b:
+			ok = true
+		default:
+		}
+	}
+	q.lock.RUnlock()
+	if !ok {
+		proto.PutMsgs(msgs)
+	}
+	return
+}
+
+func (q *asyncQueue) forward() {
+	for b := range q.batches {
+		for _, m := range b.msgs {
+			m.WithWaitGroup(b.wg)
+		}
+		_ = b.to.Forward(b.msgs)
+		q.pending <- b
+	}
+	close(q.pending)
+}
+
+func (q *asyncQueue) release() {
+	for b := range q.pending {
+		b.wg.Wait()
+		if b.done != nil {
+			b.done(b.msgs)
+		}
+		proto.PutMsgs(b.msgs)
+	}
+}
+
+// close the queue, the batches queued are still forwarded.
+func (q *asyncQueue) close() {
+	q.lock.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.batches)
+	}
+	q.lock.Unlock()
+}
+
+// Close impl proto.Forwarder, the writes queued are still copied.
+func (f *backupForwarder) Close() error {
+	f.copies.close()
+	return f.defaultForwarder.Close()
+}
+
+// Fallback forward the reads failed or missed by primary to the backup cluster and wait for the replies,
+// the miss of primary is kept when the backup failed too.
+func (f *backupForwarder) Fallback(msgs []*proto.Message, wg *sync.WaitGroup) {
+	backup := f.getBackup()
+	if backup == nil {
+		return
+	}
+	var (
+		fbs    []*proto.Message
+		missed []bool
+	)
+	collect := func(m *proto.Message) {
+		req, ok := m.Request().(*memcache.MCRequest)
+		if !ok || !req.IsReadOnly() {
+			return
+		}
+		if m.Err() != nil {
+			m.WithError(nil)
+			missed = append(missed, false)
+		} else if req.IsMiss() {
+			missed = append(missed, true)
+		} else {
+			return
+		}
+		req.ResetRetrieval()
+		fbs = append(fbs, m)
+	}
+	for _, m := range msgs {
+		if !m.IsBatch() {
+			collect(m)
+			continue
+		}
+		for _, subm := range m.Batch() {
+			collect(subm)
+		}
+	}
+	if len(fbs) == 0 {
+		return
+	}
+	if prom.On {
+		prom.BackupIncr(f.cc.Name, "fallback")
+	}
+	_ = backup.Forward(fbs)
+	wg.Wait()
+	for i, m := range fbs {
+		if missed[i] && m.Err() != nil {
+			m.WithError(nil)
+			m.Request().(*memcache.MCRequest).WithMiss()
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackupCopyWritesAndFallback(t *testing.T) {
+	primary, backup := newFakeMemcache(t), newFakeMemcache(t)
+	defer primary.Close()
+	defer backup.Close()
+	p, rw := newServedTestProxy(t, newServedClusterConfig("primary", "backup", primary.Addr().String()+":1"),
+		newServedClusterConfig("backup", "", backup.Addr().String()+":1"))
+	defer p.Close()
+
+	assert.Equal(t, "STORED\r\n", roundTrip(t, rw, "set a_11 0 0 5\r\nhello\r\n", 1))
+	for i := 0; i < 100; i++ {
+		if _, ok := backup.get("a_11"); ok {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	v, ok := backup.get("a_11")
+	assert.True(t, ok, "write should be copied to backup")
+	assert.Equal(t, "hello", v)
+
+	// NOTE: lost by primary, fall back to backup.
+	primary.lock.Lock()
+	delete(primary.kvs, "a_11")
+	primary.lock.Unlock()
+	primary.set("a_22", "world")
+	assert.Equal(t, "VALUE a_11 0 5\r\nhello\r\nEND\r\n", roundTrip(t, rw, "get a_11\r\n", 3))
+	reply := roundTrip(t, rw, "get a_11 a_22 a_33\r\n", 5)
+	assert.Equal(t, "VALUE a_11 0 5\r\nhello\r\nVALUE a_22 0 5\r\nworld\r\nEND\r\n", reply)
+	assert.Equal(t, "END\r\n", roundTrip(t, rw, "get a_33\r\n", 1))
+}
+
+func TestBackupFallbackPrimaryDown(t *testing.T) {
+	primary, backup := newFakeMemcache(t), newFakeMemcache(t)
+	defer backup.Close()
+	addr := primary.Addr().String()
+	primary.Close()
+	p, rw := newServedTestProxy(t, newServedClusterConfig("primary", "backup", addr+":1"),
+		newServedClusterConfig("backup", "", backup.Addr().String()+":1"))
+	defer p.Close()
+
+	backup.set("a_11", "hello")
+	assert.Equal(t, "VALUE a_11 0 5\r\nhello\r\nEND\r\n", roundTrip(t, rw, "get a_11\r\n", 3))
+}
+
+func TestBackupKeepMissWhenBackupDown(t *testing.T) {
+	primary, backup := newFakeMemcache(t), newFakeMemcache(t)
+	defer primary.Close()
+	addr := backup.Addr().String()
+	backup.Close()
+	p, rw := newServedTestProxy(t, newServedClusterConfig("primary", "backup", primary.Addr().String()+":1"),
+		newServedClusterConfig("backup", "", addr+":1"))
+	defer p.Close()
+
+	assert.Equal(t, "END\r\n", roundTrip(t, rw, "get a_11\r\n", 1))
+}
+
+func TestBackupCopyWritesInOrder(t *testing.T) {
+	primary, backup := newFakeMemcache(t), newFakeMemcache(t)
+	defer primary.Close()
+	defer backup.Close()
+	p, rw := newServedTestProxy(t, newServedClusterConfig("primary", "backup", primary.Addr().String()+":1"),
+		newServedClusterConfig("backup", "", backup.Addr().String()+":1"))
+	defer p.Close()
+
+	for i := 0; i < 50; i++ {
+		assert.Equal(t, "STORED\r\n", roundTrip(t, rw, fmt.Sprintf("set a_11 0 0 3\r\nv%02d\r\n", i), 1))
+		assert.Equal(t, "DELETED\r\n", roundTrip(t, rw, "delete a_11\r\n", 1))
+	}
+	assert.Equal(t, "STORED\r\n", roundTrip(t, rw, "set a_11 0 0 3\r\nend\r\n", 1))
+	// NOTE: the last copy, every copy before is written to backup once it is.
+	assert.Equal(t, "STORED\r\n", roundTrip(t, rw, "set a_22 0 0 3\r\nend\r\n", 1))
+	for i := 0; i < 100; i++ {
+		if _, ok := backup.get("a_22"); ok {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	v, ok := backup.get("a_11")
+	assert.True(t, ok, "the last write should be kept by backup")
+	assert.Equal(t, "end", v)
+}
+
+func TestBackupCopyFlush(t *testing.T) {
+	primary, backup := newFakeMemcache(t), newFakeMemcache(t)
+	defer primary.Close()
+	defer backup.Close()
+	p, rw := newServedTestProxy(t, newServedClusterConfig("primary", "backup", primary.Addr().String()+":1"),
+		newServedClusterConfig("backup", "", backup.Addr().String()+":1"))
+	defer p.Close()
+
+	backup.set("a_11", "hello")
+	assert.Equal(t, "OK\r\n", roundTrip(t, rw, "flush_all\r\n", 1))
+	waitFakeKey(backup, "a_11", false)
+	_, ok := backup.get("a_11")
+	assert.False(t, ok, "flush_all should be copied to backup")
+}
@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"overlord/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// list serve LPUSH and BLPOP of fakeRedis, BLPOP blocks until any element pushed or timeout.
+func (rs *fakeRedis) list(cmd string, args []string) string {
+	if cmd == "LPUSH" {
+		rs.pushed <- args[1:3]
+		return ":1\r\n"
+	}
+	var timeout <-chan time.Time // NOTE: 0 means blocked forever
+	if sec, _ := strconv.ParseFloat(args[len(args)-1], 64); sec > 0 {
+		timeout = time.After(time.Duration(sec * float64(time.Second)))
+	}
+	select {
+	case kv := <-rs.pushed:
+		return fmt.Sprintf("*2\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(kv[0]), kv[0], len(kv[1]), kv[1])
+	case <-timeout:
+		return "*-1\r\n"
+	}
+}
+
+func TestHandlerBlocking(t *testing.T) {
+	rs1, rs2 := newFakeRedis(t), newFakeRedis(t)
+	defer rs1.Close()
+	defer rs2.Close()
+	cc := newServedClusterConfig("block", "", rs1.Addr().String()+":1", rs2.Addr().String()+":1")
+	cc.CacheType = proto.CacheTypeRedis
+	cc.HashTag = "{}"
+	cc.ReadTimeout = 100
+	p, rw := newServedTestProxy(t, cc)
+	defer p.Close()
+	conn, err := net.Dial("tcp", p.listeners[cc.Name].Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	other := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+	assert.Equal(t, "*-1\r\n", roundTrip(t, rw, "*3\r\n$5\r\nBLPOP\r\n$3\r\n{a}\r\n$3\r\n0.3\r\n", 1), "blocked longer than read timeout")
+
+	rw.WriteString("*4\r\n$5\r\nBLPOP\r\n$4\r\n{a}1\r\n$4\r\n{a}2\r\n$1\r\n0\r\n")
+	assert.NoError(t, rw.Flush())
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	assert.Equal(t, ":1\r\n", roundTrip(t, other, "*3\r\n$5\r\nLPUSH\r\n$4\r\n{a}2\r\n$1\r\nx\r\n", 1))
+	assert.True(t, time.Since(start) < 100*time.Millisecond, "the shared conns never blocked")
+	assert.Equal(t, "*2\r\n$4\r\n{a}2\r\n$1\r\nx\r\n", roundTrip(t, rw, "", 5), "unblocked by LPUSH")
+
+	f := p.forwarders[cc.Name].(*defaultForwarder)
+	first, _ := f.ring.GetNode([]byte("0-key"))
+	var cross string
+	for i := 1; i < 100 && cross == ""; i++ {
+		key := fmt.Sprintf("%d-key", i)
+		if node, _ := f.ring.GetNode([]byte(key)); node != first {
+			cross = key
+		}
+	}
+	cmd := fmt.Sprintf("*4\r\n$5\r\nBLPOP\r\n$5\r\n0-key\r\n$%d\r\n%s\r\n$1\r\n0\r\n", len(cross), cross)
+	assert.Equal(t, "-CROSSSLOT Keys in request don't hash to the same slot\r\n", roundTrip(t, rw, cmd, 1))
+	cmd = "*3\r\n$5\r\nBLPOP\r\n$1\r\na\r\n$1\r\nx\r\n"
+	assert.Equal(t, "-ERR timeout is not a float or out of range\r\n", roundTrip(t, rw, cmd, 1))
+
+	rw.WriteString("*3\r\n$5\r\nBLPOP\r\n$3\r\n{a}\r\n$1\r\n0\r\n")
+	assert.NoError(t, rw.Flush())
+	time.Sleep(50 * time.Millisecond)
+	p.c.Proxy.ShutdownTimeout = 100 // NOTE: drained until timeout, then force closed
+	p.Close()
+	_, err = rw.ReadString('\n')
+	assert.Error(t, err, "interrupted when proxy closed")
+}
+
+func TestHandlerBlockingWatchClient(t *testing.T) {
+	rs := newFakeRedis(t)
+	defer rs.Close()
+	cc := newServedClusterConfig("block-watch", "", rs.Addr().String()+":1")
+	cc.CacheType = proto.CacheTypeRedis
+	p, rw := newServedTestProxy(t, cc)
+	defer p.Close()
+
+	conn, err := net.Dial("tcp", p.listeners[cc.Name].Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = conn.Write([]byte("*3\r\n$5\r\nBLPOP\r\n$1\r\na\r\n$1\r\n0\r\n"))
+	assert.NoError(t, err)
+	handlers := func() int {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		return len(p.handlers)
+	}
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 2, handlers())
+	conn.Close()
+	for i := 0; i < 100 && handlers() > 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 1, handlers(), "blocked forever is interrupted when the client closed")
+
+	rw.WriteString("*3\r\n$5\r\nBLPOP\r\n$1\r\na\r\n$3\r\n0.3\r\n")
+	assert.NoError(t, rw.Flush())
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, "*-1\r\n:1\r\n", roundTrip(t, rw, "*3\r\n$5\r\nLPUSH\r\n$1\r\nb\r\n$1\r\nx\r\n", 2), "the commands sent while blocked are served after")
+}
@@ -1,26 +1,67 @@
 package proxy
 
 import (
+	errs "errors"
 	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	"overlord/lib/breaker"
+	"overlord/lib/hashkit"
 	"overlord/proto"
 
 	"github.com/BurntSushi/toml"
 	"github.com/pkg/errors"
 )
 
+// config errors
+var (
+	ErrConfigEmpty            = errs.New("must not be empty")
+	ErrConfigNegative         = errs.New("must not be negative")
+	ErrConfigPositive         = errs.New("must be positive")
+	ErrConfigHashTag          = errs.New("must be empty or two characters")
+	ErrConfigListenProto      = errs.New("must be tcp or unix")
+	ErrConfigListenAddr       = errs.New("must be host:port and port in 1~65535")
+	ErrConfigListenConflict   = errs.New("listen addr conflict")
+	ErrConfigDuplicateCluster = errs.New("duplicate cluster name")
+	ErrConfigDuplicateServer  = errs.New("duplicate server")
+	ErrConfigDuplicateAlias   = errs.New("duplicate server alias")
+	ErrConfigMixedAlias       = errs.New("all servers must be with alias or not")
+	ErrConfigBackupCacheType  = errs.New("backup only supported by memcache")
+	ErrConfigBackupSelf       = errs.New("backup cluster must not be itself")
+	ErrConfigBackupCluster    = errs.New("no such memcache backup cluster")
+	ErrConfigL1CacheType      = errs.New("l1 only supported by memcache")
+	ErrConfigL1Backup         = errs.New("l1 must not be used with backup cluster")
+	ErrConfigL1WriteMode      = errs.New("must be empty, write or invalidate")
+	ErrConfigHotKeyCacheType  = errs.New("hotkey only supported by memcache, redis and redis_cluster")
+	ErrConfigBreakerErrorRate = errs.New("must be percent in 0~100")
+)
+
+const (
+	// defaultShutdownTimeout is the msec to wait in-flight requests when shutdown_timeout omitted.
+	defaultShutdownTimeout = 5000
+)
+
 // Config proxy config.
 type Config struct {
 	Pprof string
 	Debug bool
 	Log   string
 	LogVL int `toml:"log_vl"`
+	// AdminToken is required by the admin api changing the clusters, which are disabled when empty.
+	AdminToken string `toml:"admin_token"`
+
 	Proxy struct {
 		ReadTimeout    int   `toml:"read_timeout"`
 		WriteTimeout   int   `toml:"write_timeout"`
 		MaxConnections int32 `toml:"max_connections"`
 		UseMetrics     bool  `toml:"use_metrics"`
+		// ShutdownTimeout is the max msec to wait in-flight requests when proxy closing, 0 means defaultShutdownTimeout.
+		ShutdownTimeout int `toml:"shutdown_timeout"`
 	}
 }
 
@@ -47,35 +88,418 @@ func (c *Config) LoadFromFile(path string) error {
 
 // Validate validate config field value.
 func (c *Config) Validate() error {
-	// TODO(felix): complete validates
-	return nil
+	var es ConfigErrors
+	if c.Pprof != "" {
+		if err := validateTCPAddr(c.Pprof); err != nil {
+			es = append(es, errors.Wrapf(err, "pprof:%s", c.Pprof))
+		}
+	}
+	if c.LogVL < 0 {
+		es = append(es, errors.Wrapf(ErrConfigNegative, "log_vl:%d", c.LogVL))
+	}
+	if c.Proxy.ReadTimeout < 0 {
+		es = append(es, errors.Wrapf(ErrConfigNegative, "proxy read_timeout:%d", c.Proxy.ReadTimeout))
+	}
+	if c.Proxy.WriteTimeout < 0 {
+		es = append(es, errors.Wrapf(ErrConfigNegative, "proxy write_timeout:%d", c.Proxy.WriteTimeout))
+	}
+	if c.Proxy.MaxConnections < 0 {
+		es = append(es, errors.Wrapf(ErrConfigNegative, "proxy max_connections:%d", c.Proxy.MaxConnections))
+	}
+	if c.Proxy.ShutdownTimeout < 0 {
+		es = append(es, errors.Wrapf(ErrConfigNegative, "proxy shutdown_timeout:%d", c.Proxy.ShutdownTimeout))
+	}
+	return es.Err()
 }
 
 // ClusterConfig cluster config.
 type ClusterConfig struct {
 	Name             string
-	HashMethod       string          `toml:"hash_method"`
-	HashDistribution string          `toml:"hash_distribution"`
-	HashTag          string          `toml:"hash_tag"`
-	CacheType        proto.CacheType `toml:"cache_type"`
-	ListenProto      string          `toml:"listen_proto"`
-	ListenAddr       string          `toml:"listen_addr"`
-	RedisAuth        string          `toml:"redis_auth"`
-	DialTimeout      int             `toml:"dial_timeout"`
-	ReadTimeout      int             `toml:"read_timeout"`
-	WriteTimeout     int             `toml:"write_timeout"`
-	NodeConnections  int32           `toml:"node_connections"`
-	PingFailLimit    int             `toml:"ping_fail_limit"`
-	PingAutoEject    bool            `toml:"ping_auto_eject"`
-	Servers          []string        `toml:"servers"`
+	HashMethod       string           `toml:"hash_method"`
+	HashDistribution string           `toml:"hash_distribution"`
+	HashTag          string           `toml:"hash_tag"`
+	CacheType        proto.CacheType  `toml:"cache_type"`
+	ListenProto      string           `toml:"listen_proto"`
+	ListenAddr       string           `toml:"listen_addr"`
+	RedisAuth        string           `toml:"redis_auth"`
+	Password         string           `toml:"password"`
+	MSetDetail       bool             `toml:"mset_detail"`
+	DialTimeout      int              `toml:"dial_timeout"`
+	ReadTimeout      int              `toml:"read_timeout"`
+	WriteTimeout     int              `toml:"write_timeout"`
+	NodeConnections  int32            `toml:"node_connections"`
+	PingFailLimit    int              `toml:"ping_fail_limit"`
+	PingAutoEject    bool             `toml:"ping_auto_eject"`
+	ReadPolicy       proto.ReadPolicy `toml:"read_policy"`
+	BackupCluster    string           `toml:"backup_cluster"`
+	Servers          []string         `toml:"servers"`
+	// TwemproxyPlacement places keys the same as twemproxy, which moves the keys of fnv1a_64 or ketama placed before 1.6.0.
+	TwemproxyPlacement bool `toml:"twemproxy_placement"`
+	// L1Servers is the small and fast memcache pool in front of servers, the L2 pool.
+	L1Servers   []string `toml:"l1_servers"`
+	L1TTL       int      `toml:"l1_ttl"`
+	L1WriteMode string   `toml:"l1_write_mode"`
+	// HotKeyThreshold is the reads per second of key to be hot and cached by proxy, 0 means disabled.
+	HotKeyThreshold int `toml:"hotkey_threshold"`
+	HotKeyTTL       int `toml:"hotkey_ttl"` // msec
+	HotKeyMaxBytes  int `toml:"hotkey_max_bytes"`
+	// QoS limits per second, 0 means unlimited, the bytes are read from and written to clients.
+	OpsLimit         int            `toml:"ops_limit"`
+	BytesLimit       int            `toml:"bytes_limit"`
+	ClientOpsLimit   int            `toml:"client_ops_limit"`
+	ClientBytesLimit int            `toml:"client_bytes_limit"`
+	CmdOpsLimit      map[string]int `toml:"cmd_ops_limit"`
+	// BreakerErrorRate is the percent of failed requests to open the circuit breaker of node, 0 means no breaker.
+	BreakerErrorRate   int `toml:"breaker_error_rate"`
+	BreakerLatency     int `toml:"breaker_latency"` // msec, the slower requests are failed, 0 means not counted
+	BreakerMinRequests int `toml:"breaker_min_requests"`
+	BreakerWindow      int `toml:"breaker_window"`       // msec
+	BreakerOpenTimeout int `toml:"breaker_open_timeout"` // msec
 }
 
-// Validate validate config field value.
+// the defaults of circuit breaker.
+const (
+	defaultBreakerMinRequests = 20
+	defaultBreakerWindow      = 10000
+	defaultBreakerOpenTimeout = 5000
+)
+
+// l1 write modes, the writes are forwarded to L2 and then written or invalidated in L1.
+const (
+	L1WriteModeWrite      = "write"
+	L1WriteModeInvalidate = "invalidate"
+)
+
+// Validate validate config field value, all the problems are returned by ConfigErrors.
 func (cc *ClusterConfig) Validate() error {
-	// TODO(felix): complete validates
+	var es ConfigErrors
+	field := func(err error, name string, value interface{}) {
+		es = append(es, errors.Wrapf(err, "cluster(%s) %s:%v", cc.Name, name, value))
+	}
+	if cc.Name == "" {
+		field(ErrConfigEmpty, "name", cc.Name)
+	}
+	// NOTE: the unknown hash_method like sha1 is the legacy alias of fnv1a_64, which is warned by forwarder.
+	if err := hashkit.CheckDistribution(cc.HashDistribution); err != nil {
+		field(err, "hash_distribution", cc.HashDistribution)
+	}
+	if cc.HashTag != "" && len(cc.HashTag) != 2 {
+		field(ErrConfigHashTag, "hash_tag", cc.HashTag)
+	}
+	switch cc.CacheType {
+	case proto.CacheTypeMemcache, proto.CacheTypeMemcacheBinary, proto.CacheTypeRedis, proto.CacheTypeRedisCluster:
+	default:
+		field(proto.ErrNoSupportCacheType, "cache_type", cc.CacheType)
+	}
+	if err := proto.CheckReadPolicy(cc.ReadPolicy); err != nil {
+		field(err, "read_policy", cc.ReadPolicy)
+	}
+	if cc.BackupCluster != "" {
+		if cc.CacheType != proto.CacheTypeMemcache {
+			field(ErrConfigBackupCacheType, "backup_cluster", cc.BackupCluster)
+		} else if cc.BackupCluster == cc.Name {
+			field(ErrConfigBackupSelf, "backup_cluster", cc.BackupCluster)
+		}
+	}
+	if len(cc.L1Servers) > 0 {
+		cc.validateL1(field)
+	}
+	if cc.HotKeyThreshold > 0 {
+		cc.validateHotKey(field)
+	}
+	if cc.BreakerErrorRate < 0 || cc.BreakerErrorRate > 100 {
+		field(ErrConfigBreakerErrorRate, "breaker_error_rate", cc.BreakerErrorRate)
+	}
+	switch cc.ListenProto {
+	case "tcp":
+		if err := validateTCPAddr(cc.ListenAddr); err != nil {
+			field(err, "listen_addr", cc.ListenAddr)
+		}
+	case "unix":
+		if cc.ListenAddr == "" {
+			field(ErrConfigEmpty, "listen_addr", cc.ListenAddr)
+		}
+	default:
+		field(ErrConfigListenProto, "listen_proto", cc.ListenProto)
+	}
+	for _, nv := range []struct {
+		name  string
+		value int64
+	}{
+		{"dial_timeout", int64(cc.DialTimeout)},
+		{"read_timeout", int64(cc.ReadTimeout)},
+		{"write_timeout", int64(cc.WriteTimeout)},
+		{"node_connections", int64(cc.NodeConnections)},
+		{"ping_fail_limit", int64(cc.PingFailLimit)},
+		{"hotkey_threshold", int64(cc.HotKeyThreshold)},
+		{"ops_limit", int64(cc.OpsLimit)},
+		{"bytes_limit", int64(cc.BytesLimit)},
+		{"client_ops_limit", int64(cc.ClientOpsLimit)},
+		{"client_bytes_limit", int64(cc.ClientBytesLimit)},
+		{"breaker_latency", int64(cc.BreakerLatency)},
+		{"breaker_min_requests", int64(cc.BreakerMinRequests)},
+		{"breaker_window", int64(cc.BreakerWindow)},
+		{"breaker_open_timeout", int64(cc.BreakerOpenTimeout)},
+	} {
+		if nv.value < 0 {
+			field(ErrConfigNegative, nv.name, nv.value)
+		}
+	}
+	cmds := make([]string, 0, len(cc.CmdOpsLimit))
+	for cmd := range cc.CmdOpsLimit {
+		cmds = append(cmds, cmd)
+	}
+	sort.Strings(cmds)
+	for _, cmd := range cmds {
+		if n := cc.CmdOpsLimit[cmd]; n < 0 {
+			field(ErrConfigNegative, "cmd_ops_limit", fmt.Sprintf("%s=%d", cmd, n))
+		}
+	}
+	if len(cc.Servers) == 0 {
+		field(ErrConfigEmpty, "servers", cc.Servers)
+	} else if cc.CacheType == proto.CacheTypeRedisCluster {
+		cc.validateSeeds(field)
+	} else {
+		validateServers(field, "servers", cc.Servers)
+	}
+	return es.Err()
+}
+
+// validateL1 validate the L1 tier, which only supported by memcache.
+func (cc *ClusterConfig) validateL1(field func(error, string, interface{})) {
+	if cc.CacheType != proto.CacheTypeMemcache {
+		field(ErrConfigL1CacheType, "l1_servers", cc.L1Servers)
+	}
+	if cc.BackupCluster != "" {
+		field(ErrConfigL1Backup, "l1_servers", cc.L1Servers)
+	}
+	if cc.L1TTL <= 0 {
+		field(ErrConfigPositive, "l1_ttl", cc.L1TTL)
+	}
+	switch cc.L1WriteMode {
+	case "", L1WriteModeWrite, L1WriteModeInvalidate:
+	default:
+		field(ErrConfigL1WriteMode, "l1_write_mode", cc.L1WriteMode)
+	}
+	validateServers(field, "l1_servers", cc.L1Servers)
+}
+
+// validateHotKey validate the hot key cache, which only supported by the get of memcache and redis.
+func (cc *ClusterConfig) validateHotKey(field func(error, string, interface{})) {
+	switch cc.CacheType {
+	case proto.CacheTypeMemcache, proto.CacheTypeRedis, proto.CacheTypeRedisCluster:
+	default:
+		field(ErrConfigHotKeyCacheType, "hotkey_threshold", cc.HotKeyThreshold)
+	}
+	if cc.HotKeyTTL <= 0 {
+		field(ErrConfigPositive, "hotkey_ttl", cc.HotKeyTTL)
+	}
+	if cc.HotKeyMaxBytes <= 0 {
+		field(ErrConfigPositive, "hotkey_max_bytes", cc.HotKeyMaxBytes)
+	}
+}
+
+// breakerConfig returns the circuit breaker config of nodes, nil means no breaker.
+func (cc *ClusterConfig) breakerConfig() *breaker.Config {
+	if cc.BreakerErrorRate <= 0 {
+		return nil
+	}
+	bc := &breaker.Config{
+		ErrorRate:   cc.BreakerErrorRate,
+		Latency:     time.Duration(cc.BreakerLatency) * time.Millisecond,
+		MinRequests: cc.BreakerMinRequests,
+		Window:      time.Duration(cc.BreakerWindow) * time.Millisecond,
+		OpenTimeout: time.Duration(cc.BreakerOpenTimeout) * time.Millisecond,
+	}
+	if bc.MinRequests == 0 {
+		bc.MinRequests = defaultBreakerMinRequests
+	}
+	if bc.Window == 0 {
+		bc.Window = defaultBreakerWindow * time.Millisecond
+	}
+	if bc.OpenTimeout == 0 {
+		bc.OpenTimeout = defaultBreakerOpenTimeout * time.Millisecond
+	}
+	return bc
+}
+
+// validateSeeds validate the seed servers of redis cluster, which like "host:port" or "host:port:weight".
+func (cc *ClusterConfig) validateSeeds(field func(error, string, interface{})) {
+	seeds := map[string]struct{}{}
+	for _, svr := range cc.Servers {
+		ss := strings.Split(svr, ":")
+		if len(ss) != 2 && len(ss) != 3 {
+			field(ErrConfigServerFormat, "servers", svr)
+			continue
+		}
+		addr := net.JoinHostPort(ss[0], ss[1])
+		if err := validateTCPAddr(addr); err != nil || ss[0] == "" {
+			field(ErrConfigServerFormat, "servers", svr)
+			continue
+		}
+		if _, ok := seeds[addr]; ok {
+			field(ErrConfigDuplicateServer, "servers", svr)
+		}
+		seeds[addr] = struct{}{}
+	}
+}
+
+// validateServers validate the servers which like "host:port:weight" or "host:port:weight alias",
+// and the replicas like "host:port:weight,host:port alias".
+func validateServers(field func(error, string, interface{}), name string, servers []string) {
+	var (
+		addrs   = map[string]struct{}{}
+		aliases = map[string]struct{}{}
+		valid   int
+		withAn  int
+	)
+	for _, svr := range servers {
+		addr, replicas, _, alias, err := parseServer(svr)
+		if err != nil {
+			field(err, name, svr)
+			continue
+		}
+		valid++
+		for _, addr := range append([]string{addr}, replicas...) {
+			if _, ok := addrs[addr]; ok {
+				field(ErrConfigDuplicateServer, name, svr)
+			}
+			addrs[addr] = struct{}{}
+		}
+		if alias == "" {
+			continue
+		}
+		withAn++
+		if _, ok := aliases[alias]; ok {
+			field(ErrConfigDuplicateAlias, name, svr)
+		}
+		aliases[alias] = struct{}{}
+	}
+	if withAn > 0 && withAn != valid {
+		field(ErrConfigMixedAlias, name, servers)
+	}
+}
+
+// sameServers check the servers of cluster config whether equal.
+func (cc *ClusterConfig) sameServers(ncc *ClusterConfig) bool {
+	return reflect.DeepEqual(cc.Servers, ncc.Servers)
+}
+
+// sameWithoutServers check the fields of cluster config except servers whether equal,
+// when true the cluster can be reloaded in place.
+func (cc *ClusterConfig) sameWithoutServers(ncc *ClusterConfig) bool {
+	occ, nncc := *cc, *ncc
+	occ.Servers, nncc.Servers = nil, nil
+	return reflect.DeepEqual(occ, nncc)
+}
+
+// ValidateClusters validate every cluster config and the conflicts between clusters,
+// like the same name or the same listen addr.
+func ValidateClusters(ccs []*ClusterConfig) error {
+	var es ConfigErrors
+	for i, cc := range ccs {
+		if err := cc.Validate(); err != nil {
+			es = append(es, err.(ConfigErrors)...)
+		}
+		if err := ValidateConflicts(ccs[i:i+1], ccs[:i]); err != nil {
+			es = append(es, err.(ConfigErrors)...)
+		}
+	}
+	return es.Err()
+}
+
+// ValidateConflicts validate the conflicts of clusters with the others, like the same name or the same listen addr.
+func ValidateConflicts(ccs, others []*ClusterConfig) error {
+	var es ConfigErrors
+	for _, cc := range ccs {
+		for _, occ := range others {
+			if cc.Name == occ.Name {
+				es = append(es, errors.Wrapf(ErrConfigDuplicateCluster, "cluster(%s) name:%s", cc.Name, cc.Name))
+			}
+			if listenConflict(occ, cc) {
+				es = append(es, errors.Wrapf(ErrConfigListenConflict, "cluster(%s) listen_addr:%s with cluster(%s)", cc.Name, cc.ListenAddr, occ.Name))
+			}
+		}
+	}
+	return es.Err()
+}
+
+// ValidateBackups validate the backup clusters exist and are memcache, the clusters may be loaded from different files.
+func ValidateBackups(ccs []*ClusterConfig) error {
+	var es ConfigErrors
+	types := make(map[string]proto.CacheType)
+	for _, cc := range ccs {
+		types[cc.Name] = cc.CacheType
+	}
+	for _, cc := range ccs {
+		if cc.BackupCluster == "" || cc.BackupCluster == cc.Name {
+			continue
+		}
+		if tp, ok := types[cc.BackupCluster]; !ok || tp != proto.CacheTypeMemcache {
+			es = append(es, errors.Wrapf(ErrConfigBackupCluster, "cluster(%s) backup_cluster:%s", cc.Name, cc.BackupCluster))
+		}
+	}
+	return es.Err()
+}
+
+// listenConflict check the listen addrs whether conflict, the unspecified host conflicts with any host in the same port.
+func listenConflict(a, b *ClusterConfig) bool {
+	if a.ListenProto != b.ListenProto {
+		return false
+	}
+	if a.ListenProto != "tcp" {
+		return a.ListenAddr == b.ListenAddr
+	}
+	ah, ap, aerr := net.SplitHostPort(a.ListenAddr)
+	bh, bp, berr := net.SplitHostPort(b.ListenAddr)
+	if aerr != nil || berr != nil {
+		return a.ListenAddr == b.ListenAddr
+	}
+	if ap != bp {
+		return false
+	}
+	return ah == bh || unspecifiedHost(ah) || unspecifiedHost(bh)
+}
+
+func unspecifiedHost(host string) bool {
+	if host == "" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsUnspecified()
+}
+
+// validateTCPAddr validate the addr like "host:port" and the port must be in 1~65535.
+func validateTCPAddr(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return ErrConfigListenAddr
+	}
+	if p, err := strconv.Atoi(port); err != nil || p <= 0 || p > 65535 {
+		return ErrConfigListenAddr
+	}
 	return nil
 }
 
+// ConfigErrors is the collection of config problems.
+type ConfigErrors []error
+
+func (es ConfigErrors) Error() string {
+	ss := make([]string, len(es))
+	for i, e := range es {
+		ss[i] = e.Error()
+	}
+	return strings.Join(ss, "\n")
+}
+
+// Err returns nil when no problems.
+func (es ConfigErrors) Err() error {
+	if len(es) == 0 {
+		return nil
+	}
+	return es
+}
+
 // ClusterConfigs cluster configs.
 type ClusterConfigs struct {
 	Clusters []*ClusterConfig
@@ -87,9 +511,12 @@ func (ccs *ClusterConfigs) LoadFromFile(path string) error {
 	if err != nil {
 		return errors.Wrapf(err, "Load From File:%s", path)
 	}
+	if err = ValidateClusters(ccs.Clusters); err != nil {
+		return err
+	}
 	for _, cc := range ccs.Clusters {
-		if err = cc.Validate(); err != nil {
-			return err
+		if cc.NodeConnections == 0 {
+			cc.NodeConnections = 1 // NOTE: default open 1 connection to each server
 		}
 		if cc.CacheType == proto.CacheTypeRedisCluster {
 			servers := make([]string, len(cc.Servers))
@@ -117,6 +544,9 @@ const defaultConfig = `
 #                                                #
 ##################################################
 pprof = "0.0.0.0:2110"
+# The token required by the admin api changing the clusters like eject/readd in header "Authorization: Bearer <token>".
+# By default empty, those api are disabled. The pprof addr is plain http, never expose it to an untrusted network.
+admin_token = ""
 debug = false
 log = ""
 log_lv = 0
@@ -130,4 +560,7 @@ write_timeout = 0
 max_connections = 0
 # proxy support prometheus metrics, reuse the pprof port. By default, we use it.
 use_metrics = true
+# The max time value in msec that we wait for in-flight requests to finish when proxy closing by SIGTERM/SIGQUIT/SIGINT, then the client connections are closed.
+# By default or 0, we wait 5000.
+shutdown_timeout = 5000
 `
@@ -1,18 +1,23 @@
 package proxy
 
 import (
-	"github.com/stretchr/testify/assert"
 	"os"
 	"testing"
+
+	"overlord/lib/hashkit"
+	"overlord/proto"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
 )
 
 const exampleCluster = `
 [[clusters]]
 # This be used to specify the name of cache cluster.
 name = "test-mc"
-# The name of the hash function. Possible values are: sha1.
+# The name of the hash function. Possible values are: one_at_a_time, md5, crc16, crc32, crc32a, fnv1_64, fnv1a_64, fnv1_32, fnv1a_32, hsieh, murmur, jenkins. Defaults to fnv1a_64, the others like sha1 are the deprecated alias of fnv1a_64.
 hash_method = "fnv1a_64"
-# The key distribution mode. Possible values are: ketama.
+# The key distribution mode. Possible values are: ketama, modula, random. Defaults to ketama.
 hash_distribution = "ketama"
 # A two character string that specifies the part of the key used for hashing. Eg "{}".
 hash_tag = ""
@@ -44,9 +49,9 @@ servers = [
 [[clusters]]
 # This be used to specify the name of cache cluster.
 name = "test-redis"
-# The name of the hash function. Possible values are: sha1.
+# The name of the hash function. Possible values are: one_at_a_time, md5, crc16, crc32, crc32a, fnv1_64, fnv1a_64, fnv1_32, fnv1a_32, hsieh, murmur, jenkins. Defaults to fnv1a_64, the others like sha1 are the deprecated alias of fnv1a_64.
 hash_method = "fnv1a_64"
-# The key distribution mode. Possible values are: ketama.
+# The key distribution mode. Possible values are: ketama, modula, random. Defaults to ketama.
 hash_distribution = "ketama"
 # A two character string that specifies the part of the key used for hashing. Eg "{}".
 hash_tag = ""
@@ -78,9 +83,9 @@ servers = [
 [[clusters]]
 # This be used to specify the name of cache cluster.
 name = "test-redis-cluster"
-# The name of the hash function. Possible values are: sha1.
+# The name of the hash function. Possible values are: one_at_a_time, md5, crc16, crc32, crc32a, fnv1_64, fnv1a_64, fnv1_32, fnv1a_32, hsieh, murmur, jenkins. Defaults to fnv1a_64, the others like sha1 are the deprecated alias of fnv1a_64.
 hash_method = "fnv1a_64"
-# The key distribution mode. Possible values are: ketama.
+# The key distribution mode. Possible values are: ketama, modula, random. Defaults to ketama.
 hash_distribution = "ketama"
 # A two character string that specifies the part of the key used for hashing. Eg "{}".
 hash_tag = "{}"
@@ -129,3 +134,183 @@ func TestClusterConfigLoadFromFile(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Len(t, ccs.Clusters, 3)
 }
+
+func TestClusterConfigSameWithoutServers(t *testing.T) {
+	cc := &ClusterConfig{Name: "a", CacheType: "memcache", Servers: []string{"127.0.0.1:11211:1"}}
+	ncc := &ClusterConfig{Name: "a", CacheType: "memcache", Servers: []string{"127.0.0.1:11212:1"}}
+	assert.True(t, cc.sameWithoutServers(ncc))
+	assert.False(t, cc.sameServers(ncc))
+	ncc.ListenAddr = "0.0.0.0:21211"
+	assert.False(t, cc.sameWithoutServers(ncc))
+}
+
+func newValidClusterConfig() *ClusterConfig {
+	return &ClusterConfig{
+		Name:             "a",
+		HashMethod:       "fnv1a_64",
+		HashDistribution: "ketama",
+		CacheType:        proto.CacheTypeMemcache,
+		ListenProto:      "tcp",
+		ListenAddr:       "0.0.0.0:21211",
+		NodeConnections:  1,
+		Servers:          []string{"127.0.0.1:11211:1 mc1", "127.0.0.1:11212:1 mc2"},
+	}
+}
+
+func TestClusterConfigValidateHash(t *testing.T) {
+	cc := newValidClusterConfig()
+	cc.HashMethod, cc.HashDistribution = "murmur", "modula"
+	assert.NoError(t, cc.Validate())
+	cc.HashMethod = "sha1"
+	assert.NoError(t, cc.Validate(), "legacy alias of fnv1a_64")
+	cc.HashMethod = ""
+	cc.HashDistribution = "redis_cluster"
+	err := cc.Validate()
+	if assert.Len(t, err, 1) {
+		assert.Equal(t, hashkit.ErrUnknownDistribution, errors.Cause(err.(ConfigErrors)[0]))
+		assert.Contains(t, err.Error(), "cluster(a)")
+	}
+}
+
+func TestClusterConfigValidate(t *testing.T) {
+	ts := []struct {
+		name   string
+		modify func(cc *ClusterConfig)
+		errs   []error
+	}{
+		{name: "Ok", modify: func(cc *ClusterConfig) {}},
+		{name: "UnixOk", modify: func(cc *ClusterConfig) { cc.ListenProto, cc.ListenAddr = "unix", "/tmp/overlord.sock" }},
+		{name: "NoAliasOk", modify: func(cc *ClusterConfig) { cc.Servers = []string{"127.0.0.1:11211:1", "127.0.0.1:11212:1"} }},
+		{name: "RedisClusterSeedsOk", modify: func(cc *ClusterConfig) {
+			cc.CacheType = proto.CacheTypeRedisCluster
+			cc.Servers = []string{"127.0.0.1:7000", "127.0.0.1:7001:1"}
+		}},
+		{name: "ReadPolicyOk", modify: func(cc *ClusterConfig) {
+			cc.CacheType, cc.ReadPolicy = proto.CacheTypeRedisCluster, proto.ReadPolicyRoundRobin
+			cc.Servers = []string{"127.0.0.1:7000"}
+		}},
+		{name: "ReplicaOk", modify: func(cc *ClusterConfig) {
+			cc.ReadPolicy = proto.ReadPolicyPreferReplica
+			cc.Servers = []string{"127.0.0.1:11211:1,127.0.0.1:11221,127.0.0.1:11231 mc1", "127.0.0.1:11212:1 mc2"}
+		}},
+		{name: "L1Ok", modify: func(cc *ClusterConfig) {
+			cc.L1Servers, cc.L1TTL, cc.L1WriteMode = []string{"127.0.0.1:11311:1"}, 60, L1WriteModeInvalidate
+		}},
+		{name: "HotKeyOk", modify: func(cc *ClusterConfig) {
+			cc.CacheType, cc.HotKeyThreshold, cc.HotKeyTTL, cc.HotKeyMaxBytes = proto.CacheTypeRedis, 1000, 100, 1<<20
+		}},
+		{name: "EmptyName", modify: func(cc *ClusterConfig) { cc.Name = "" }, errs: []error{ErrConfigEmpty}},
+		{name: "CacheType", modify: func(cc *ClusterConfig) { cc.CacheType = "mysql" }, errs: []error{proto.ErrNoSupportCacheType}},
+		{name: "HashTag", modify: func(cc *ClusterConfig) { cc.HashTag = "{" }, errs: []error{ErrConfigHashTag}},
+		{name: "ListenProto", modify: func(cc *ClusterConfig) { cc.ListenProto = "udp" }, errs: []error{ErrConfigListenProto}},
+		{name: "ListenAddrNoPort", modify: func(cc *ClusterConfig) { cc.ListenAddr = "0.0.0.0" }, errs: []error{ErrConfigListenAddr}},
+		{name: "ListenAddrBadPort", modify: func(cc *ClusterConfig) { cc.ListenAddr = "0.0.0.0:65536" }, errs: []error{ErrConfigListenAddr}},
+		{name: "UnixEmpty", modify: func(cc *ClusterConfig) { cc.ListenProto, cc.ListenAddr = "unix", "" }, errs: []error{ErrConfigEmpty}},
+		{name: "Negative", modify: func(cc *ClusterConfig) {
+			cc.DialTimeout, cc.ReadTimeout, cc.WriteTimeout, cc.NodeConnections, cc.PingFailLimit = -1, -1, -1, -1, -1
+		}, errs: []error{ErrConfigNegative, ErrConfigNegative, ErrConfigNegative, ErrConfigNegative, ErrConfigNegative}},
+		{name: "EmptyServers", modify: func(cc *ClusterConfig) { cc.Servers = nil }, errs: []error{ErrConfigEmpty}},
+		{name: "ServerFormat", modify: func(cc *ClusterConfig) {
+			cc.Servers = []string{"127.0.0.1:11211 mc1", "127.0.0.1:11212:0 mc2", "127.0.0.1:port:1 mc3", "127.0.0.1:11214:1 mc4 x"}
+		}, errs: []error{ErrConfigServerFormat, ErrConfigServerFormat, ErrConfigServerFormat, ErrConfigServerFormat}},
+		{name: "DuplicateServer", modify: func(cc *ClusterConfig) { cc.Servers = []string{"127.0.0.1:11211:1 mc1", "127.0.0.1:11211:2 mc2"} }, errs: []error{ErrConfigDuplicateServer}},
+		{name: "DuplicateAlias", modify: func(cc *ClusterConfig) { cc.Servers = []string{"127.0.0.1:11211:1 mc1", "127.0.0.1:11212:1 mc1"} }, errs: []error{ErrConfigDuplicateAlias}},
+		{name: "MixedAlias", modify: func(cc *ClusterConfig) { cc.Servers = []string{"127.0.0.1:11211:1", "127.0.0.1:11212:1 mc2"} }, errs: []error{ErrConfigMixedAlias}},
+		{name: "RedisClusterSeeds", modify: func(cc *ClusterConfig) {
+			cc.CacheType = proto.CacheTypeRedisCluster
+			cc.Servers = []string{"127.0.0.1", "127.0.0.1:7000", "127.0.0.1:7000:1"}
+		}, errs: []error{ErrConfigServerFormat, ErrConfigDuplicateServer}},
+		{name: "ReadPolicy", modify: func(cc *ClusterConfig) { cc.ReadPolicy = "slave" }, errs: []error{proto.ErrNoSupportReadPolicy}},
+		{name: "ReplicaFormat", modify: func(cc *ClusterConfig) {
+			cc.Servers = []string{"127.0.0.1:11211:1,127.0.0.1:11212:1 mc1", "127.0.0.1:11213:1,127.0.0.1 mc2", "127.0.0.1:11214:1, mc3"}
+		}, errs: []error{ErrConfigServerFormat, ErrConfigServerFormat, ErrConfigServerFormat}},
+		{name: "DuplicateReplica", modify: func(cc *ClusterConfig) {
+			cc.Servers = []string{"127.0.0.1:11211:1,127.0.0.1:11212 mc1", "127.0.0.1:11212:1 mc2"}
+		}, errs: []error{ErrConfigDuplicateServer}},
+		{name: "BackupCacheType", modify: func(cc *ClusterConfig) {
+			cc.CacheType, cc.BackupCluster = proto.CacheTypeRedis, "b"
+		}, errs: []error{ErrConfigBackupCacheType}},
+		{name: "BackupSelf", modify: func(cc *ClusterConfig) { cc.BackupCluster = cc.Name }, errs: []error{ErrConfigBackupSelf}},
+		{name: "L1", modify: func(cc *ClusterConfig) {
+			cc.CacheType, cc.BackupCluster, cc.L1WriteMode = proto.CacheTypeRedis, "b", "both"
+			cc.L1Servers = []string{"127.0.0.1:11311:1", "127.0.0.1:11311:1"}
+		}, errs: []error{ErrConfigBackupCacheType, ErrConfigL1CacheType, ErrConfigL1Backup, ErrConfigPositive, ErrConfigL1WriteMode, ErrConfigDuplicateServer}},
+		{name: "HotKey", modify: func(cc *ClusterConfig) {
+			cc.CacheType, cc.HotKeyThreshold = proto.CacheTypeMemcacheBinary, 1000
+		}, errs: []error{ErrConfigHotKeyCacheType, ErrConfigPositive, ErrConfigPositive}},
+		{name: "HotKeyNegative", modify: func(cc *ClusterConfig) { cc.HotKeyThreshold = -1 }, errs: []error{ErrConfigNegative}},
+		{name: "LimitNegative", modify: func(cc *ClusterConfig) {
+			cc.OpsLimit, cc.ClientBytesLimit = -1, -1
+			cc.CmdOpsLimit = map[string]int{"set": -1, "get": 100}
+		}, errs: []error{ErrConfigNegative, ErrConfigNegative, ErrConfigNegative}},
+		{name: "Breaker", modify: func(cc *ClusterConfig) {
+			cc.BreakerErrorRate, cc.BreakerLatency = 101, -1
+		}, errs: []error{ErrConfigBreakerErrorRate, ErrConfigNegative}},
+		{name: "Multi", modify: func(cc *ClusterConfig) { cc.HashTag, cc.ListenProto = "{}}", "udp" }, errs: []error{ErrConfigHashTag, ErrConfigListenProto}},
+	}
+	for _, tt := range ts {
+		t.Run(tt.name, func(t *testing.T) {
+			cc := newValidClusterConfig()
+			tt.modify(cc)
+			err := cc.Validate()
+			if len(tt.errs) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			es, ok := err.(ConfigErrors)
+			if !assert.True(t, ok, "%v", err) || !assert.Len(t, es, len(tt.errs), "%v", err) {
+				return
+			}
+			for i, e := range es {
+				assert.Equal(t, tt.errs[i], errors.Cause(e))
+				assert.Contains(t, e.Error(), "cluster("+cc.Name+")")
+			}
+		})
+	}
+}
+
+func TestValidateClusters(t *testing.T) {
+	a := newValidClusterConfig()
+	b := newValidClusterConfig()
+	b.Name, b.ListenAddr = "b", "127.0.0.1:21212"
+	assert.NoError(t, ValidateClusters([]*ClusterConfig{a, b}))
+
+	b.ListenAddr = "127.0.0.1:21211"
+	err := ValidateClusters([]*ClusterConfig{a, b})
+	if assert.Len(t, err, 1) {
+		assert.Equal(t, ErrConfigListenConflict, errors.Cause(err.(ConfigErrors)[0]))
+		assert.Contains(t, err.Error(), "cluster(b) listen_addr:127.0.0.1:21211 with cluster(a)")
+	}
+
+	b.Name, b.ListenAddr = "a", "127.0.0.1:21212"
+	b.HashTag = "{"
+	err = ValidateClusters([]*ClusterConfig{a, b})
+	if assert.Len(t, err, 2) {
+		assert.Equal(t, ErrConfigHashTag, errors.Cause(err.(ConfigErrors)[0]))
+		assert.Equal(t, ErrConfigDuplicateCluster, errors.Cause(err.(ConfigErrors)[1]))
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	c := DefaultConfig()
+	assert.NoError(t, c.Validate())
+	c.Pprof = "2110"
+	c.Proxy.MaxConnections = -1
+	err := c.Validate()
+	assert.Len(t, err, 2)
+}
+
+func TestValidateBackups(t *testing.T) {
+	a := newValidClusterConfig()
+	a.BackupCluster = "b"
+	err := ValidateBackups([]*ClusterConfig{a})
+	if assert.Len(t, err, 1) {
+		assert.Equal(t, ErrConfigBackupCluster, errors.Cause(err.(ConfigErrors)[0]))
+		assert.Contains(t, err.Error(), "cluster(a)")
+	}
+	b := newValidClusterConfig()
+	b.Name, b.ListenAddr = "b", "127.0.0.1:21212"
+	assert.NoError(t, ValidateBackups([]*ClusterConfig{a, b}))
+	b.CacheType = proto.CacheTypeRedis
+	assert.Error(t, ValidateBackups([]*ClusterConfig{a, b}))
+}
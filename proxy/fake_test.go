@@ -0,0 +1,241 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"overlord/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeMemcache is the memcache server only supports set, get, delete, flush_all and stats.
+type fakeMemcache struct {
+	net.Listener
+
+	lock sync.Mutex
+	kvs  map[string]string
+}
+
+func newFakeMemcache(t *testing.T) *fakeMemcache {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mc := &fakeMemcache{Listener: l, kvs: map[string]string{}}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go mc.serve(conn)
+		}
+	}()
+	return mc
+}
+
+func (mc *fakeMemcache) serve(conn net.Conn) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		var reply string
+		switch fields[0] {
+		case "set":
+			var n int
+			fmt.Sscanf(fields[4], "%d", &n)
+			data := make([]byte, n+2)
+			if _, err = io.ReadFull(br, data); err != nil {
+				return
+			}
+			mc.set(fields[1], string(data[:n]))
+			reply = "STORED\r\n"
+		case "get":
+			if v, ok := mc.get(fields[1]); ok {
+				reply = fmt.Sprintf("VALUE %s 0 %d\r\n%s\r\n", fields[1], len(v), v)
+			}
+			reply += "END\r\n"
+		case "delete":
+			mc.lock.Lock()
+			delete(mc.kvs, fields[1])
+			mc.lock.Unlock()
+			reply = "DELETED\r\n"
+		case "flush_all":
+			mc.lock.Lock()
+			mc.kvs = map[string]string{}
+			mc.lock.Unlock()
+			reply = "OK\r\n"
+		case "stats":
+			mc.lock.Lock()
+			reply = fmt.Sprintf("STAT curr_items %d\r\nEND\r\n", len(mc.kvs))
+			mc.lock.Unlock()
+		default:
+			reply = "ERROR\r\n"
+		}
+		if _, err = conn.Write([]byte(reply)); err != nil {
+			return
+		}
+	}
+}
+
+func (mc *fakeMemcache) set(k, v string) {
+	mc.lock.Lock()
+	mc.kvs[k] = v
+	mc.lock.Unlock()
+}
+
+func (mc *fakeMemcache) get(k string) (v string, ok bool) {
+	mc.lock.Lock()
+	v, ok = mc.kvs[k]
+	mc.lock.Unlock()
+	return
+}
+
+// newServedClusterConfig returns the memcache cluster config served by newServedTestProxy.
+func newServedClusterConfig(name, backup string, servers ...string) *ClusterConfig {
+	return &ClusterConfig{
+		Name:             name,
+		HashMethod:       "fnv1a_64",
+		HashDistribution: "ketama",
+		CacheType:        proto.CacheTypeMemcache,
+		ListenProto:      "tcp",
+		ListenAddr:       "127.0.0.1:0",
+		DialTimeout:      100,
+		ReadTimeout:      1000,
+		WriteTimeout:     1000,
+		NodeConnections:  1,
+		BackupCluster:    backup,
+		Servers:          servers,
+	}
+}
+
+// newServedTestProxy serve the clusters and returns the client conn to the first, the others are like the backup cluster.
+func newServedTestProxy(t *testing.T, cc *ClusterConfig, others ...*ClusterConfig) (*Proxy, *bufio.ReadWriter) {
+	p, err := New(DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Serve(append([]*ClusterConfig{cc}, others...))
+	conn, err := net.Dial("tcp", p.listeners[cc.Name].Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+}
+
+func roundTrip(t *testing.T, rw *bufio.ReadWriter, cmd string, lines int) string {
+	rw.WriteString(cmd)
+	assert.NoError(t, rw.Flush())
+	var reply string
+	for i := 0; i < lines; i++ {
+		line, err := rw.ReadString('\n')
+		assert.NoError(t, err)
+		reply += line
+	}
+	return reply
+}
+
+// fakeRedis is the redis server only supports SCAN, EVAL, EVALSHA, SCRIPT LOAD|EXISTS|FLUSH, pub/sub,
+// GET, SET in transactions and LPUSH, BLPOP of one element.
+// SCAN replies one key every time and the cursor is the index of next key, the scripts reply the script itself.
+type fakeRedis struct {
+	net.Listener
+
+	keys []string
+	args chan []string
+
+	lock    sync.Mutex
+	scripts map[string]string
+	subs    map[net.Conn]map[string]bool // NOTE: the channels and the patterns marked true of conn
+	txs     map[net.Conn]*fakeTx
+	data    map[string]string
+	version map[string]int
+	pushed  chan []string // NOTE: the key and the element of LPUSH
+}
+
+func newFakeRedis(t *testing.T, keys ...string) *fakeRedis {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := &fakeRedis{Listener: l, keys: keys, args: make(chan []string, 16), scripts: map[string]string{}, subs: map[net.Conn]map[string]bool{},
+		txs: map[net.Conn]*fakeTx{}, data: map[string]string{}, version: map[string]int{}, pushed: make(chan []string, 16)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go rs.serve(conn)
+		}
+	}()
+	return rs
+}
+
+func (rs *fakeRedis) serve(conn net.Conn) {
+	defer func() {
+		rs.lock.Lock()
+		delete(rs.subs, conn)
+		delete(rs.txs, conn)
+		rs.lock.Unlock()
+		conn.Close()
+	}()
+	br := bufio.NewReader(conn)
+	for {
+		var n int
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fmt.Sscanf(line, "*%d", &n)
+		args := make([]string, n)
+		for i := range args {
+			if _, err = br.ReadString('\n'); err != nil { // NOTE: $len
+				return
+			}
+			if line, err = br.ReadString('\n'); err != nil {
+				return
+			}
+			args[i] = strings.TrimSuffix(line, "\r\n")
+		}
+		var reply string
+		if tx, ok := rs.tx(conn, args); ok {
+			reply = tx
+		} else if cmd := strings.ToUpper(args[0]); cmd == "BLPOP" || cmd == "LPUSH" {
+			reply = rs.list(cmd, args)
+		} else if len(args) < 2 {
+			reply = "-ERR unknown command\r\n"
+		} else if cmd := strings.ToUpper(args[0]); strings.HasSuffix(cmd, "SUBSCRIBE") || cmd == "PUBLISH" {
+			reply = rs.pubsub(conn, cmd, args)
+		} else if cmd := strings.ToUpper(args[0]); cmd != "SCAN" {
+			reply = rs.script(cmd, args)
+		} else {
+			reply = rs.scan(args)
+		}
+		if _, err = conn.Write([]byte(reply)); err != nil {
+			return
+		}
+	}
+}
+
+func (rs *fakeRedis) scan(args []string) string {
+	rs.args <- args
+	var cursor int
+	fmt.Sscanf(args[1], "%d", &cursor)
+	next := "0"
+	if cursor+1 < len(rs.keys) {
+		next = fmt.Sprint(cursor + 1)
+	}
+	key := rs.keys[cursor]
+	return fmt.Sprintf("*2\r\n$%d\r\n%s\r\n*1\r\n$%d\r\n%s\r\n", len(next), next, len(key), key)
+}
@@ -5,6 +5,7 @@ import (
 	errs "errors"
 	"net"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -13,6 +14,7 @@ import (
 	"overlord/lib/hashkit"
 	"overlord/lib/log"
 	libnet "overlord/lib/net"
+	"overlord/lib/prom"
 	"overlord/proto"
 	"overlord/proto/memcache"
 	mcbin "overlord/proto/memcache/binary"
@@ -32,6 +34,7 @@ var (
 	ErrConfigServerFormat  = errs.New("servers config format error")
 	ErrForwarderHashNoNode = errs.New("forwarder hash no hit node")
 	ErrForwarderClosed     = errs.New("forwarder already closed")
+	ErrForwarderNoSuchNode = errs.New("forwarder no such node")
 )
 
 var (
@@ -46,13 +49,19 @@ var (
 func NewForwarder(cc *ClusterConfig) proto.Forwarder {
 	// new Forwarder
 	if _, ok := defaultForwardCacheTypes[cc.CacheType]; ok {
+		if cc.BackupCluster != "" {
+			return newBackupForwarder(cc)
+		}
+		if len(cc.L1Servers) > 0 {
+			return newTieredForwarder(cc)
+		}
 		return newDefaultForwarder(cc)
 	}
 	if cc.CacheType == proto.CacheTypeRedisCluster {
 		dto := time.Duration(cc.DialTimeout) * time.Millisecond
 		rto := time.Duration(cc.ReadTimeout) * time.Millisecond
 		wto := time.Duration(cc.WriteTimeout) * time.Millisecond
-		return rclstr.NewForwarder(cc.Name, cc.ListenAddr, cc.Servers, cc.NodeConnections, dto, rto, wto, []byte(cc.HashTag))
+		return rclstr.NewForwarder(cc.Name, cc.ListenAddr, cc.Servers, cc.NodeConnections, cc.RedisAuth, dto, rto, wto, []byte(cc.HashTag), cc.ReadPolicy, cc.breakerConfig())
 	}
 	panic("unsupported protocol")
 }
@@ -64,10 +73,21 @@ type defaultForwarder struct {
 	ring    *hashkit.HashRing
 	hashTag []byte
 
-	// recording alias to real node
-	alias    bool
-	aliasMap map[string]string
-	nodePipe map[string]*proto.NodeConnPipe
+	// recording ring node name to real node addr of primary
+	nodeAddr map[string]string
+	// recording ring node name to the group addrs, primary first then replicas
+	nodeGroup map[string][]string
+	nodePipe  map[string]*proto.NodeConnPipe
+	// ring node names and weights by config order, the ejected are skipped
+	names   []string
+	weights []int
+	down    map[string]struct{} // addrs failed to ping
+	ejected map[string]struct{} // all addrs of group are down
+	manual  map[string]struct{} // ejected by admin, never re-added by pinger
+	routes  map[string]*nodeRoute
+	rr      uint32 // NOTE: round robin counter of the read routes
+	pingers []*pinger
+	lock    sync.RWMutex
 
 	state int32
 }
@@ -75,63 +95,59 @@ type defaultForwarder struct {
 // newDefaultForwarder must combinf.
 func newDefaultForwarder(cc *ClusterConfig) proto.Forwarder {
 	f := &defaultForwarder{cc: cc}
-	// parse servers config
-	addrs, ws, ans, alias, err := parseServers(cc.Servers)
-	if err != nil {
-		panic(err)
-	}
-	f.alias = alias
 	f.hashTag = []byte(cc.HashTag)
-	f.ring = hashkit.NewRing(cc.HashDistribution, cc.HashMethod)
-	f.aliasMap = make(map[string]string)
-	if alias {
-		for idx, aname := range ans {
-			f.aliasMap[aname] = addrs[idx]
-		}
-		f.ring.Init(ans, ws)
+	if err := hashkit.CheckHashMethod(cc.HashMethod); err != nil {
+		log.Warnf("cluster(%s) hash_method:%s is deprecated and falls back to fnv1a_64", cc.Name, cc.HashMethod)
+	}
+	if cc.TwemproxyPlacement {
+		f.ring = hashkit.NewTwemproxyRing(cc.HashDistribution, cc.HashMethod)
 	} else {
-		f.ring.Init(addrs, ws)
+		f.ring = hashkit.NewRing(cc.HashDistribution, cc.HashMethod)
 	}
-	// start nbc
 	f.nodePipe = make(map[string]*proto.NodeConnPipe)
-	for _, addr := range addrs {
-		toAddr := addr // NOTE: avoid closure
-		f.nodePipe[toAddr] = proto.NewNodeConnPipe(cc.NodeConnections, func() proto.NodeConn {
-			return newNodeConn(cc, toAddr)
-		})
-	}
-	if cc.PingAutoEject {
-		for idx, addr := range addrs {
-			w := ws[idx]
-			pc := newPingConn(cc, addr)
-			p := &pinger{ping: pc, cc: cc, node: addr, weight: w}
-			if f.alias {
-				p.alias = ans[idx]
-			}
-			go f.processPing(p)
-		}
+	if err := f.Update(cc.Servers); err != nil {
+		panic(err)
 	}
 	return f
 }
 
 // Forward impl proto.Forwarder
-func (f defaultForwarder) Forward(msgs []*proto.Message) error {
+func (f *defaultForwarder) Forward(msgs []*proto.Message) error {
 	if closed := atomic.LoadInt32(&f.state); closed == forwarderStateClosed {
+		for _, m := range msgs {
+			m.WithError(ErrForwarderClosed)
+		}
 		return ErrForwarderClosed
 	}
 	for _, m := range msgs {
+		if sc, ok := m.Request().(proto.Scanner); ok && sc.IsScan() {
+			f.scan(m, sc)
+			continue
+		}
+		if bc, ok := m.Request().(proto.Broadcaster); ok && bc.IsBroadcast() {
+			f.broadcast(m, bc)
+			continue
+		}
+		if mk, ok := m.Request().(proto.MultiKeyer); ok && !m.IsBatch() {
+			if keys := mk.Keys(); len(keys) > 1 && !f.sameNode(keys) {
+				mk.WithCrossSlot()
+				continue
+			}
+		}
 		if m.IsBatch() {
 			for _, subm := range m.Batch() {
-				ncp, ok := f.getPipes(subm.Request().Key())
+				ncp, ok := f.getPipes(subm.Request().Key(), isReadOnly(subm.Request()))
 				if !ok {
+					f.hashMiss()
 					m.WithError(ErrForwarderHashNoNode)
 					return errors.WithStack(ErrForwarderHashNoNode)
 				}
 				ncp.Push(subm)
 			}
 		} else {
-			ncp, ok := f.getPipes(m.Request().Key())
+			ncp, ok := f.getPipes(m.Request().Key(), isReadOnly(m.Request()))
 			if !ok {
+				f.hashMiss()
 				m.WithError(ErrForwarderHashNoNode)
 				return errors.WithStack(ErrForwarderHashNoNode)
 			}
@@ -141,72 +157,462 @@ func (f defaultForwarder) Forward(msgs []*proto.Message) error {
 	return nil
 }
 
-// Close close forwarder.
-func (f defaultForwarder) Close() error {
+// Update impl proto.Forwarder.
+// The pipes of kept servers are reused, new servers are dialed and the removed servers are drained and closed.
+func (f *defaultForwarder) Update(servers []string) error {
+	if closed := atomic.LoadInt32(&f.state); closed == forwarderStateClosed {
+		return ErrForwarderClosed
+	}
+	// parse servers config
+	addrs, replicas, ws, ans, alias, err := parseServers(servers)
+	if err != nil {
+		return err
+	}
+	names := ans
+	if !alias && f.cc.TwemproxyPlacement {
+		names = make([]string, len(addrs))
+		for idx, addr := range addrs {
+			names[idx] = nodeName(addr)
+		}
+	} else if !alias {
+		names = addrs
+	}
+	var all []string
+	nodeAddr := make(map[string]string)
+	nodeGroup := make(map[string][]string)
+	for idx, name := range names {
+		nodeAddr[name] = addrs[idx]
+		nodeGroup[name] = append([]string{addrs[idx]}, replicas[idx]...)
+		all = append(all, nodeGroup[name]...)
+	}
+	f.lock.Lock()
+	for _, p := range f.pingers {
+		p.close()
+	}
+	f.pingers = nil
+	nodePipe := make(map[string]*proto.NodeConnPipe)
+	for _, addr := range all {
+		if ncp, ok := f.nodePipe[addr]; ok {
+			nodePipe[addr] = ncp
+			continue
+		}
+		toAddr := addr // NOTE: avoid closure
+		nodePipe[toAddr] = proto.NewNodeConnPipe(f.cc.Name, toAddr, f.cc.NodeConnections, f.cc.breakerConfig(), func() proto.NodeConn {
+			return newNodeConn(f.cc, toAddr)
+		})
+		if log.V(4) {
+			log.Infof("cluster(%s) forwarder add node:%s", f.cc.Name, toAddr)
+		}
+	}
+	var removed []*proto.NodeConnPipe
+	for addr, ncp := range f.nodePipe {
+		if _, ok := nodePipe[addr]; !ok {
+			removed = append(removed, ncp)
+			if log.V(4) {
+				log.Infof("cluster(%s) forwarder remove node:%s", f.cc.Name, addr)
+			}
+		}
+	}
+	f.nodeAddr = nodeAddr
+	f.nodeGroup = nodeGroup
+	f.nodePipe = nodePipe
+	f.names = names
+	f.weights = ws
+	f.down = make(map[string]struct{})
+	manual := make(map[string]struct{})
+	for _, name := range names {
+		if _, ok := f.manual[name]; ok {
+			manual[name] = struct{}{}
+		}
+	}
+	f.manual = manual
+	f.initRing()
+	if f.cc.PingAutoEject {
+		for _, name := range names {
+			for _, addr := range nodeGroup[name] {
+				p := &pinger{cc: f.cc, node: addr, name: name, stop: make(chan struct{})}
+				f.pingers = append(f.pingers, p)
+				go f.processPing(p)
+			}
+		}
+	}
+	f.lock.Unlock()
+	for _, ncp := range removed {
+		ncp.Close()
+	}
+	return nil
+}
+
+// Close close forwarder, stop the pingers and close the pipes.
+// The messages already pushed will be drained by pipes before the node conns closed.
+func (f *defaultForwarder) Close() error {
 	if !atomic.CompareAndSwapInt32(&f.state, forwarderStateOpening, forwarderStateClosed) {
 		return nil
 	}
+	f.lock.Lock()
+	for _, p := range f.pingers {
+		p.close()
+	}
+	f.pingers = nil
+	for _, ncp := range f.nodePipe {
+		ncp.Close() // NOTE: the racing Forward will get ErrNodeConnPipeClosed
+	}
+	f.lock.Unlock()
 	return nil
 }
 
-func (f defaultForwarder) processPing(p *pinger) {
-	del := false
+func (f *defaultForwarder) processPing(p *pinger) {
+	if p.ping == nil {
+		p.ping = newPingConn(p.cc, p.node)
+	}
+	defer func() {
+		_ = p.ping.Close()
+	}()
 	for {
 		if err := p.ping.Ping(); err != nil {
-			p.failure++
+			failure := atomic.AddInt32(&p.failure, 1)
 			p.retries = 0
 			if netE, ok := err.(net.Error); !ok || !netE.Temporary() {
 				_ = p.ping.Close()
 				p.ping = newPingConn(p.cc, p.node)
 			}
 			if log.V(3) {
-				log.Warnf("node ping node:%s fail:%d times with err:%v", p.node, p.failure, err)
+				log.Warnf("node ping node:%s fail:%d times with err:%v", p.node, failure, err)
 			}
 		} else {
-			p.failure = 0
-			if del {
-				if p.alias != "" {
-					f.ring.AddNode(p.alias, p.weight)
-				} else {
-					f.ring.AddNode(p.node, p.weight)
+			atomic.StoreInt32(&p.failure, 0)
+			if f.isDown(p.node) {
+				if !f.readdNode(p) {
+					return
 				}
-				del = false
 				if log.V(4) {
 					log.Infof("node ping node:%s success and readd", p.node)
 				}
 			}
 		}
-		if f.cc.PingAutoEject && p.failure >= f.cc.PingFailLimit {
-			if p.alias != "" {
-				f.ring.DelNode(p.alias)
-			} else {
-				f.ring.DelNode(p.node)
+		// NOTE: ejected and counted only when the limit reached, the later failures keep it down until readded.
+		// The down state is checked by forwarder instead of pinger, so the node readded by admin is ejected again.
+		if f.cc.PingAutoEject && int(atomic.LoadInt32(&p.failure)) >= f.cc.PingFailLimit && !f.isDown(p.node) {
+			if !f.ejectNode(p) {
+				return
 			}
-			del = true
 			if log.V(2) {
 				log.Errorf("node ping node:%s fail times equals limit:%d then del", p.node, f.cc.PingFailLimit)
 			}
 		}
-		<-time.After(backoff.Backoff(p.retries))
+		select {
+		case <-time.After(backoff.Backoff(p.retries)):
+		case <-p.stop:
+			return
+		}
 		p.retries++
 	}
 }
 
-func (f defaultForwarder) getPipes(key []byte) (ncp *proto.NodeConnPipe, ok bool) {
-	var addr string
-	if addr, ok = f.ring.GetNode(f.trimHashTag(key)); !ok {
+// isDown returns whether the node is marked down by pinger.
+func (f *defaultForwarder) isDown(node string) bool {
+	f.lock.RLock()
+	_, ok := f.down[node]
+	f.lock.RUnlock()
+	return ok
+}
+
+// ejectNode mark pinger node down, the group fails over to the replica or is deleted from ring when all down.
+// Return false when pinger already stopped.
+func (f *defaultForwarder) ejectNode(p *pinger) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if p.stopped() {
+		return false
+	}
+	f.down[p.node] = struct{}{}
+	f.initRing()
+	if rt, ok := f.routes[p.name]; ok && p.node == f.nodeAddr[p.name] {
+		if log.V(2) {
+			log.Warnf("cluster(%s) node:%s primary:%s down and fail over to:%s", f.cc.Name, p.name, p.node, rt.write)
+		}
+	}
+	if prom.On {
+		prom.EjectIncr(f.cc.Name, p.node)
+	}
+	return true
+}
+
+// readdNode mark pinger node alive and add it into ring, return false when pinger already stopped.
+func (f *defaultForwarder) readdNode(p *pinger) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if p.stopped() {
+		return false
+	}
+	delete(f.down, p.node)
+	f.initRing()
+	return true
+}
+
+// initRing init ring with the nodes which not ejected and the routes of groups, must be called with lock.
+// NOTE: keep the config order for modula distribution same as twemproxy.
+func (f *defaultForwarder) initRing() {
+	var (
+		names   = make([]string, 0, len(f.names))
+		weights = make([]int, 0, len(f.weights))
+	)
+	f.ejected = make(map[string]struct{})
+	f.routes = make(map[string]*nodeRoute, len(f.names))
+	for idx, name := range f.names {
+		rt := newNodeRoute(f.nodeGroup[name], f.down, f.cc.ReadPolicy)
+		if rt == nil {
+			f.ejected[name] = struct{}{}
+			continue
+		}
+		f.routes[name] = rt
+		if _, ok := f.manual[name]; ok {
+			continue
+		}
+		names = append(names, name)
+		weights = append(weights, f.weights[idx])
+	}
+	f.ring.Init(names, weights)
+}
+
+// Eject eject the node from ring by admin, node can be the alias or the addr.
+func (f *defaultForwarder) Eject(node string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	name, ok := f.lookupName(node)
+	if !ok {
+		return ErrForwarderNoSuchNode
+	}
+	f.manual[name] = struct{}{}
+	f.initRing()
+	if prom.On {
+		prom.EjectIncr(f.cc.Name, f.nodeAddr[name])
+	}
+	return nil
+}
+
+// Readd add the ejected node into ring by admin, node can be the alias or the addr.
+// The node still failing ping is ejected again by pinger.
+func (f *defaultForwarder) Readd(node string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	name, ok := f.lookupName(node)
+	if !ok {
+		return ErrForwarderNoSuchNode
+	}
+	delete(f.manual, name)
+	for _, addr := range f.nodeGroup[name] {
+		delete(f.down, addr)
+	}
+	f.initRing()
+	return nil
+}
+
+// lookupName returns the ring node name by alias or addr of primary or replica, must be called with lock.
+func (f *defaultForwarder) lookupName(node string) (string, bool) {
+	if _, ok := f.nodeAddr[node]; ok {
+		return node, true
+	}
+	for name, addrs := range f.nodeGroup {
+		for _, addr := range addrs {
+			if addr == node {
+				return name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// State returns the snapshot of ring nodes, ping failures and node conns.
+func (f *defaultForwarder) State() *proto.ForwarderState {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	failures := make(map[string]int)
+	for _, p := range f.pingers {
+		failures[p.node] = int(atomic.LoadInt32(&p.failure))
+	}
+	inRing := make(map[string]struct{})
+	nodes, _ := f.ring.Nodes()
+	for _, node := range nodes {
+		inRing[node] = struct{}{}
+	}
+	st := &proto.ForwarderState{}
+	for idx, name := range f.names {
+		_, in := inRing[name]
+		_, manual := f.manual[name]
+		ns := &proto.NodeState{
+			Name:     name,
+			Addr:     f.nodeAddr[name],
+			Replicas: f.nodeGroup[name][1:],
+			Weight:   f.weights[idx],
+			Ejected:  !in,
+			Manual:   manual,
+		}
+		ns.PingFailures = failures[ns.Addr]
+		for _, addr := range f.nodeGroup[name] {
+			if _, ok := f.down[addr]; ok {
+				ns.Down = append(ns.Down, addr)
+			}
+		}
+		if rt, ok := f.routes[name]; ok && rt.write != ns.Addr {
+			ns.Failover = rt.write
+		}
+		if ncp, ok := f.nodePipe[ns.Addr]; ok {
+			ns.Conns = ncp.Conns()
+			if f.cc.BreakerErrorRate > 0 {
+				ns.Breaker = ncp.BreakerState().String()
+			}
+		}
+		st.Nodes = append(st.Nodes, ns)
+	}
+	return st
+}
+
+// broadcast forward the copies of message to all the nodes, the group primary or the replica when primary is down.
+// NOTE: the nodes all down are skipped.
+func (f *defaultForwarder) broadcast(m *proto.Message, bc proto.Broadcaster) {
+	var (
+		addrs []string
+		pipes []*proto.NodeConnPipe
+	)
+	f.lock.RLock()
+	for _, name := range f.names {
+		rt, ok := f.routes[name]
+		if !ok {
+			continue
+		}
+		if ncp, ok := f.nodePipe[rt.write]; ok {
+			addrs = append(addrs, rt.write)
+			pipes = append(pipes, ncp)
+		}
+	}
+	f.lock.RUnlock()
+	if len(pipes) == 0 {
+		f.hashMiss()
+		m.WithError(ErrForwarderHashNoNode)
 		return
 	}
-	if f.alias {
-		if addr, ok = f.aliasMap[addr]; !ok {
-			return
+	bc.Broadcast(m, addrs)
+	if !m.IsBatch() {
+		pipes[0].Push(m)
+		return
+	}
+	for idx, subm := range m.Batch() {
+		pipes[idx].Push(subm)
+	}
+}
+
+// scan forward the message to the node of cursor in config order, the group primary or the replica when primary is down.
+// NOTE: the replicas of read policy are never scanned for the cursor is only valid on the same node.
+func (f *defaultForwarder) scan(m *proto.Message, sc proto.Scanner) {
+	f.lock.RLock()
+	idx, ok := sc.Scan(len(f.names))
+	var ncp *proto.NodeConnPipe
+	if ok {
+		if rt, has := f.routes[f.names[idx]]; has {
+			ncp = f.nodePipe[rt.write]
 		}
 	}
+	f.lock.RUnlock()
+	if !ok {
+		return
+	}
+	if ncp == nil {
+		f.hashMiss()
+		m.WithError(ErrForwarderHashNoNode)
+		return
+	}
+	ncp.Push(m)
+}
+
+// KeyNode impl proto.Router, the channel is hashed like key, so the PUBLISH and SUBSCRIBE meet on the same node.
+func (f *defaultForwarder) KeyNode(key []byte) (string, bool) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	name, ok := f.ring.GetNode(f.trimHashTag(key))
+	if !ok {
+		return "", false
+	}
+	rt, ok := f.routes[name]
+	if !ok {
+		return "", false
+	}
+	return rt.write, true
+}
+
+// PatternNodes impl proto.Router, the patterns are subscribed on all the nodes for the channels are spread by hash.
+func (f *defaultForwarder) PatternNodes() (addrs []string) {
+	f.lock.RLock()
+	for _, name := range f.names {
+		if rt, ok := f.routes[name]; ok {
+			addrs = append(addrs, rt.write)
+		}
+	}
+	f.lock.RUnlock()
+	return
+}
+
+// SameNode impl proto.Router.
+func (f *defaultForwarder) SameNode(keys [][]byte) bool {
+	return f.sameNode(keys)
+}
+
+// Dial impl proto.Router.
+func (f *defaultForwarder) Dial(addr string) (*libnet.Conn, error) {
+	dto := time.Duration(f.cc.DialTimeout) * time.Millisecond
+	rto := time.Duration(f.cc.ReadTimeout) * time.Millisecond
+	wto := time.Duration(f.cc.WriteTimeout) * time.Millisecond
+	conn := libnet.DialWithTimeout(addr, dto, rto, wto)
+	if err := redis.Auth(conn, f.cc.RedisAuth); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// getPipes returns the pipe of the group primary, or the replica when primary is down or the read request
+// is forwarded to replicas by read policy.
+func (f *defaultForwarder) getPipes(key []byte, read bool) (ncp *proto.NodeConnPipe, ok bool) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	var (
+		name string
+		rt   *nodeRoute
+	)
+	if name, ok = f.ring.GetNode(f.trimHashTag(key)); !ok {
+		return
+	}
+	if rt, ok = f.routes[name]; !ok {
+		return
+	}
+	addr := rt.write
+	if read && len(rt.reads) != 0 {
+		addr = rt.reads[atomic.AddUint32(&f.rr, 1)%uint32(len(rt.reads))]
+	}
 	ncp, ok = f.nodePipe[addr]
 	return
 }
 
-func (f defaultForwarder) trimHashTag(key []byte) []byte {
+// sameNode check all the keys whether hashed to the same node of ring.
+func (f *defaultForwarder) sameNode(keys [][]byte) bool {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	first, _ := f.ring.GetNode(f.trimHashTag(keys[0]))
+	for _, key := range keys[1:] {
+		if node, _ := f.ring.GetNode(f.trimHashTag(key)); node != first {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *defaultForwarder) hashMiss() {
+	if prom.On {
+		prom.HashMissIncr(f.cc.Name)
+	}
+}
+
+func (f *defaultForwarder) trimHashTag(key []byte) []byte {
 	if len(f.hashTag) != 2 {
 		return key
 	}
@@ -221,15 +627,74 @@ func (f defaultForwarder) trimHashTag(key []byte) []byte {
 	return key[bidx+1 : bidx+1+eidx]
 }
 
+// nodeRoute is the addrs of group which the requests forwarded to.
+type nodeRoute struct {
+	write string   // the first alive addr, primary first
+	reads []string // candidates of read-only requests by read policy, empty means the write addr
+}
+
+// newNodeRoute returns the route of group addrs skipped the down, nil when all the addrs are down.
+func newNodeRoute(addrs []string, down map[string]struct{}, rp proto.ReadPolicy) *nodeRoute {
+	var alive []string
+	for _, addr := range addrs {
+		if _, ok := down[addr]; !ok {
+			alive = append(alive, addr)
+		}
+	}
+	if len(alive) == 0 {
+		return nil
+	}
+	rt := &nodeRoute{write: alive[0]}
+	switch rp {
+	case proto.ReadPolicyPreferReplica:
+		for _, addr := range alive {
+			if addr != addrs[0] {
+				rt.reads = append(rt.reads, addr)
+			}
+		}
+	case proto.ReadPolicyRoundRobin:
+		if len(alive) > 1 {
+			rt.reads = alive
+		}
+	}
+	return rt
+}
+
+// readOnlyRequest is implemented by the requests which can tell whether only read the data.
+type readOnlyRequest interface {
+	IsReadOnly() bool
+}
+
+func isReadOnly(req proto.Request) bool {
+	if rr, ok := req.(readOnlyRequest); ok {
+		return rr.IsReadOnly()
+	}
+	return false
+}
+
 type pinger struct {
-	cc     *ClusterConfig
-	ping   proto.Pinger
-	node   string
-	alias  string
-	weight int
+	cc   *ClusterConfig
+	ping proto.Pinger
+	node string
+	name string
 
-	failure int
+	failure int32
 	retries int
+
+	stop chan struct{}
+}
+
+func (p *pinger) close() {
+	close(p.stop)
+}
+
+func (p *pinger) stopped() bool {
+	select {
+	case <-p.stop:
+		return true
+	default:
+		return false
+	}
 }
 
 func newNodeConn(cc *ClusterConfig, addr string) proto.NodeConn {
@@ -242,7 +707,7 @@ func newNodeConn(cc *ClusterConfig, addr string) proto.NodeConn {
 	case proto.CacheTypeMemcacheBinary:
 		return mcbin.NewNodeConn(cc.Name, addr, dto, rto, wto)
 	case proto.CacheTypeRedis:
-		return redis.NewNodeConn(cc.Name, addr, dto, rto, wto)
+		return redis.NewNodeConn(cc.Name, addr, cc.RedisAuth, dto, rto, wto)
 	default:
 		panic(proto.ErrNoSupportCacheType)
 	}
@@ -260,47 +725,85 @@ func newPingConn(cc *ClusterConfig, addr string) proto.Pinger {
 	case proto.CacheTypeMemcacheBinary:
 		return mcbin.NewPinger(conn)
 	case proto.CacheTypeRedis:
-		return redis.NewPinger(conn)
+		return redis.NewPinger(conn, cc.RedisAuth)
 	default:
 		panic(proto.ErrNoSupportCacheType)
 	}
 }
 
-func parseServers(svrs []string) (addrs []string, ws []int, ans []string, alias bool, err error) {
-	for _, svr := range svrs {
-		if strings.Contains(svr, " ") {
-			alias = true
-		} else if alias {
+// nodeName returns the ring node name of addr without alias in twemproxy_placement,
+// the port is omitted when it is 11211 to keep the same key placement as twemproxy and libmemcached.
+func nodeName(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err == nil && port == "11211" {
+		return host
+	}
+	return addr
+}
+
+func parseServers(svrs []string) (addrs []string, replicas [][]string, ws []int, ans []string, alias bool, err error) {
+	for idx, svr := range svrs {
+		addr, rs, w, an, perr := parseServer(svr)
+		if perr != nil {
+			err = perr
+			return
+		}
+		if idx == 0 {
+			alias = an != ""
+		} else if alias != (an != "") {
+			// NOTE: all servers must be with alias or not.
 			err = ErrConfigServerFormat
 			return
 		}
-		var (
-			ss    []string
-			addrW string
-		)
+		addrs = append(addrs, addr)
+		replicas = append(replicas, rs)
+		ws = append(ws, w)
 		if alias {
-			ss = strings.Split(svr, " ")
-			if len(ss) != 2 {
-				err = ErrConfigServerFormat
-				return
-			}
-			addrW = ss[0]
-			ans = append(ans, ss[1])
-		} else {
-			addrW = svr
+			ans = append(ans, an)
 		}
-		ss = strings.Split(addrW, ":")
-		if len(ss) != 3 {
+	}
+	return
+}
+
+// parseServer parse the server line like "host:port:weight" or "host:port:weight alias",
+// the replicas of group follow the weight like "host:port:weight,host:port,host:port alias".
+func parseServer(svr string) (addr string, replicas []string, w int, alias string, err error) {
+	ss := strings.Split(svr, " ")
+	if len(ss) > 2 || (len(ss) == 2 && ss[1] == "") {
+		err = ErrConfigServerFormat
+		return
+	}
+	if len(ss) == 2 {
+		alias = ss[1]
+	}
+	group := strings.Split(ss[0], ",")
+	hs := strings.Split(group[0], ":")
+	if len(hs) != 3 || hs[0] == "" {
+		err = ErrConfigServerFormat
+		return
+	}
+	if port, pe := conv.Btoi([]byte(hs[1])); pe != nil || port <= 0 || port > 65535 {
+		err = ErrConfigServerFormat
+		return
+	}
+	weight, we := conv.Btoi([]byte(hs[2]))
+	if we != nil || weight <= 0 {
+		err = ErrConfigServerFormat
+		return
+	}
+	for _, replica := range group[1:] {
+		rhs := strings.Split(replica, ":")
+		if len(rhs) != 2 || rhs[0] == "" {
 			err = ErrConfigServerFormat
 			return
 		}
-		addrs = append(addrs, net.JoinHostPort(ss[0], ss[1]))
-		w, we := conv.Btoi([]byte(ss[2]))
-		if we != nil || w <= 0 {
+		if port, pe := conv.Btoi([]byte(rhs[1])); pe != nil || port <= 0 || port > 65535 {
 			err = ErrConfigServerFormat
 			return
 		}
-		ws = append(ws, int(w))
+		replicas = append(replicas, net.JoinHostPort(rhs[0], rhs[1]))
 	}
+	addr = net.JoinHostPort(hs[0], hs[1])
+	w = int(weight)
 	return
 }
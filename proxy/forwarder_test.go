@@ -0,0 +1,270 @@
+package proxy
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"overlord/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestForwarder(servers ...string) *defaultForwarder {
+	cc := &ClusterConfig{
+		Name:             "test-forwarder",
+		HashMethod:       "fnv1a_64",
+		HashDistribution: "ketama",
+		CacheType:        proto.CacheTypeMemcache,
+		ListenProto:      "tcp",
+		DialTimeout:      10,
+		ReadTimeout:      10,
+		WriteTimeout:     10,
+		NodeConnections:  1,
+		Servers:          servers,
+	}
+	return newDefaultForwarder(cc).(*defaultForwarder)
+}
+
+func TestForwarderUpdateServers(t *testing.T) {
+	f := newTestForwarder("127.0.0.1:21301:1", "127.0.0.1:21302:1")
+	defer f.Close()
+	assert.Len(t, f.nodePipe, 2)
+	old := f.nodePipe["127.0.0.1:21301"]
+
+	err := f.Update([]string{"127.0.0.1:21301:2", "127.0.0.1:21303:1"})
+	assert.NoError(t, err)
+	assert.Len(t, f.nodePipe, 2)
+	assert.True(t, old == f.nodePipe["127.0.0.1:21301"], "kept node should reuse the pipe")
+	assert.Contains(t, f.nodePipe, "127.0.0.1:21303")
+	assert.NotContains(t, f.nodePipe, "127.0.0.1:21302")
+
+	hits := map[string]int{}
+	for _, key := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"} {
+		node, ok := f.ring.GetNode([]byte(key))
+		assert.True(t, ok)
+		hits[node]++
+	}
+	assert.NotContains(t, hits, "127.0.0.1:21302")
+}
+
+func TestForwarderUpdateAlias(t *testing.T) {
+	f := newTestForwarder("127.0.0.1:21301:1 mc1")
+	defer f.Close()
+	assert.NoError(t, f.Update([]string{"127.0.0.1:21302:1 mc1"}))
+	ncp, ok := f.getPipes([]byte("key"), false)
+	assert.True(t, ok)
+	assert.True(t, ncp == f.nodePipe["127.0.0.1:21302"])
+}
+
+func TestForwarderUpdateBadServers(t *testing.T) {
+	f := newTestForwarder("127.0.0.1:21301:1")
+	defer f.Close()
+	assert.Equal(t, ErrConfigServerFormat, f.Update([]string{"127.0.0.1:21301"}))
+	assert.Contains(t, f.nodePipe, "127.0.0.1:21301")
+}
+
+func TestForwarderUpdateClosed(t *testing.T) {
+	f := newTestForwarder("127.0.0.1:21301:1")
+	assert.NoError(t, f.Close())
+	assert.Equal(t, ErrForwarderClosed, f.Update([]string{"127.0.0.1:21302:1"}))
+}
+
+func TestForwarderNodeName(t *testing.T) {
+	f := newTestForwarder("127.0.0.1:11211:1", "127.0.0.1:11212:1")
+	assert.Equal(t, []string{"127.0.0.1:11211", "127.0.0.1:11212"}, f.names, "the same as before 1.6.0 by default")
+	f.Close()
+
+	cc := *f.cc
+	cc.TwemproxyPlacement = true
+	f = newDefaultForwarder(&cc).(*defaultForwarder)
+	defer f.Close()
+	assert.Equal(t, []string{"127.0.0.1", "127.0.0.1:11212"}, f.names)
+	assert.Equal(t, "127.0.0.1:11211", f.nodeAddr["127.0.0.1"])
+	ncp, ok := f.getPipes([]byte("key"), false)
+	assert.True(t, ok)
+	assert.NotNil(t, ncp)
+}
+
+func TestForwarderEjectKeepOrder(t *testing.T) {
+	f := newTestForwarder("127.0.0.1:21301:1", "127.0.0.1:21302:1", "127.0.0.1:21303:1")
+	defer f.Close()
+	p := &pinger{node: "127.0.0.1:21301", name: "127.0.0.1:21301", stop: make(chan struct{})}
+	assert.True(t, f.ejectNode(p))
+	for _, key := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"} {
+		node, ok := f.ring.GetNode([]byte(key))
+		assert.True(t, ok)
+		assert.NotEqual(t, "127.0.0.1:21301", node)
+	}
+	assert.True(t, f.readdNode(p))
+	assert.Empty(t, f.ejected)
+	p.close()
+	assert.False(t, f.ejectNode(p))
+}
+
+func TestForwarderReplicaFailover(t *testing.T) {
+	f := newTestForwarder("127.0.0.1:21301:1,127.0.0.1:21311,127.0.0.1:21321 mc1", "127.0.0.1:21302:1 mc2")
+	defer f.Close()
+	assert.Len(t, f.nodePipe, 4)
+	assert.Equal(t, []string{"mc1", "mc2"}, f.names)
+	key := []byte("b") // NOTE: hashed to mc1
+	node, _ := f.ring.GetNode(key)
+	assert.Equal(t, "mc1", node)
+	ncp, ok := f.getPipes(key, false)
+	assert.True(t, ok)
+	assert.True(t, ncp == f.nodePipe["127.0.0.1:21301"])
+
+	primary := &pinger{node: "127.0.0.1:21301", name: "mc1", stop: make(chan struct{})}
+	replica := &pinger{node: "127.0.0.1:21311", name: "mc1", stop: make(chan struct{})}
+	assert.True(t, f.ejectNode(primary))
+	nodes, _ := f.ring.Nodes()
+	assert.Equal(t, []string{"mc1", "mc2"}, nodes, "group should fail over instead of ejected")
+	ncp, _ = f.getPipes(key, false)
+	assert.True(t, ncp == f.nodePipe["127.0.0.1:21311"])
+	st := f.State()
+	assert.Equal(t, []string{"127.0.0.1:21311", "127.0.0.1:21321"}, st.Nodes[0].Replicas)
+	assert.Equal(t, []string{"127.0.0.1:21301"}, st.Nodes[0].Down)
+	assert.Equal(t, "127.0.0.1:21311", st.Nodes[0].Failover)
+
+	assert.True(t, f.ejectNode(replica))
+	ncp, _ = f.getPipes(key, false)
+	assert.True(t, ncp == f.nodePipe["127.0.0.1:21321"])
+	assert.True(t, f.ejectNode(&pinger{node: "127.0.0.1:21321", name: "mc1", stop: make(chan struct{})}))
+	nodes, _ = f.ring.Nodes()
+	assert.Equal(t, []string{"mc2"}, nodes)
+	assert.Contains(t, f.ejected, "mc1")
+
+	assert.True(t, f.readdNode(primary))
+	nodes, _ = f.ring.Nodes()
+	assert.Equal(t, []string{"mc1", "mc2"}, nodes)
+	ncp, _ = f.getPipes(key, false)
+	assert.True(t, ncp == f.nodePipe["127.0.0.1:21301"])
+}
+
+func waitDown(f *defaultForwarder, node string) {
+	for i := 0; i < 300; i++ {
+		if f.isDown(node) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestForwarderReaddPingFailing(t *testing.T) {
+	f := newTestForwarder("127.0.0.1:21301:1 mc1", "127.0.0.1:21302:1 mc2")
+	defer f.Close()
+	f.cc.PingAutoEject, f.cc.PingFailLimit = true, 1
+	p := &pinger{cc: f.cc, node: "127.0.0.1:21301", name: "mc1", stop: make(chan struct{})}
+	defer p.close()
+	go f.processPing(p) // NOTE: nothing listens on the node, the pings keep failing
+	waitDown(f, p.node)
+	nodes, _ := f.ring.Nodes()
+	assert.Equal(t, []string{"mc2"}, nodes)
+
+	assert.NoError(t, f.Readd("mc1"))
+	nodes, _ = f.ring.Nodes()
+	assert.Equal(t, []string{"mc1", "mc2"}, nodes)
+	waitDown(f, p.node)
+	nodes, _ = f.ring.Nodes()
+	assert.Equal(t, []string{"mc2"}, nodes, "ejected again by the failing ping")
+}
+
+func TestForwarderReadPolicy(t *testing.T) {
+	f := newTestForwarder("127.0.0.1:21301:1,127.0.0.1:21311 mc1")
+	defer f.Close()
+	for _, tc := range []struct {
+		rp    proto.ReadPolicy
+		reads map[string]bool
+	}{
+		{proto.ReadPolicyMasterOnly, map[string]bool{"127.0.0.1:21301": true}},
+		{proto.ReadPolicyPreferReplica, map[string]bool{"127.0.0.1:21311": true}},
+		{proto.ReadPolicyRoundRobin, map[string]bool{"127.0.0.1:21301": true, "127.0.0.1:21311": true}},
+	} {
+		f.lock.Lock()
+		f.cc.ReadPolicy = tc.rp
+		f.initRing()
+		f.lock.Unlock()
+		reads := map[string]bool{}
+		for i := 0; i < 4; i++ {
+			ncp, ok := f.getPipes([]byte("key"), true)
+			assert.True(t, ok)
+			for addr, p := range f.nodePipe {
+				if p == ncp {
+					reads[addr] = true
+				}
+			}
+			ncp, _ = f.getPipes([]byte("key"), false)
+			assert.True(t, ncp == f.nodePipe["127.0.0.1:21301"], "write always go to primary")
+		}
+		assert.Equal(t, tc.reads, reads, string(tc.rp))
+	}
+	// NOTE: prefer_replica reads go to primary when all replicas down.
+	f.lock.Lock()
+	f.cc.ReadPolicy = proto.ReadPolicyPreferReplica
+	f.lock.Unlock()
+	assert.True(t, f.ejectNode(&pinger{node: "127.0.0.1:21311", name: "mc1", stop: make(chan struct{})}))
+	ncp, _ := f.getPipes([]byte("key"), true)
+	assert.True(t, ncp == f.nodePipe["127.0.0.1:21301"])
+}
+
+func TestForwarderSameNode(t *testing.T) {
+	f := newTestForwarder("127.0.0.1:21301:1", "127.0.0.1:21302:1")
+	defer f.Close()
+	f.hashTag = []byte("{}")
+	keys := [][]byte{[]byte("0-key")}
+	first, _ := f.ring.GetNode(keys[0])
+	for i := 1; i < 100 && len(keys) == 1; i++ {
+		key := []byte(fmt.Sprintf("%d-key", i))
+		if node, _ := f.ring.GetNode(key); node != first {
+			keys = append(keys, key)
+		}
+	}
+	assert.Len(t, keys, 2)
+	assert.False(t, f.sameNode(keys))
+	assert.True(t, f.sameNode([][]byte{[]byte("{a}.1"), []byte("{a}.2"), []byte("a")}))
+}
+
+func TestForwarderCrossSlot(t *testing.T) {
+	rs1, rs2 := newFakeRedis(t), newFakeRedis(t)
+	defer rs1.Close()
+	defer rs2.Close()
+	cc := newServedClusterConfig("crossslot", "", rs1.Addr().String()+":1", rs2.Addr().String()+":1")
+	cc.CacheType = proto.CacheTypeRedis
+	cc.HashTag = "{}"
+	p, rw := newServedTestProxy(t, cc)
+	defer p.Close()
+
+	f := p.forwarders[cc.Name].(*defaultForwarder)
+	first, _ := f.ring.GetNode([]byte("0-key"))
+	var other string
+	for i := 1; i < 100 && other == ""; i++ {
+		key := fmt.Sprintf("%d-key", i)
+		if node, _ := f.ring.GetNode([]byte(key)); node != first {
+			other = key
+		}
+	}
+	assert.NotEmpty(t, other)
+	cmd := fmt.Sprintf("*3\r\n$6\r\nRENAME\r\n$5\r\n0-key\r\n$%d\r\n%s\r\n", len(other), other)
+	assert.Equal(t, "-CROSSSLOT Keys in request don't hash to the same slot\r\n", roundTrip(t, rw, cmd, 1))
+	cmd = "*3\r\n$6\r\nRENAME\r\n$4\r\n{a}1\r\n$4\r\n{a}2\r\n"
+	assert.Equal(t, "-ERR unknown command\r\n", roundTrip(t, rw, cmd, 1), "forwarded to node")
+}
+
+func TestForwarderBroadcast(t *testing.T) {
+	mc1, mc2 := newFakeMemcache(t), newFakeMemcache(t)
+	defer mc1.Close()
+	defer mc2.Close()
+	p, rw := newServedTestProxy(t, newServedClusterConfig("broadcast", "", mc1.Addr().String()+":1 mc1", mc2.Addr().String()+":1 mc2"))
+	defer p.Close()
+
+	mc1.set("a", "1")
+	mc2.set("b", "2")
+	mc2.set("c", "3")
+	reply := roundTrip(t, rw, "stats\r\n", 5)
+	assert.Equal(t, "STAT node "+mc1.Addr().String()+"\r\nSTAT curr_items 1\r\nSTAT node "+mc2.Addr().String()+"\r\nSTAT curr_items 2\r\nEND\r\n", reply)
+	assert.Equal(t, "OK\r\n", roundTrip(t, rw, "flush_all\r\n", 1))
+	_, ok1 := mc1.get("a")
+	_, ok2 := mc2.get("b")
+	assert.False(t, ok1 || ok2, "flush all the nodes")
+	assert.Equal(t, "ERROR\r\n", roundTrip(t, rw, "version\r\n", 1), "error of node replied")
+}
@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	errs "errors"
 	"io"
 	"net"
 	"sync"
@@ -22,6 +23,11 @@ const (
 	handlerClosed  = int32(1)
 )
 
+// handler errors
+var (
+	ErrHandlerDrained = errs.New("handler drained by proxy closing")
+)
+
 // variables need to change
 var (
 	// TODO: config and reduce to small
@@ -35,12 +41,21 @@ type Handler struct {
 	cc *ClusterConfig
 
 	forwarder proto.Forwarder
+	fallback  fallbacker
+	hotkey    *hotKeyCache
+	limiter   *limiter
+	scripts   *scriptCache
+	client    *clientLimiter
+	fwds      []*proto.Message
+	bytes     int64 // NOTE: the bytes of client conn charged by limiter
 
 	conn *libnet.Conn
 	pc   proto.ProxyConn
 
-	closed int32
-	err    error
+	closed   int32
+	draining int32
+	done     chan struct{}
+	err      error
 }
 
 // NewHandler new a conn handler.
@@ -49,7 +64,9 @@ func NewHandler(p *Proxy, cc *ClusterConfig, conn net.Conn, forwarder proto.Forw
 		p:         p,
 		cc:        cc,
 		forwarder: forwarder,
+		done:      make(chan struct{}),
 	}
+	h.fallback, _ = forwarder.(fallbacker)
 	h.conn = libnet.NewConn(conn, time.Second*time.Duration(h.p.c.Proxy.ReadTimeout), time.Second*time.Duration(h.p.c.Proxy.WriteTimeout))
 	// cache type
 	switch cc.CacheType {
@@ -58,12 +75,16 @@ func NewHandler(p *Proxy, cc *ClusterConfig, conn net.Conn, forwarder proto.Forw
 	case proto.CacheTypeMemcacheBinary:
 		h.pc = mcbin.NewProxyConn(h.conn)
 	case proto.CacheTypeRedis:
-		h.pc = redis.NewProxyConn(h.conn)
+		h.pc = redis.NewProxyConn(h.conn, cc.Password, cc.MSetDetail)
 	case proto.CacheTypeRedisCluster:
-		h.pc = rclstr.NewProxyConn(h.conn, forwarder)
+		h.pc = rclstr.NewProxyConn(h.conn, forwarder, cc.Password, cc.MSetDetail)
 	default:
 		panic(proto.ErrNoSupportCacheType)
 	}
+	if pn, ok := h.pc.(proto.Pinner); ok {
+		router, _ := forwarder.(proto.Router)
+		pn.WithRouter(router)
+	}
 	prom.ConnIncr(cc.Name)
 	return
 }
@@ -83,14 +104,40 @@ func (h *Handler) handle() {
 	)
 	messages = h.allocMaxConcurrent(wg, messages, len(msgs))
 	for {
+		// 0. stop reading new requests when draining
+		if atomic.LoadInt32(&h.draining) == 1 {
+			h.deferHandle(messages, ErrHandlerDrained)
+			return
+		}
 		// 1. read until limit or error
 		if msgs, err = h.pc.Decode(messages); err != nil {
+			if atomic.LoadInt32(&h.draining) == 1 {
+				err = ErrHandlerDrained
+			}
 			h.deferHandle(messages, err)
 			return
 		}
-		// 2. send to cluster
-		h.forwarder.Forward(msgs)
+		// 2. send to cluster, the requests exceed the rate limit are rejected and the gets of hot keys are replied by local cache
+		fwds := msgs
+		if h.limiter != nil {
+			h.fwds = h.limiter.allow(h.client, fwds, h.fwds[:0])
+			fwds = h.fwds
+		}
+		if h.hotkey != nil {
+			h.fwds = h.hotkey.serve(fwds, h.fwds[:0])
+			fwds = h.fwds
+		}
+		h.forwarder.Forward(fwds)
 		wg.Wait()
+		if h.fallback != nil {
+			h.fallback.Fallback(fwds, wg)
+		}
+		if h.scripts != nil {
+			h.scripts.reload(h.forwarder, fwds, wg)
+		}
+		if h.hotkey != nil {
+			h.hotkey.store(fwds)
+		}
 		// 3. encode
 		for _, msg := range msgs {
 			if err = h.pc.Encode(msg); err != nil {
@@ -104,15 +151,31 @@ func (h *Handler) handle() {
 				prom.ProxyTime(h.cc.Name, msg.Request().CmdString(), int64(msg.TotalDur()/time.Microsecond))
 			}
 		}
+		if h.hotkey != nil {
+			h.hotkey.execed(msgs)
+		}
 		if err = h.pc.Flush(); err != nil {
 			h.deferHandle(messages, err)
 			return
 		}
+		if h.limiter != nil {
+			h.chargeBytes()
+		}
 		// 4. release resource
 		for _, msg := range msgs {
 			msg.Reset()
 		}
-		// 5. alloc MaxConcurrent
+		// 5. serve the subscribe mode of pub/sub until all unsubscribed
+		if sub, ok := h.pc.(proto.Subscriber); ok && sub.IsSubscribing() {
+			if err = h.subscribe(sub); err != nil {
+				if atomic.LoadInt32(&h.draining) == 1 {
+					err = ErrHandlerDrained
+				}
+				h.deferHandle(messages, err)
+				return
+			}
+		}
+		// 6. alloc MaxConcurrent
 		messages = h.allocMaxConcurrent(wg, messages, len(msgs))
 	}
 }
@@ -134,22 +197,81 @@ func (h *Handler) allocMaxConcurrent(wg *sync.WaitGroup, msgs []*proto.Message,
 	return msgs
 }
 
+// subscribe serve the subscribe mode of pub/sub, the client conn never times out reading in the mode
+// for the messages may be published rarely.
+func (h *Handler) subscribe(sub proto.Subscriber) error {
+	rto := h.conn.SetReadTimeout(0)
+	defer h.conn.SetReadTimeout(rto)
+	_ = h.conn.SetReadDeadline(time.Time{})
+	if atomic.LoadInt32(&h.draining) == 1 { // NOTE: the deadline of drain may be cleared
+		return ErrHandlerDrained
+	}
+	return sub.Subscribe()
+}
+
+// withLimiter limit the requests of handler by the limiter of cluster and the client IP.
+func (h *Handler) withLimiter(l *limiter) {
+	ip := h.conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	h.limiter, h.client = l, l.acquire(ip)
+}
+
+// chargeBytes charge the bytes read and written since last charged.
+func (h *Handler) chargeBytes() {
+	read, written := h.conn.Bytes()
+	h.limiter.charge(h.client, int(read+written-h.bytes))
+	h.bytes = read + written
+}
+
 func (h *Handler) deferHandle(msgs []*proto.Message, err error) {
 	proto.PutMsgs(msgs)
 	h.closeWithError(err)
 	return
 }
 
+// drain stop the handler reading new requests, the in-flight requests will be finished and then the conn closed.
+func (h *Handler) drain() {
+	if atomic.CompareAndSwapInt32(&h.draining, 0, 1) {
+		_ = h.conn.SetReadDeadline(time.Now()) // NOTE: wake up the blocking read
+	}
+}
+
+// forceClose close the underlying client conn to interrupt the handler, which will be closed by itself.
+// The blocking command of client is interrupted too, for the handler is waiting the reply but not reading.
+func (h *Handler) forceClose() {
+	if h.conn.Conn != nil {
+		_ = h.conn.Conn.Close() // NOTE: net.Conn is safe for concurrent use but libnet.Conn is not
+	}
+	if pn, ok := h.pc.(proto.Pinner); ok {
+		pn.Interrupt()
+	}
+}
+
+// Done returns the chan which closed when handler closed.
+func (h *Handler) Done() <-chan struct{} {
+	return h.done
+}
+
 func (h *Handler) closeWithError(err error) {
 	if atomic.CompareAndSwapInt32(&h.closed, handlerOpening, handlerClosed) {
 		h.err = err
 		_ = h.conn.Close()
+		if pn, ok := h.pc.(proto.Pinner); ok {
+			_ = pn.Close()
+		}
+		h.p.removeHandler(h)
+		if h.limiter != nil {
+			h.limiter.release(h.client)
+		}
+		close(h.done)
 		atomic.AddInt32(&h.p.conns, -1) // NOTE: decr!!!
 		if prom.On {
 			prom.ConnDecr(h.cc.Name)
 		}
 		if log.V(2) {
-			if err != io.EOF {
+			if err != io.EOF && err != ErrHandlerDrained {
 				log.Warnf("cluster(%s) addr(%s) remoteAddr(%s) handler close error:%+v", h.cc.Name, h.cc.ListenAddr, h.conn.RemoteAddr(), err)
 			}
 		}
@@ -0,0 +1,202 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"overlord/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newSlowMemcache start a fake memcache server which reply END to every line after delay.
+func newSlowMemcache(t *testing.T, delay time.Duration) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				for {
+					if _, err := br.ReadBytes('\n'); err != nil {
+						return
+					}
+					time.Sleep(delay)
+					if _, err := conn.Write([]byte("END\r\n")); err != nil {
+						return
+					}
+				}
+			}(conn)
+		}
+	}()
+	return l
+}
+
+func newTestProxy(t *testing.T, backend string, shutdownTimeout int) (*Proxy, string) {
+	c := DefaultConfig()
+	c.Proxy.ShutdownTimeout = shutdownTimeout
+	p, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc := &ClusterConfig{
+		Name:             "drain-cluster",
+		HashMethod:       "fnv1a_64",
+		HashDistribution: "ketama",
+		CacheType:        proto.CacheTypeMemcache,
+		ListenProto:      "tcp",
+		ListenAddr:       "127.0.0.1:0",
+		DialTimeout:      100,
+		ReadTimeout:      1000,
+		WriteTimeout:     1000,
+		NodeConnections:  1,
+		Servers:          []string{backend + ":1"},
+	}
+	p.Serve([]*ClusterConfig{cc})
+	return p, p.listeners[cc.Name].Addr().String()
+}
+
+func waitHandlers(p *Proxy, n int) {
+	for i := 0; i < 100; i++ {
+		p.lock.Lock()
+		l := len(p.handlers)
+		p.lock.Unlock()
+		if l == n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestProxyCloseDrainInflight(t *testing.T) {
+	mc := newSlowMemcache(t, 200*time.Millisecond)
+	defer mc.Close()
+	p, addr := newTestProxy(t, mc.Addr().String(), 2000)
+
+	conn, err := net.Dial("tcp", addr)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	idle, err := net.Dial("tcp", addr)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer idle.Close()
+	waitHandlers(p, 2)
+
+	_, err = conn.Write([]byte("get a_11\r\n"))
+	assert.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	assert.NoError(t, p.Close())
+	assert.True(t, time.Since(start) < time.Second)
+
+	br := bufio.NewReader(conn)
+	line, err := br.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "END\r\n", line)
+	_, err = br.ReadByte()
+	assert.Equal(t, io.EOF, err)
+
+	_, err = bufio.NewReader(idle).ReadByte()
+	assert.Equal(t, io.EOF, err)
+
+	_, err = net.DialTimeout("tcp", addr, 100*time.Millisecond)
+	assert.Error(t, err)
+	waitHandlers(p, 0)
+	p.lock.Lock()
+	assert.Len(t, p.handlers, 0)
+	p.lock.Unlock()
+	assert.Equal(t, forwarderStateClosed, p.forwarders["drain-cluster"].(*defaultForwarder).state)
+}
+
+func TestProxyCloseDrainTimeout(t *testing.T) {
+	mc := newSlowMemcache(t, time.Second)
+	defer mc.Close()
+	p, addr := newTestProxy(t, mc.Addr().String(), 100)
+
+	conn, err := net.Dial("tcp", addr)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	waitHandlers(p, 1)
+	_, err = conn.Write([]byte("get a_11\r\n"))
+	assert.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	assert.NoError(t, p.Close())
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+	_, err = bufio.NewReader(conn).ReadByte()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestProxyCloseDrainDefaultTimeout(t *testing.T) {
+	mc := newSlowMemcache(t, 200*time.Millisecond)
+	defer mc.Close()
+	p, addr := newTestProxy(t, mc.Addr().String(), 0)
+
+	conn, err := net.Dial("tcp", addr)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	waitHandlers(p, 1)
+	_, err = conn.Write([]byte("get a_11\r\n"))
+	assert.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+
+	assert.NoError(t, p.Close())
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "END\r\n", line, "0 means the default timeout instead of force closed")
+}
+
+func TestProxyReloadRemoveDrain(t *testing.T) {
+	mc := newSlowMemcache(t, 0)
+	defer mc.Close()
+	p, addr := newTestProxy(t, mc.Addr().String(), 1000)
+	defer p.Close()
+
+	conn, err := net.Dial("tcp", addr)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	waitHandlers(p, 1)
+
+	assert.NoError(t, p.Reload(nil))
+	_, err = bufio.NewReader(conn).ReadByte()
+	assert.Equal(t, io.EOF, err)
+	waitHandlers(p, 0)
+	p.lock.Lock()
+	assert.Len(t, p.handlers, 0)
+	p.lock.Unlock()
+}
+
+func TestHandlerInline(t *testing.T) {
+	rs := newFakeRedis(t)
+	defer rs.Close()
+	cc := newServedClusterConfig("inline", "", rs.Addr().String()+":1")
+	cc.CacheType = proto.CacheTypeRedis
+	p, rw := newServedTestProxy(t, cc)
+	defer p.Close()
+
+	assert.Equal(t, "+PONG\r\n", roundTrip(t, rw, "PING\r\n", 1))
+	assert.Equal(t, "+OK\r\n", roundTrip(t, rw, "set foo \"hello world\"\n", 1), "ended with LF only like nc")
+	assert.Equal(t, "$11\r\nhello world\r\n", roundTrip(t, rw, "\r\nGET 'foo'\r\n", 2))
+	assert.Equal(t, "-ERR Protocol error: unbalanced quotes in request\r\n+PONG\r\n", roundTrip(t, rw, "GET \"foo\r\nPING\r\n", 2))
+}
@@ -0,0 +1,205 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"overlord/lib/hotkey"
+	"overlord/lib/prom"
+	"overlord/proto"
+	"overlord/proto/memcache"
+	"overlord/proto/redis"
+)
+
+const (
+	// hotKeyWindow is the window of counting the reads of key.
+	hotKeyWindow = time.Second
+	// hotKeyTop is the max hot keys reported into metrics.
+	hotKeyTop = 16
+)
+
+// NOTE: the gets reply with cas unique, so cached apart from get, and the memcache key never contains space.
+var hotKeyCasSuffix = []byte(" cas")
+
+// hotRequest is implemented by requests which the hit reply can be cached by proxy.
+type hotRequest interface {
+	HitReply() ([]byte, bool)
+	WithHitReply(data []byte)
+}
+
+// hotKeyCache serves the gets of hot keys by the local cache of proxy, and the writes invalidate the local copy.
+// Only the single key get of memcache and redis can be served, the batch like multi-key get and MGET are always forwarded.
+type hotKeyCache struct {
+	cc    *ClusterConfig
+	cache *hotkey.Cache
+	hots  map[string]struct{} // NOTE: the hot keys reported into metrics
+
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newHotKeyCache(cc *ClusterConfig) *hotKeyCache {
+	hc := &hotKeyCache{
+		cc:     cc,
+		cache:  hotkey.New(cc.HotKeyThreshold, time.Duration(cc.HotKeyTTL)*time.Millisecond, cc.HotKeyMaxBytes, hotKeyTop),
+		hots:   make(map[string]struct{}),
+		closed: make(chan struct{}),
+	}
+	go hc.rotate()
+	return hc
+}
+
+// rotate report the hot keys of every window into metrics.
+func (hc *hotKeyCache) rotate() {
+	ticker := time.NewTicker(hotKeyWindow)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-hc.closed:
+			if prom.On {
+				for k := range hc.hots {
+					prom.HotKeyDelete(hc.cc.Name, k)
+				}
+			}
+			return
+		case <-ticker.C:
+		}
+		keys := hc.cache.Rotate()
+		if !prom.On {
+			continue
+		}
+		hots := make(map[string]struct{}, len(keys))
+		for _, hk := range keys {
+			prom.HotKeySet(hc.cc.Name, hk.Key, hk.Count)
+			hots[hk.Key] = struct{}{}
+		}
+		for k := range hc.hots {
+			if _, ok := hots[k]; !ok {
+				prom.HotKeyDelete(hc.cc.Name, k)
+			}
+		}
+		hc.hots = hots
+	}
+}
+
+// serve reply the gets of hot keys by the local cache and invalidate the keys of writes,
+// returns the messages appended to fwds which need forwarding.
+func (hc *hotKeyCache) serve(msgs, fwds []*proto.Message) []*proto.Message {
+	for _, m := range msgs {
+		key, ck, hr, ok := hotRead(m)
+		if !ok {
+			hc.invalidate(m)
+			fwds = append(fwds, m)
+			continue
+		}
+		hc.cache.Incr(key)
+		if data, ok := hc.cache.Get(ck); ok {
+			hr.WithHitReply(data)
+			if prom.On {
+				prom.HotCacheIncr(hc.cc.Name, "hit")
+			}
+			continue
+		}
+		if prom.On {
+			prom.HotCacheIncr(hc.cc.Name, "miss")
+		}
+		fwds = append(fwds, m)
+	}
+	return fwds
+}
+
+// store cache the hit replies of hot keys, and invalidate the keys of writes again
+// for the gets forwarded before the writes may be replied after.
+func (hc *hotKeyCache) store(msgs []*proto.Message) {
+	for _, m := range msgs {
+		key, ck, hr, ok := hotRead(m)
+		if !ok {
+			hc.invalidate(m)
+			continue
+		}
+		if m.Err() != nil || !hc.cache.IsHot(key) {
+			continue
+		}
+		if data, ok := hr.HitReply(); ok {
+			hc.cache.Set(ck, data)
+		}
+	}
+}
+
+func (hc *hotKeyCache) invalidate(m *proto.Message) {
+	if !m.IsBatch() {
+		hc.del(m.Request())
+		return
+	}
+	for _, subm := range m.Batch() {
+		hc.del(subm.Request())
+	}
+}
+
+func (hc *hotKeyCache) del(req proto.Request) {
+	switch r := req.(type) {
+	case *memcache.MCRequest:
+		if r.IsFlush() {
+			hc.cache.Purge()
+		} else if r.IsWrite() {
+			hc.cache.Del(r.Key())
+			hc.cache.Del(casKey(r.Key()))
+		}
+	case *redis.Request:
+		if r.IsFlush() {
+			hc.cache.Purge()
+		} else if keys := r.TxKeys(); len(keys) > 0 {
+			for _, key := range keys {
+				hc.cache.Del(key)
+			}
+		} else if r.IsForward() && !r.IsReadOnly() {
+			hc.cache.Del(r.Key())
+			for _, key := range r.Keys() { // NOTE: the multi-key writes like RENAME
+				hc.cache.Del(key)
+			}
+		}
+	}
+}
+
+// execed invalidate the keys written by the transactions again, for EXEC is executed by the dedicated conn when encoding.
+func (hc *hotKeyCache) execed(msgs []*proto.Message) {
+	for _, m := range msgs {
+		if r, ok := m.Request().(*redis.Request); ok {
+			for _, key := range r.TxKeys() {
+				hc.cache.Del(key)
+			}
+		}
+	}
+}
+
+func (hc *hotKeyCache) close() {
+	hc.once.Do(func() {
+		close(hc.closed)
+	})
+}
+
+// hotRead returns the key and the local cache key of the get which can be served by proxy.
+func hotRead(m *proto.Message) (key, ck []byte, hr hotRequest, ok bool) {
+	if m.IsBatch() {
+		return
+	}
+	switch req := m.Request().(type) {
+	case *memcache.MCRequest:
+		switch req.Type() {
+		case memcache.RequestTypeGet:
+			return req.Key(), req.Key(), req, true
+		case memcache.RequestTypeGets:
+			return req.Key(), casKey(req.Key()), req, true
+		}
+	case *redis.Request:
+		if req.IsGet() && !req.IsLocal() { // NOTE: local reply like NOAUTH
+			return req.Key(), req.Key(), req, true
+		}
+	}
+	return
+}
+
+func casKey(key []byte) []byte {
+	ck := make([]byte, 0, len(key)+len(hotKeyCasSuffix))
+	return append(append(ck, key...), hotKeyCasSuffix...)
+}
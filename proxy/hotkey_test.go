@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newHotKeyClusterConfig(addr string) *ClusterConfig {
+	cc := newServedClusterConfig("hotkey", "", addr+":1")
+	cc.HotKeyThreshold, cc.HotKeyTTL, cc.HotKeyMaxBytes = 2, 60000, 1024*1024
+	return cc
+}
+
+func TestHotKeyServeAndInvalidate(t *testing.T) {
+	mc := newFakeMemcache(t)
+	defer mc.Close()
+	p, rw := newServedTestProxy(t, newHotKeyClusterConfig(mc.Addr().String()))
+	defer p.Close()
+
+	p.lock.Lock()
+	hc := p.hotkeys["hotkey"]
+	p.lock.Unlock()
+	mc.set("a_11", "hello")
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, "VALUE a_11 0 5\r\nhello\r\nEND\r\n", roundTrip(t, rw, "get a_11\r\n", 3))
+		if _, ok := hc.cache.Get([]byte("a_11")); ok {
+			break
+		}
+	}
+	_, ok := hc.cache.Get([]byte("a_11"))
+	assert.True(t, ok, "hot key should be cached")
+	// NOTE: hot and served by proxy even if lost by backend.
+	mc.lock.Lock()
+	delete(mc.kvs, "a_11")
+	mc.lock.Unlock()
+	assert.Equal(t, "VALUE a_11 0 5\r\nhello\r\nEND\r\n", roundTrip(t, rw, "get a_11\r\n", 3))
+	assert.Equal(t, "END\r\n", roundTrip(t, rw, "get a_11 a_22\r\n", 1), "batch never served by proxy")
+
+	assert.Equal(t, "STORED\r\n", roundTrip(t, rw, "set a_11 0 0 5\r\nworld\r\n", 1))
+	assert.Equal(t, "VALUE a_11 0 5\r\nworld\r\nEND\r\n", roundTrip(t, rw, "get a_11\r\n", 3))
+	assert.Equal(t, "DELETED\r\n", roundTrip(t, rw, "delete a_11\r\n", 1))
+	assert.Equal(t, "END\r\n", roundTrip(t, rw, "get a_11\r\n", 1))
+}
+
+func TestHotKeyPurgeByFlush(t *testing.T) {
+	mc := newFakeMemcache(t)
+	defer mc.Close()
+	p, rw := newServedTestProxy(t, newHotKeyClusterConfig(mc.Addr().String()))
+	defer p.Close()
+
+	p.lock.Lock()
+	hc := p.hotkeys["hotkey"]
+	p.lock.Unlock()
+	mc.set("a_11", "hello")
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, "VALUE a_11 0 5\r\nhello\r\nEND\r\n", roundTrip(t, rw, "get a_11\r\n", 3))
+		if _, ok := hc.cache.Get([]byte("a_11")); ok {
+			break
+		}
+	}
+	assert.Equal(t, "OK\r\n", roundTrip(t, rw, "flush_all\r\n", 1))
+	assert.Equal(t, 0, hc.cache.Len(), "flush should purge the hot keys")
+	assert.Equal(t, "END\r\n", roundTrip(t, rw, "get a_11\r\n", 1))
+}
+
+func TestHotKeyCloseWithProxy(t *testing.T) {
+	mc := newFakeMemcache(t)
+	defer mc.Close()
+	p, _ := newServedTestProxy(t, newHotKeyClusterConfig(mc.Addr().String()))
+	hc := p.hotkeys["hotkey"]
+	p.Close()
+	select {
+	case <-hc.closed:
+	default:
+		t.Fatal("hot key cache should be closed")
+	}
+}
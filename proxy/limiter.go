@@ -0,0 +1,164 @@
+package proxy
+
+import (
+	"strings"
+	"sync"
+
+	"overlord/lib/prom"
+	"overlord/lib/ratelimit"
+	"overlord/proto"
+)
+
+// limiter limits the requests of cluster by the token buckets of ops and bytes per second,
+// the limits are of the cluster, every client IP and the commands.
+type limiter struct {
+	cc *ClusterConfig
+
+	ops   *ratelimit.Bucket // NOTE: nil means unlimited
+	bytes *ratelimit.Bucket
+	cmds  map[string]*ratelimit.Bucket // NOTE: keyed by lower case command
+
+	lock    sync.Mutex
+	clients map[string]*clientLimiter
+}
+
+// clientLimiter is the limits of client IP shared by the connections.
+type clientLimiter struct {
+	ip    string
+	ops   *ratelimit.Bucket
+	bytes *ratelimit.Bucket
+	refs  int
+}
+
+// limitBucket is the bucket and the type reported when exceeded.
+type limitBucket struct {
+	b  *ratelimit.Bucket
+	tp string
+}
+
+// newLimiter returns nil when no limit.
+func newLimiter(cc *ClusterConfig) *limiter {
+	l := &limiter{
+		cc:      cc,
+		ops:     newLimitBucket(cc.OpsLimit),
+		bytes:   newLimitBucket(cc.BytesLimit),
+		cmds:    make(map[string]*ratelimit.Bucket),
+		clients: make(map[string]*clientLimiter),
+	}
+	for cmd, n := range cc.CmdOpsLimit {
+		if n > 0 {
+			l.cmds[strings.ToLower(cmd)] = ratelimit.NewBucket(n)
+		}
+	}
+	if l.ops == nil && l.bytes == nil && len(l.cmds) == 0 && cc.ClientOpsLimit == 0 && cc.ClientBytesLimit == 0 {
+		return nil
+	}
+	return l
+}
+
+func newLimitBucket(rate int) *ratelimit.Bucket {
+	if rate <= 0 {
+		return nil
+	}
+	return ratelimit.NewBucket(rate)
+}
+
+// acquire returns the limits of client IP which must be released when the connection closed, nil when no client limit.
+func (l *limiter) acquire(ip string) *clientLimiter {
+	if l.cc.ClientOpsLimit == 0 && l.cc.ClientBytesLimit == 0 {
+		return nil
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	cl, ok := l.clients[ip]
+	if !ok {
+		cl = &clientLimiter{
+			ip:    ip,
+			ops:   newLimitBucket(l.cc.ClientOpsLimit),
+			bytes: newLimitBucket(l.cc.ClientBytesLimit),
+		}
+		l.clients[ip] = cl
+	}
+	cl.refs++
+	return cl
+}
+
+func (l *limiter) release(cl *clientLimiter) {
+	if cl == nil {
+		return
+	}
+	l.lock.Lock()
+	if cl.refs--; cl.refs == 0 {
+		delete(l.clients, cl.ip)
+	}
+	l.lock.Unlock()
+}
+
+// allow appends the messages within limits to fwds and returns, the others are replied with ErrRateLimited.
+// NOTE: the bytes are charged after replied, so all the messages are rejected until the debt paid.
+func (l *limiter) allow(cl *clientLimiter, msgs, fwds []*proto.Message) []*proto.Message {
+	var bytes [2]limitBucket
+	bytes[0].b, bytes[0].tp = l.bytes, "bytes"
+	if cl != nil {
+		bytes[1].b, bytes[1].tp = cl.bytes, "client_bytes"
+	}
+	for _, lb := range bytes {
+		if lb.b != nil && !lb.b.Ready() {
+			for _, m := range msgs {
+				l.reject(m, lb.tp)
+			}
+			return fwds
+		}
+	}
+	for _, m := range msgs {
+		if tp := l.take(cl, m); tp != "" {
+			l.reject(m, tp)
+			continue
+		}
+		fwds = append(fwds, m)
+	}
+	return fwds
+}
+
+// take the ops of message from every limit, returns the type of limit exceeded and the taken are refunded.
+// NOTE: the batch more than the limit is allowed when the bucket is full, and the excess is charged as debt.
+func (l *limiter) take(cl *clientLimiter, m *proto.Message) string {
+	n := len(m.Requests())
+	var limits [3]limitBucket
+	if cl != nil {
+		limits[0].b, limits[0].tp = cl.ops, "client_ops"
+	}
+	if len(l.cmds) > 0 {
+		limits[1].b, limits[1].tp = l.cmds[strings.ToLower(m.Request().CmdString())], "cmd"
+	}
+	limits[2].b, limits[2].tp = l.ops, "ops"
+	for i, lb := range limits {
+		if lb.b == nil || lb.b.AllowDebt(n) {
+			continue
+		}
+		for _, taken := range limits[:i] {
+			if taken.b != nil {
+				taken.b.Refund(n)
+			}
+		}
+		return lb.tp
+	}
+	return ""
+}
+
+func (l *limiter) reject(m *proto.Message, tp string) {
+	m.WithError(proto.ErrRateLimited)
+	if prom.On {
+		prom.LimitedIncr(l.cc.Name, tp)
+	}
+}
+
+// charge take the bytes read from and written to client.
+func (l *limiter) charge(cl *clientLimiter, n int) {
+	if l.bytes != nil {
+		l.bytes.Take(n)
+	}
+	if cl != nil && cl.bytes != nil {
+		cl.bytes.Take(n)
+	}
+}
@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiterOps(t *testing.T) {
+	mc := newFakeMemcache(t)
+	defer mc.Close()
+	cc := newServedClusterConfig("limit", "", mc.Addr().String()+":1")
+	cc.OpsLimit = 2
+	p, rw := newServedTestProxy(t, cc)
+	defer p.Close()
+
+	reply := roundTrip(t, rw, "get a_11\r\nget a_11\r\nget a_11\r\n", 3)
+	assert.Equal(t, "END\r\nEND\r\nSERVER_ERROR rate limit exceeded\r\n", reply)
+}
+
+func TestLimiterBatchMoreThanOps(t *testing.T) {
+	mc := newFakeMemcache(t)
+	defer mc.Close()
+	cc := newServedClusterConfig("limit", "", mc.Addr().String()+":1")
+	cc.OpsLimit = 2
+	p, rw := newServedTestProxy(t, cc)
+	defer p.Close()
+
+	assert.Equal(t, "END\r\n", roundTrip(t, rw, "get a_11 a_22 a_33 a_44\r\n", 1), "allowed when the bucket is full")
+	assert.Equal(t, "SERVER_ERROR rate limit exceeded\r\n", roundTrip(t, rw, "get a_11\r\n", 1), "the excess is charged as debt")
+}
+
+func TestLimiterClientAndCmd(t *testing.T) {
+	mc := newFakeMemcache(t)
+	defer mc.Close()
+	cc := newServedClusterConfig("limit", "", mc.Addr().String()+":1")
+	cc.ClientOpsLimit = 3
+	cc.CmdOpsLimit = map[string]int{"SET": 1}
+	p, rw := newServedTestProxy(t, cc)
+	defer p.Close()
+
+	reply := roundTrip(t, rw, "set a_11 0 0 1\r\na\r\nset a_11 0 0 1\r\na\r\nget a_11\r\n", 4)
+	assert.Equal(t, "STORED\r\nSERVER_ERROR rate limit exceeded\r\nVALUE a_11 0 1\r\na\r\n", reply)
+	// NOTE: the client ops taken by the set rejected are refunded.
+	assert.Equal(t, "END\r\n", roundTrip(t, rw, "", 1))
+	assert.Equal(t, "END\r\n", roundTrip(t, rw, "get a_22\r\n", 1))
+	assert.Equal(t, "SERVER_ERROR rate limit exceeded\r\n", roundTrip(t, rw, "get a_22\r\n", 1))
+
+	p.lock.Lock()
+	lm := p.limiters["limit"]
+	p.lock.Unlock()
+	lm.lock.Lock()
+	assert.Len(t, lm.clients, 1, "clients of same IP share the limits")
+	lm.lock.Unlock()
+}
+
+func TestLimiterBytes(t *testing.T) {
+	mc := newFakeMemcache(t)
+	defer mc.Close()
+	cc := newServedClusterConfig("limit", "", mc.Addr().String()+":1")
+	cc.BytesLimit = 16
+	p, rw := newServedTestProxy(t, cc)
+	defer p.Close()
+
+	assert.Equal(t, "STORED\r\n", roundTrip(t, rw, "set a_11 0 0 10\r\n0123456789\r\n", 1))
+	assert.Equal(t, "SERVER_ERROR rate limit exceeded\r\n", roundTrip(t, rw, "get a_11\r\n", 1))
+}
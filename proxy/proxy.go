@@ -2,6 +2,7 @@ package proxy
 
 import (
 	errs "errors"
+	"fmt"
 	"net"
 	"sync"
 	"sync/atomic"
@@ -21,14 +22,20 @@ import (
 // proxy errors
 var (
 	ErrProxyMoreMaxConns = errs.New("Proxy accept more than max connextions")
+	ErrProxyClosed       = errs.New("Proxy already closed")
 )
 
 // Proxy is proxy.
 type Proxy struct {
 	c   *Config
-	ccs []*ClusterConfig
+	ccs map[string]*ClusterConfig
 
 	forwarders map[string]proto.Forwarder
+	hotkeys    map[string]*hotKeyCache
+	limiters   map[string]*limiter
+	scripts    map[string]*scriptCache
+	listeners  map[string]net.Listener
+	handlers   map[*Handler]struct{}
 	once       sync.Once
 
 	conns int32
@@ -45,44 +52,232 @@ func New(c *Config) (p *Proxy, err error) {
 	}
 	p = &Proxy{}
 	p.c = c
+	p.handlers = map[*Handler]struct{}{}
 	return
 }
 
 // Serve is the main accept() loop of a server.
 func (p *Proxy) Serve(ccs []*ClusterConfig) {
 	p.once.Do(func() {
-		p.ccs = ccs
+		p.ccs = map[string]*ClusterConfig{}
 		p.forwarders = map[string]proto.Forwarder{}
+		p.hotkeys = map[string]*hotKeyCache{}
+		p.limiters = map[string]*limiter{}
+		p.scripts = map[string]*scriptCache{}
+		p.listeners = map[string]net.Listener{}
 		if len(ccs) == 0 {
 			log.Warnf("overlord will never listen on any port due to cluster is not specified")
 		}
+		p.lock.Lock()
+		defer p.lock.Unlock()
 		for _, cc := range ccs {
-			p.serve(cc)
+			if err := p.serve(cc); err != nil {
+				panic(err)
+			}
 		}
+		p.linkBackups()
 	})
 }
 
-func (p *Proxy) serve(cc *ClusterConfig) {
+// serve must be called with p.lock held.
+func (p *Proxy) serve(cc *ClusterConfig) error {
 	forwarder := NewForwarder(cc)
-	p.lock.Lock()
-	p.forwarders[cc.Name] = forwarder
-	p.lock.Unlock()
 	// listen
 	l, err := Listen(cc.ListenProto, cc.ListenAddr)
 	if err != nil {
-		panic(err)
+		_ = forwarder.Close()
+		return err
+	}
+	var hc *hotKeyCache
+	if cc.HotKeyThreshold > 0 {
+		hc = newHotKeyCache(cc)
+		p.hotkeys[cc.Name] = hc
 	}
+	lm := newLimiter(cc)
+	if lm != nil {
+		p.limiters[cc.Name] = lm
+	}
+	sc := newScriptCache(cc)
+	if sc != nil {
+		p.scripts[cc.Name] = sc
+	}
+	p.ccs[cc.Name] = cc
+	p.forwarders[cc.Name] = forwarder
+	p.listeners[cc.Name] = l
 	log.Infof("overlord proxy cluster[%s] addr(%s) already listened", cc.Name, cc.ListenAddr)
-	go p.accept(cc, l, forwarder)
+	go p.accept(cc, l, forwarder, hc, lm, sc)
+	return nil
+}
+
+// Reload apply the cluster configs to the running proxy.
+// New clusters will be served, removed clusters will be closed, and clusters which only changed
+// servers will be updated in place, the client connections keep alive.
+func (p *Proxy) Reload(ccs []*ClusterConfig) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.closed || p.ccs == nil {
+		return ErrProxyClosed
+	}
+	news := map[string]struct{}{}
+	for _, cc := range ccs {
+		news[cc.Name] = struct{}{}
+		occ, ok := p.ccs[cc.Name]
+		if !ok {
+			if err := p.reloadServe(cc); err != nil {
+				log.Errorf("overlord proxy reload cluster(%s) serve error:%v", cc.Name, err)
+			} else if log.V(2) {
+				log.Infof("overlord proxy reload cluster(%s) added", cc.Name)
+			}
+			continue
+		}
+		if !occ.sameWithoutServers(cc) {
+			log.Errorf("overlord proxy reload cluster(%s) changed fields other than servers, need restart to take effect", cc.Name)
+			continue
+		}
+		if occ.sameServers(cc) {
+			continue
+		}
+		if err := p.forwarders[cc.Name].Update(cc.Servers); err != nil {
+			log.Errorf("overlord proxy reload cluster(%s) update servers error:%v", cc.Name, err)
+			continue
+		}
+		p.ccs[cc.Name] = cc
+		if log.V(2) {
+			log.Infof("overlord proxy reload cluster(%s) servers updated to %v", cc.Name, cc.Servers)
+		}
+	}
+	for name := range p.ccs {
+		if _, ok := news[name]; ok {
+			continue
+		}
+		p.remove(name)
+		if log.V(2) {
+			log.Infof("overlord proxy reload cluster(%s) removed", name)
+		}
+	}
+	p.linkBackups()
+	return nil
+}
+
+// reloadServe serve the new cluster but never panic the running proxy.
+func (p *Proxy) reloadServe(cc *ClusterConfig) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("new forwarder panic:%v", r)
+		}
+	}()
+	return p.serve(cc)
+}
+
+// linkBackups link the forwarders to their backup cluster by name, must be called with p.lock held.
+func (p *Proxy) linkBackups() {
+	for name, f := range p.forwarders {
+		bf, ok := f.(*backupForwarder)
+		if !ok {
+			continue
+		}
+		backup, ok := p.forwarders[bf.cc.BackupCluster]
+		if !ok {
+			log.Errorf("overlord proxy cluster(%s) backup cluster(%s) not found", name, bf.cc.BackupCluster)
+		}
+		bf.setBackup(backup)
+	}
+}
+
+// remove must be called with p.lock held.
+// The handlers of cluster are drained and then the forwarder closed in background.
+func (p *Proxy) remove(name string) {
+	if l, ok := p.listeners[name]; ok {
+		delete(p.listeners, name)
+		_ = l.Close()
+	}
+	var hs []*Handler
+	for h := range p.handlers {
+		if h.cc.Name == name {
+			hs = append(hs, h)
+		}
+	}
+	f, ok := p.forwarders[name]
+	hc := p.hotkeys[name]
+	delete(p.forwarders, name)
+	delete(p.hotkeys, name)
+	delete(p.limiters, name)
+	delete(p.scripts, name)
+	delete(p.ccs, name)
+	go func() {
+		p.drainHandlers(hs)
+		if ok {
+			_ = f.Close()
+		}
+		if hc != nil {
+			hc.close()
+		}
+	}()
+}
+
+// addHandler record the handler, return false when proxy already closed.
+func (p *Proxy) addHandler(h *Handler) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.closed {
+		return false
+	}
+	p.handlers[h] = struct{}{}
+	return true
+}
+
+func (p *Proxy) removeHandler(h *Handler) {
+	p.lock.Lock()
+	delete(p.handlers, h)
+	p.lock.Unlock()
+}
+
+// drainHandlers drain the handlers and wait them closed until the shutdown timeout, then force close the rest.
+func (p *Proxy) drainHandlers(hs []*Handler) {
+	if len(hs) == 0 {
+		return
+	}
+	for _, h := range hs {
+		h.drain()
+	}
+	timeout := p.c.Proxy.ShutdownTimeout
+	if timeout == 0 {
+		timeout = defaultShutdownTimeout // NOTE: omitted by the config file
+	}
+	timer := time.NewTimer(time.Duration(timeout) * time.Millisecond)
+	defer timer.Stop()
+	for _, h := range hs {
+		select {
+		case <-h.Done():
+		case <-timer.C:
+			for _, h := range hs {
+				h.forceClose()
+			}
+			if log.V(2) {
+				log.Warnf("overlord proxy drain handlers timeout:%dms and force closed", timeout)
+			}
+			return
+		}
+	}
+}
+
+// listening return whether the listener still serving the cluster.
+func (p *Proxy) listening(name string, l net.Listener) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.listeners[name] == l
 }
 
-func (p *Proxy) accept(cc *ClusterConfig, l net.Listener, forwarder proto.Forwarder) {
+func (p *Proxy) accept(cc *ClusterConfig, l net.Listener, forwarder proto.Forwarder, hc *hotKeyCache, lm *limiter, sc *scriptCache) {
 	for {
 		conn, err := l.Accept()
 		if err != nil {
 			if conn != nil {
 				_ = conn.Close()
 			}
+			if !p.listening(cc.Name, l) {
+				return
+			}
 			log.Errorf("cluster(%s) addr(%s) accept connection error:%+v", cc.Name, cc.ListenAddr, err)
 			continue
 		}
@@ -96,9 +291,9 @@ func (p *Proxy) accept(cc *ClusterConfig, l net.Listener, forwarder proto.Forwar
 				case proto.CacheTypeMemcacheBinary:
 					encoder = mcbin.NewProxyConn(libnet.NewConn(conn, time.Second, time.Second))
 				case proto.CacheTypeRedis:
-					encoder = redis.NewProxyConn(libnet.NewConn(conn, time.Second, time.Second))
+					encoder = redis.NewProxyConn(libnet.NewConn(conn, time.Second, time.Second), "", false)
 				case proto.CacheTypeRedisCluster:
-					encoder = rclstr.NewProxyConn(libnet.NewConn(conn, time.Second, time.Second), nil)
+					encoder = rclstr.NewProxyConn(libnet.NewConn(conn, time.Second, time.Second), nil, "", false)
 				}
 				if encoder != nil {
 					_ = encoder.Encode(proto.ErrMessage(ErrProxyMoreMaxConns))
@@ -111,19 +306,53 @@ func (p *Proxy) accept(cc *ClusterConfig, l net.Listener, forwarder proto.Forwar
 				continue
 			}
 		}
-		NewHandler(p, cc, conn, forwarder).Handle()
+		h := NewHandler(p, cc, conn, forwarder)
+		h.hotkey = hc
+		h.scripts = sc
+		if lm != nil {
+			h.withLimiter(lm)
+		}
+		if !p.addHandler(h) {
+			h.closeWithError(ErrProxyClosed)
+			return
+		}
+		h.Handle()
 	}
 }
 
-// Close close proxy resource.
+// Close close proxy gracefully.
+// It stops accepting new connections, waits the in-flight requests finished until the shutdown timeout,
+// then closes the client connections and the forwarders with backend pipes and pingers.
 func (p *Proxy) Close() error {
 	p.lock.Lock()
-	defer p.lock.Unlock()
 	if p.closed {
+		p.lock.Unlock()
 		return nil
 	}
-	for _, forwarder := range p.forwarders {
-		forwarder.Close()
+	p.closed = true
+	for name, l := range p.listeners {
+		delete(p.listeners, name)
+		_ = l.Close()
+	}
+	hs := make([]*Handler, 0, len(p.handlers))
+	for h := range p.handlers {
+		hs = append(hs, h)
+	}
+	fs := make([]proto.Forwarder, 0, len(p.forwarders))
+	for _, f := range p.forwarders {
+		fs = append(fs, f)
+	}
+	hcs := make([]*hotKeyCache, 0, len(p.hotkeys))
+	for _, hc := range p.hotkeys {
+		hcs = append(hcs, hc)
+	}
+	p.lock.Unlock()
+	p.drainHandlers(hs)
+	for _, f := range fs {
+		_ = f.Close()
+	}
+	for _, hc := range hcs {
+		hc.close()
 	}
 	return nil
 }
@@ -560,7 +560,7 @@ func TestEject(t *testing.T) {
 	eject := ccs[0]
 	fer := p.forwarders["eject-cluster"].(*defaultForwarder)
 	mp := &mockPing{}
-	ping := &pinger{ping: mp, cc: eject, weight: 10, alias: "mc1"}
+	ping := &pinger{ping: mp, cc: eject, node: "127.0.0.1:11211", name: "mc1"}
 	go fer.processPing(ping)
 
 	for _, tt := range ts {
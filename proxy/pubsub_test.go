@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"path"
+	"strings"
+	"testing"
+
+	"overlord/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// pubsub serve the pub/sub commands of fakeRedis, the messages are written to the subscribers directly.
+func (rs *fakeRedis) pubsub(conn net.Conn, cmd string, args []string) (reply string) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	if cmd == "PUBLISH" {
+		var n int
+		for sconn, subs := range rs.subs {
+			for name, pattern := range subs {
+				var msg string
+				if !pattern && name == args[1] {
+					msg = fmt.Sprintf("*3\r\n$7\r\nmessage\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(args[1]), args[1], len(args[2]), args[2])
+				} else if ok, _ := path.Match(name, args[1]); pattern && ok {
+					msg = fmt.Sprintf("*4\r\n$8\r\npmessage\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n",
+						len(name), name, len(args[1]), args[1], len(args[2]), args[2])
+				} else {
+					continue
+				}
+				sconn.Write([]byte(msg))
+				n++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", n)
+	}
+	subs, ok := rs.subs[conn]
+	if !ok {
+		subs = map[string]bool{}
+		rs.subs[conn] = subs
+	}
+	kind := strings.ToLower(cmd)
+	for _, name := range args[1:] {
+		if strings.HasPrefix(cmd, "UN") || strings.HasPrefix(cmd, "PUN") {
+			delete(subs, name)
+		} else {
+			subs[name] = strings.HasPrefix(cmd, "P")
+		}
+		reply += fmt.Sprintf("*3\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n:%d\r\n", len(kind), kind, len(name), name, len(subs))
+	}
+	return
+}
+
+func (rs *fakeRedis) subscribed() (n int) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	for _, subs := range rs.subs {
+		n += len(subs)
+	}
+	return
+}
+
+func TestHandlerSubscribe(t *testing.T) {
+	rs1, rs2 := newFakeRedis(t), newFakeRedis(t)
+	defer rs1.Close()
+	defer rs2.Close()
+	cc := newServedClusterConfig("pubsub", "", rs1.Addr().String()+":1 rs1", rs2.Addr().String()+":1 rs2")
+	cc.CacheType = proto.CacheTypeRedis
+	p, rw := newServedTestProxy(t, cc)
+	defer p.Close()
+	conn, err := net.Dial("tcp", p.listeners[cc.Name].Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	pub := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+	reply := roundTrip(t, rw, "*3\r\n$9\r\nSUBSCRIBE\r\n$3\r\nch1\r\n$3\r\nch2\r\n", 12)
+	assert.Equal(t, "*3\r\n$9\r\nsubscribe\r\n$3\r\nch1\r\n:1\r\n*3\r\n$9\r\nsubscribe\r\n$3\r\nch2\r\n:2\r\n", reply)
+	reply = roundTrip(t, rw, "*2\r\n$10\r\nPSUBSCRIBE\r\n$3\r\nch*\r\n", 6)
+	assert.Equal(t, "*3\r\n$10\r\npsubscribe\r\n$3\r\nch*\r\n:3\r\n", reply, "the count of proxy")
+	assert.Equal(t, 4, rs1.subscribed()+rs2.subscribed(), "the patterns subscribed on all the nodes")
+
+	assert.Equal(t, ":2\r\n", roundTrip(t, pub, "*3\r\n$7\r\nPUBLISH\r\n$3\r\nch1\r\n$5\r\nhello\r\n", 1))
+	reply = roundTrip(t, rw, "", 16)
+	assert.Contains(t, reply, "*3\r\n$7\r\nmessage\r\n$3\r\nch1\r\n$5\r\nhello\r\n")
+	assert.Contains(t, reply, "*4\r\n$8\r\npmessage\r\n$3\r\nch*\r\n$3\r\nch1\r\n$5\r\nhello\r\n")
+
+	assert.Equal(t, "*2\r\n$4\r\npong\r\n$0\r\n\r\n", roundTrip(t, rw, "*1\r\n$4\r\nPING\r\n", 5))
+	assert.Equal(t, "-ERR only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT allowed in this context\r\n",
+		roundTrip(t, rw, "*2\r\n$3\r\nGET\r\n$1\r\na\r\n", 1))
+	reply = roundTrip(t, rw, "*1\r\n$11\r\nUNSUBSCRIBE\r\n", 12)
+	assert.Equal(t, "*3\r\n$11\r\nunsubscribe\r\n$3\r\nch1\r\n:2\r\n*3\r\n$11\r\nunsubscribe\r\n$3\r\nch2\r\n:1\r\n", reply)
+	reply = roundTrip(t, rw, "*1\r\n$12\r\nPUNSUBSCRIBE\r\n*1\r\n$4\r\nPING\r\n", 7)
+	assert.Equal(t, "*3\r\n$12\r\npunsubscribe\r\n$3\r\nch*\r\n:0\r\n+PONG\r\n", reply, "back to the request/response mode")
+
+	reply = roundTrip(t, rw, "*1\r\n$11\r\nUNSUBSCRIBE\r\n", 5)
+	assert.Equal(t, "*3\r\n$11\r\nunsubscribe\r\n$-1\r\n:0\r\n", reply, "nothing subscribed")
+	assert.Equal(t, 0, rs1.subscribed()+rs2.subscribed())
+}
+
+func TestHandlerSubscribeRESP3(t *testing.T) {
+	rs := newFakeRedis(t)
+	defer rs.Close()
+	cc := newServedClusterConfig("pubsub3", "", rs.Addr().String()+":1")
+	cc.CacheType = proto.CacheTypeRedis
+	p, rw := newServedTestProxy(t, cc)
+	defer p.Close()
+	conn, err := net.Dial("tcp", p.listeners[cc.Name].Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	pub := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+	reply := roundTrip(t, rw, "*2\r\n$5\r\nHELLO\r\n$1\r\n3\r\n", 26)
+	assert.True(t, strings.HasPrefix(reply, "%7\r\n$6\r\nserver\r\n"), reply)
+	assert.Contains(t, reply, "$5\r\nproto\r\n:3\r\n")
+	assert.Equal(t, "_\r\n", roundTrip(t, rw, "*2\r\n$3\r\nGET\r\n$1\r\na\r\n", 1), "nil upgraded")
+
+	reply = roundTrip(t, rw, "*2\r\n$9\r\nSUBSCRIBE\r\n$3\r\nch1\r\n", 6)
+	assert.Equal(t, ">3\r\n$9\r\nsubscribe\r\n$3\r\nch1\r\n:1\r\n", reply)
+	assert.Equal(t, ":1\r\n", roundTrip(t, pub, "*3\r\n$7\r\nPUBLISH\r\n$3\r\nch1\r\n$5\r\nhello\r\n", 1))
+	assert.Equal(t, ">3\r\n$7\r\nmessage\r\n$3\r\nch1\r\n$5\r\nhello\r\n", roundTrip(t, rw, "", 7), "pushed")
+	assert.Equal(t, "+PONG\r\n", roundTrip(t, rw, "*1\r\n$4\r\nPING\r\n", 1))
+	reply = roundTrip(t, rw, "*1\r\n$11\r\nUNSUBSCRIBE\r\n*1\r\n$11\r\nUNSUBSCRIBE\r\n", 11)
+	assert.Equal(t, ">3\r\n$11\r\nunsubscribe\r\n$3\r\nch1\r\n:0\r\n>3\r\n$11\r\nunsubscribe\r\n_\r\n:0\r\n", reply)
+}
@@ -0,0 +1,38 @@
+package proxy
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"overlord/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForwarderScan(t *testing.T) {
+	rs1, rs2 := newFakeRedis(t, "a", "b"), newFakeRedis(t, "c")
+	defer rs1.Close()
+	defer rs2.Close()
+	cc := newServedClusterConfig("scan", "", rs1.Addr().String()+":1 rs1", rs2.Addr().String()+":1 rs2")
+	cc.CacheType = proto.CacheTypeRedis
+	p, rw := newServedTestProxy(t, cc)
+	defer p.Close()
+
+	var keys []string
+	cursor := "0"
+	for i := 0; i < 3; i++ {
+		cmd := fmt.Sprintf("*4\r\n$4\r\nSCAN\r\n$%d\r\n%s\r\n$5\r\nMATCH\r\n$1\r\n*\r\n", len(cursor), cursor)
+		lines := strings.Split(roundTrip(t, rw, cmd, 6), "\r\n")
+		cursor = lines[2]
+		keys = append(keys, lines[5])
+	}
+	assert.Equal(t, "0", cursor, "all the nodes scanned over")
+	assert.Equal(t, []string{"a", "b", "c"}, keys)
+	assert.Equal(t, []string{"SCAN", "0", "MATCH", "*"}, <-rs1.args)
+	assert.Equal(t, []string{"SCAN", "1", "MATCH", "*"}, <-rs1.args, "the cursor of node")
+	assert.Equal(t, []string{"SCAN", "0", "MATCH", "*"}, <-rs2.args)
+
+	assert.Equal(t, "-ERR invalid cursor\r\n", roundTrip(t, rw, "*2\r\n$4\r\nSCAN\r\n$15\r\n844424930131968\r\n", 1), "node 3 out of range")
+	assert.Equal(t, "-ERR invalid cursor\r\n", roundTrip(t, rw, "*2\r\n$4\r\nSCAN\r\n$1\r\nx\r\n", 1))
+}
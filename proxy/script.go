@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"sync"
+
+	"overlord/lib/log"
+	"overlord/proto"
+	"overlord/proto/redis"
+)
+
+// scriptCacheMax is the max scripts cached, the new scripts are not cached when full.
+const scriptCacheMax = 4096
+
+// scriptCache caches the scripts of redis by sha1, which are learned from the EVAL and SCRIPT LOAD replied.
+// The EVALSHA replied NOSCRIPT by node is forwarded again by EVAL with the script cached,
+// so the script is loaded into the node transparently, like the node restarted or added.
+type scriptCache struct {
+	cc *ClusterConfig
+
+	lock    sync.RWMutex
+	scripts map[string][]byte
+}
+
+func newScriptCache(cc *ClusterConfig) *scriptCache {
+	if cc.CacheType != proto.CacheTypeRedis && cc.CacheType != proto.CacheTypeRedisCluster {
+		return nil
+	}
+	return &scriptCache{cc: cc, scripts: make(map[string][]byte)}
+}
+
+// reload learn the scripts from the messages replied, and forward the EVALSHA replied NOSCRIPT again
+// by EVAL with the script cached and wait for the replies.
+func (sc *scriptCache) reload(forwarder proto.Forwarder, msgs []*proto.Message, wg *sync.WaitGroup) {
+	for _, m := range msgs {
+		req, ok := m.Request().(*redis.Request)
+		if !ok || m.Err() != nil {
+			continue
+		}
+		if req.IsScriptFlush() {
+			sc.flush()
+		} else if sha, script, ok := req.Script(); ok {
+			sc.set(sha, script)
+		}
+	}
+	var evals []*proto.Message
+	for _, m := range msgs {
+		if m.IsBatch() || m.Err() != nil {
+			continue
+		}
+		req, ok := m.Request().(*redis.Request)
+		if !ok {
+			continue
+		}
+		sha, ok := req.NoScript()
+		if !ok {
+			continue
+		}
+		script, ok := sc.get(sha)
+		if !ok {
+			continue
+		}
+		req.WithScript(script)
+		evals = append(evals, m)
+	}
+	if len(evals) == 0 {
+		return
+	}
+	if log.V(5) {
+		log.Infof("cluster(%s) reload %d scripts replied NOSCRIPT by EVAL", sc.cc.Name, len(evals))
+	}
+	_ = forwarder.Forward(evals)
+	wg.Wait()
+}
+
+func (sc *scriptCache) set(sha string, script []byte) {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+	if _, ok := sc.scripts[sha]; ok || len(sc.scripts) >= scriptCacheMax {
+		return
+	}
+	sc.scripts[sha] = append([]byte(nil), script...)
+}
+
+func (sc *scriptCache) get(sha string) (script []byte, ok bool) {
+	sc.lock.RLock()
+	script, ok = sc.scripts[sha]
+	sc.lock.RUnlock()
+	return
+}
+
+// flush clear the scripts for SCRIPT FLUSH, so the EVALSHA is replied NOSCRIPT as the node.
+func (sc *scriptCache) flush() {
+	sc.lock.Lock()
+	sc.scripts = make(map[string][]byte)
+	sc.lock.Unlock()
+}
@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+
+	"overlord/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func (rs *fakeRedis) script(cmd string, args []string) string {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	switch cmd + " " + strings.ToUpper(args[1]) {
+	case "SCRIPT LOAD":
+		sha := rs.load(args[2])
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(sha), sha)
+	case "SCRIPT EXISTS":
+		reply := fmt.Sprintf("*%d\r\n", len(args)-2)
+		for _, sha := range args[2:] {
+			if _, ok := rs.scripts[sha]; ok {
+				reply += ":1\r\n"
+			} else {
+				reply += ":0\r\n"
+			}
+		}
+		return reply
+	case "SCRIPT FLUSH":
+		rs.scripts = map[string]string{}
+		return "+OK\r\n"
+	}
+	var script string
+	switch cmd {
+	case "EVAL":
+		rs.load(args[1])
+		script = args[1]
+	case "EVALSHA":
+		var ok bool
+		if script, ok = rs.scripts[args[1]]; !ok {
+			return "-NOSCRIPT No matching script. Please use EVAL.\r\n"
+		}
+	default:
+		return "-ERR unknown command\r\n"
+	}
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(script), script)
+}
+
+// load must be called with lock held.
+func (rs *fakeRedis) load(script string) string {
+	sum := sha1.Sum([]byte(script))
+	sha := hex.EncodeToString(sum[:])
+	rs.scripts[sha] = script
+	return sha
+}
+
+func (rs *fakeRedis) loaded() int {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	return len(rs.scripts)
+}
+
+func evalSha(sha, key string) string {
+	return fmt.Sprintf("*4\r\n$7\r\nEVALSHA\r\n$%d\r\n%s\r\n$1\r\n1\r\n$%d\r\n%s\r\n", len(sha), sha, len(key), key)
+}
+
+func TestScriptReload(t *testing.T) {
+	rs1, rs2 := newFakeRedis(t), newFakeRedis(t)
+	defer rs1.Close()
+	defer rs2.Close()
+	cc := newServedClusterConfig("script", "", rs1.Addr().String()+":1 rs1", rs2.Addr().String()+":1 rs2")
+	cc.CacheType = proto.CacheTypeRedis
+	p, rw := newServedTestProxy(t, cc)
+	defer p.Close()
+
+	const (
+		script = "return 1"
+		sha    = "e0e1f9fabfc9d4800c877a703b823ac0578ff8db"
+	)
+	assert.Equal(t, "-NOSCRIPT No matching script. Please use EVAL.\r\n", roundTrip(t, rw, evalSha(sha, "a"), 1), "script unknown by proxy")
+	assert.Equal(t, "$40\r\n"+sha+"\r\n", roundTrip(t, rw, "*3\r\n$6\r\nSCRIPT\r\n$4\r\nLOAD\r\n$8\r\n"+script+"\r\n", 2))
+	assert.Equal(t, "*2\r\n:1\r\n:0\r\n", roundTrip(t, rw, "*4\r\n$6\r\nSCRIPT\r\n$6\r\nEXISTS\r\n$40\r\n"+sha+"\r\n$1\r\nx\r\n", 3))
+
+	// NOTE: the nodes lost the script and the proxy loads it again by EVAL.
+	for _, rs := range []*fakeRedis{rs1, rs2} {
+		rs.lock.Lock()
+		rs.scripts = map[string]string{}
+		rs.lock.Unlock()
+	}
+	rs1.lock.Lock()
+	rs1.load(script)
+	rs1.lock.Unlock()
+	assert.Equal(t, "*2\r\n:0\r\n:0\r\n", roundTrip(t, rw, "*4\r\n$6\r\nSCRIPT\r\n$6\r\nEXISTS\r\n$40\r\n"+sha+"\r\n$1\r\nx\r\n", 3), "not all nodes loaded")
+	assert.Equal(t, "$8\r\n"+script+"\r\n", roundTrip(t, rw, evalSha(sha, "a"), 2))
+	assert.Equal(t, 1, rs2.loaded(), "loaded into the node of key")
+
+	assert.Equal(t, "+OK\r\n", roundTrip(t, rw, "*2\r\n$6\r\nSCRIPT\r\n$5\r\nFLUSH\r\n", 1))
+	assert.Equal(t, "-NOSCRIPT No matching script. Please use EVAL.\r\n", roundTrip(t, rw, evalSha(sha, "a"), 1), "the cache of proxy flushed")
+	assert.Equal(t, "$8\r\n"+script+"\r\n", roundTrip(t, rw, "*4\r\n$4\r\nEVAL\r\n$8\r\n"+script+"\r\n$1\r\n1\r\n$1\r\na\r\n", 2))
+}
@@ -0,0 +1,207 @@
+package proxy
+
+import (
+	"sync"
+
+	"overlord/lib/log"
+	"overlord/lib/prom"
+	"overlord/proto"
+	"overlord/proto/memcache"
+)
+
+const (
+	// tieredMaxQueued is the max batches of L1 populating queued, the more are dropped.
+	tieredMaxQueued = 1024
+)
+
+// tieredForwarder is the two-tier cache which the small and fast L1 pool in front of the L2 pool.
+// The gets read L1 first, the missed read L2 and then populate L1 with l1_ttl asynchronously.
+// The other commands are forwarded to L2, and the writes are written to L1 too or invalidate L1 by l1_write_mode.
+type tieredForwarder struct {
+	*defaultForwarder // NOTE: the L2 pool
+
+	l1        *defaultForwarder
+	populates *asyncQueue
+}
+
+func newTieredForwarder(cc *ClusterConfig) proto.Forwarder {
+	l1cc := *cc
+	l1cc.Name = cc.Name + "-l1"
+	l1cc.Servers, l1cc.L1Servers = cc.L1Servers, nil
+	return &tieredForwarder{
+		defaultForwarder: newDefaultForwarder(cc).(*defaultForwarder),
+		l1:               newDefaultForwarder(&l1cc).(*defaultForwarder),
+		populates:        newAsyncQueue(tieredMaxQueued),
+	}
+}
+
+// Forward impl proto.Forwarder, the gets are forwarded to L1 and the others to L2.
+// NOTE: the sets are written to L1 with l1_ttl along with L2 in write mode and flush_all flushes both tiers,
+// the other writes and the sets failed by L2 invalidate L1 after L2 replied, see Fallback.
+func (f *tieredForwarder) Forward(msgs []*proto.Message) error {
+	var reads, others, l1ws []*proto.Message
+	for _, m := range msgs {
+		req, ok := m.Request().(*memcache.MCRequest)
+		if !ok {
+			others = append(others, m)
+			continue
+		}
+		switch req.Type() {
+		case memcache.RequestTypeGet:
+			reads = append(reads, m)
+			continue
+		case memcache.RequestTypeSet:
+			if set, ok := req.CloneWithExptime(f.cc.L1TTL); ok && f.cc.L1WriteMode != L1WriteModeInvalidate {
+				sm := proto.NewMessage()
+				sm.Type = m.Type
+				sm.WithRequest(set)
+				l1ws = append(l1ws, sm)
+			}
+		case memcache.RequestTypeFlushAll:
+			fm := proto.NewMessage() // NOTE: L1 is flushed in any write mode, copied for the reply overwrites the request
+			fm.Type = m.Type
+			fm.WithRequest(req.Clone())
+			l1ws = append(l1ws, fm)
+		}
+		others = append(others, m)
+	}
+	wg := &sync.WaitGroup{}
+	for _, sm := range l1ws {
+		sm.WithWaitGroup(wg)
+	}
+	if len(l1ws) > 0 {
+		_ = f.l1.Forward(l1ws)
+	}
+	var err error
+	if len(others) > 0 {
+		err = f.defaultForwarder.Forward(others)
+	}
+	if len(reads) > 0 {
+		if rerr := f.l1.Forward(reads); err == nil {
+			err = rerr
+		}
+	}
+	wg.Wait()
+	f.logL1Errors(l1ws, "write")
+	proto.PutMsgs(l1ws)
+	return err
+}
+
+// Fallback invalidate L1 by the writes, and forward the gets failed or missed by L1 to L2 and wait for the replies,
+// the values read from L2 populate L1 asynchronously.
+func (f *tieredForwarder) Fallback(msgs []*proto.Message, wg *sync.WaitGroup) {
+	var (
+		fbs  []*proto.Message
+		dels []*proto.Message
+	)
+	collect := func(m *proto.Message) {
+		req := m.Request().(*memcache.MCRequest)
+		if m.Err() == nil && req.IsHit() {
+			if prom.On {
+				prom.TieredIncr(f.cc.Name, "hit")
+			}
+			return
+		}
+		if prom.On {
+			prom.TieredIncr(f.cc.Name, "miss")
+		}
+		m.WithError(nil)
+		req.ResetRetrieval()
+		fbs = append(fbs, m)
+	}
+	for _, m := range msgs {
+		req, ok := m.Request().(*memcache.MCRequest)
+		if !ok {
+			continue
+		}
+		if req.Type() == memcache.RequestTypeGet {
+			if !m.IsBatch() {
+				collect(m)
+				continue
+			}
+			m.WithError(nil) // NOTE: the batch failed by L1 hash miss
+			for _, subm := range m.Batch() {
+				collect(subm)
+			}
+			continue
+		}
+		if !req.IsWrite() || m.IsBatch() {
+			continue
+		}
+		if req.Type() == memcache.RequestTypeSet && f.cc.L1WriteMode != L1WriteModeInvalidate && m.Err() == nil && req.IsStored() {
+			continue // NOTE: written to L1 along with L2, and invalidated when L2 failed
+		}
+		dm := proto.NewMessage()
+		dm.Type = m.Type
+		dm.WithRequest(memcache.NewDeleteReq(req.Key()))
+		dels = append(dels, dm)
+	}
+	dwg := &sync.WaitGroup{}
+	for _, dm := range dels {
+		dm.WithWaitGroup(dwg)
+	}
+	if len(dels) > 0 {
+		_ = f.l1.Forward(dels)
+	}
+	if len(fbs) > 0 {
+		_ = f.defaultForwarder.Forward(fbs)
+		wg.Wait()
+	}
+	dwg.Wait()
+	f.logL1Errors(dels, "invalidate")
+	proto.PutMsgs(dels)
+	f.populate(fbs)
+}
+
+// populate set the values read from L2 into L1 with l1_ttl asynchronously.
+func (f *tieredForwarder) populate(fbs []*proto.Message) {
+	var sets []*proto.Message
+	for _, m := range fbs {
+		if m.Err() != nil {
+			continue
+		}
+		set, ok := m.Request().(*memcache.MCRequest).ToSet(f.cc.L1TTL)
+		if !ok {
+			continue
+		}
+		sm := proto.NewMessage()
+		sm.Type = m.Type
+		sm.WithRequest(set)
+		sets = append(sets, sm)
+	}
+	if len(sets) == 0 {
+		return
+	}
+	ok := f.populates.push(f.l1, sets, func(sets []*proto.Message) {
+		f.logL1Errors(sets, "populate")
+		if prom.On {
+			prom.TieredIncr(f.cc.Name, "populate")
+		}
+	})
+	if !ok {
+		if prom.On {
+			prom.TieredIncr(f.cc.Name, "drop")
+		}
+		if log.V(3) {
+			log.Warnf("cluster(%s) too many queued L1 populating and drop", f.cc.Name)
+		}
+	}
+}
+
+func (f *tieredForwarder) logL1Errors(msgs []*proto.Message, op string) {
+	if !log.V(3) {
+		return
+	}
+	for _, m := range msgs {
+		if err := m.Err(); err != nil {
+			log.Warnf("cluster(%s) L1 %s key:%s error:%v", f.cc.Name, op, m.Request().Key(), err)
+		}
+	}
+}
+
+// Close impl proto.Forwarder, both tiers are closed.
+func (f *tieredForwarder) Close() error {
+	f.populates.close()
+	_ = f.l1.Close()
+	return f.defaultForwarder.Close()
+}
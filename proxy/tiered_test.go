@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTieredClusterConfig(l1, l2, mode string) *ClusterConfig {
+	cc := newServedClusterConfig("tiered", "", l2+":1")
+	cc.L1Servers = []string{l1 + ":1"}
+	cc.L1TTL = 60
+	cc.L1WriteMode = mode
+	return cc
+}
+
+func waitFakeKey(mc *fakeMemcache, k string, exist bool) {
+	for i := 0; i < 100; i++ {
+		if _, ok := mc.get(k); ok == exist {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTieredReadThroughAndPopulate(t *testing.T) {
+	l1, l2 := newFakeMemcache(t), newFakeMemcache(t)
+	defer l1.Close()
+	defer l2.Close()
+	p, rw := newServedTestProxy(t, newTieredClusterConfig(l1.Addr().String(), l2.Addr().String(), ""))
+	defer p.Close()
+
+	l2.set("a_11", "hello")
+	l2.set("a_22", "world")
+	assert.Equal(t, "VALUE a_11 0 5\r\nhello\r\nEND\r\n", roundTrip(t, rw, "get a_11\r\n", 3))
+	waitFakeKey(l1, "a_11", true)
+	v, ok := l1.get("a_11")
+	assert.True(t, ok, "L1 should be populated by L2")
+	assert.Equal(t, "hello", v)
+
+	// NOTE: served by L1 even if L2 lost.
+	l2.lock.Lock()
+	delete(l2.kvs, "a_11")
+	l2.lock.Unlock()
+	reply := roundTrip(t, rw, "get a_11 a_22 a_33\r\n", 5)
+	assert.Equal(t, "VALUE a_11 0 5\r\nhello\r\nVALUE a_22 0 5\r\nworld\r\nEND\r\n", reply)
+	waitFakeKey(l1, "a_22", true)
+	_, ok = l1.get("a_33")
+	assert.False(t, ok)
+}
+
+func TestTieredWriteBoth(t *testing.T) {
+	l1, l2 := newFakeMemcache(t), newFakeMemcache(t)
+	defer l1.Close()
+	defer l2.Close()
+	p, rw := newServedTestProxy(t, newTieredClusterConfig(l1.Addr().String(), l2.Addr().String(), L1WriteModeWrite))
+	defer p.Close()
+
+	assert.Equal(t, "STORED\r\n", roundTrip(t, rw, "set a_11 0 0 5\r\nhello\r\n", 1))
+	v, ok := l1.get("a_11")
+	assert.True(t, ok, "set should be written to L1")
+	assert.Equal(t, "hello", v)
+	v, _ = l2.get("a_11")
+	assert.Equal(t, "hello", v)
+
+	assert.Equal(t, "DELETED\r\n", roundTrip(t, rw, "delete a_11\r\n", 1))
+	_, ok = l1.get("a_11")
+	assert.False(t, ok, "delete should invalidate L1")
+}
+
+func TestTieredInvalidate(t *testing.T) {
+	l1, l2 := newFakeMemcache(t), newFakeMemcache(t)
+	defer l1.Close()
+	defer l2.Close()
+	p, rw := newServedTestProxy(t, newTieredClusterConfig(l1.Addr().String(), l2.Addr().String(), L1WriteModeInvalidate))
+	defer p.Close()
+
+	l1.set("a_11", "stale")
+	assert.Equal(t, "STORED\r\n", roundTrip(t, rw, "set a_11 0 0 5\r\nhello\r\n", 1))
+	_, ok := l1.get("a_11")
+	assert.False(t, ok, "set should invalidate L1")
+	assert.Equal(t, "VALUE a_11 0 5\r\nhello\r\nEND\r\n", roundTrip(t, rw, "get a_11\r\n", 3))
+}
+
+func TestTieredFlushBoth(t *testing.T) {
+	l1, l2 := newFakeMemcache(t), newFakeMemcache(t)
+	defer l1.Close()
+	defer l2.Close()
+	p, rw := newServedTestProxy(t, newTieredClusterConfig(l1.Addr().String(), l2.Addr().String(), L1WriteModeInvalidate))
+	defer p.Close()
+
+	l1.set("a_11", "hello")
+	l2.set("a_11", "hello")
+	assert.Equal(t, "OK\r\n", roundTrip(t, rw, "flush_all\r\n", 1))
+	_, ok := l1.get("a_11")
+	assert.False(t, ok, "flush_all should flush L1")
+	assert.Equal(t, "END\r\n", roundTrip(t, rw, "get a_11\r\n", 1))
+}
+
+func TestTieredL1Down(t *testing.T) {
+	l1, l2 := newFakeMemcache(t), newFakeMemcache(t)
+	defer l2.Close()
+	addr := l1.Addr().String()
+	l1.Close()
+	p, rw := newServedTestProxy(t, newTieredClusterConfig(addr, l2.Addr().String(), ""))
+	defer p.Close()
+
+	l2.set("a_11", "hello")
+	assert.Equal(t, "VALUE a_11 0 5\r\nhello\r\nEND\r\n", roundTrip(t, rw, "get a_11\r\n", 3))
+	assert.Equal(t, "END\r\n", roundTrip(t, rw, "get a_22\r\n", 1))
+}
+
+func TestTieredInvalidateWhenL2Failed(t *testing.T) {
+	l1, l2 := newFakeMemcache(t), newFakeMemcache(t)
+	defer l1.Close()
+	addr := l2.Addr().String()
+	l2.Close()
+	p, rw := newServedTestProxy(t, newTieredClusterConfig(l1.Addr().String(), addr, L1WriteModeWrite))
+	defer p.Close()
+
+	reply := roundTrip(t, rw, "set a_11 0 0 5\r\nhello\r\n", 1)
+	assert.NotEqual(t, "STORED\r\n", reply)
+	_, ok := l1.get("a_11")
+	assert.False(t, ok, "the set failed by L2 should be invalidated from L1")
+}
@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"overlord/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeTx is the transaction state of the conn to fakeRedis.
+type fakeTx struct {
+	multi   bool
+	queued  [][]string
+	watched map[string]int // NOTE: the versions of keys watched
+}
+
+// tx serve MULTI, EXEC, DISCARD, WATCH and the GET, SET of fakeRedis, false means not the commands.
+func (rs *fakeRedis) tx(conn net.Conn, args []string) (string, bool) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	t, ok := rs.txs[conn]
+	if !ok {
+		t = &fakeTx{watched: map[string]int{}}
+		rs.txs[conn] = t
+	}
+	switch cmd := strings.ToUpper(args[0]); cmd {
+	case "MULTI":
+		t.multi = true
+		return "+OK\r\n", true
+	case "DISCARD":
+		delete(rs.txs, conn)
+		return "+OK\r\n", true
+	case "WATCH":
+		for _, key := range args[1:] {
+			t.watched[key] = rs.version[key]
+		}
+		return "+OK\r\n", true
+	case "EXEC":
+		delete(rs.txs, conn)
+		for key, version := range t.watched {
+			if rs.version[key] != version {
+				return "*-1\r\n", true
+			}
+		}
+		reply := fmt.Sprintf("*%d\r\n", len(t.queued))
+		for _, q := range t.queued {
+			reply += rs.kv(q)
+		}
+		return reply, true
+	case "GET", "SET":
+		if t.multi {
+			t.queued = append(t.queued, args)
+			return "+QUEUED\r\n", true
+		}
+		return rs.kv(args), true
+	}
+	return "", false
+}
+
+// kv must be called with lock held.
+func (rs *fakeRedis) kv(args []string) string {
+	if strings.ToUpper(args[0]) == "SET" {
+		rs.data[args[1]] = args[2]
+		rs.version[args[1]]++
+		return "+OK\r\n"
+	}
+	val, ok := rs.data[args[1]]
+	if !ok {
+		return "$-1\r\n"
+	}
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(val), val)
+}
+
+func TestHandlerTx(t *testing.T) {
+	rs1, rs2 := newFakeRedis(t), newFakeRedis(t)
+	defer rs1.Close()
+	defer rs2.Close()
+	cc := newServedClusterConfig("tx", "", rs1.Addr().String()+":1", rs2.Addr().String()+":1")
+	cc.CacheType = proto.CacheTypeRedis
+	cc.HashTag = "{}"
+	p, rw := newServedTestProxy(t, cc)
+	defer p.Close()
+	conn, err := net.Dial("tcp", p.listeners[cc.Name].Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	other := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+	cmd := "*1\r\n$5\r\nMULTI\r\n*3\r\n$3\r\nSET\r\n$4\r\n{a}1\r\n$1\r\nx\r\n*2\r\n$3\r\nGET\r\n$4\r\n{a}1\r\n*1\r\n$4\r\nEXEC\r\n"
+	assert.Equal(t, "+OK\r\n+QUEUED\r\n+QUEUED\r\n*2\r\n+OK\r\n$1\r\nx\r\n", roundTrip(t, rw, cmd, 7), "pipelined")
+
+	assert.Equal(t, "+OK\r\n", roundTrip(t, rw, "*2\r\n$5\r\nWATCH\r\n$4\r\n{a}1\r\n", 1))
+	assert.Equal(t, "+OK\r\n", roundTrip(t, other, "*3\r\n$3\r\nSET\r\n$4\r\n{a}1\r\n$1\r\ny\r\n", 1), "changed by another client")
+	cmd = "*1\r\n$5\r\nMULTI\r\n*3\r\n$3\r\nSET\r\n$4\r\n{a}2\r\n$1\r\nz\r\n*1\r\n$4\r\nEXEC\r\n"
+	assert.Equal(t, "+OK\r\n+QUEUED\r\n*-1\r\n", roundTrip(t, rw, cmd, 3), "aborted by WATCH")
+	assert.Equal(t, "$-1\r\n", roundTrip(t, rw, "*2\r\n$3\r\nGET\r\n$4\r\n{a}2\r\n", 1))
+
+	f := p.forwarders[cc.Name].(*defaultForwarder)
+	first, _ := f.ring.GetNode([]byte("0-key"))
+	var cross string
+	for i := 1; i < 100 && cross == ""; i++ {
+		key := fmt.Sprintf("%d-key", i)
+		if node, _ := f.ring.GetNode([]byte(key)); node != first {
+			cross = key
+		}
+	}
+	assert.NotEmpty(t, cross)
+	cmd = fmt.Sprintf("*1\r\n$5\r\nMULTI\r\n*2\r\n$3\r\nGET\r\n$5\r\n0-key\r\n*2\r\n$3\r\nGET\r\n$%d\r\n%s\r\n*1\r\n$4\r\nEXEC\r\n", len(cross), cross)
+	assert.Equal(t, "+OK\r\n+QUEUED\r\n+QUEUED\r\n-CROSSSLOT Keys in request don't hash to the same slot\r\n", roundTrip(t, rw, cmd, 4))
+
+	cmd = "*1\r\n$5\r\nMULTI\r\n*2\r\n$9\r\nSUBSCRIBE\r\n$2\r\nch\r\n*1\r\n$4\r\nEXEC\r\n"
+	reply := roundTrip(t, rw, cmd, 3)
+	assert.Equal(t, "+OK\r\n-ERR command not allowed in transaction\r\n-EXECABORT Transaction discarded because of previous errors.\r\n", reply)
+
+	cmd = "*1\r\n$5\r\nMULTI\r\n*3\r\n$3\r\nSET\r\n$4\r\n{a}1\r\n$1\r\nx\r\n*1\r\n$7\r\nDISCARD\r\n*1\r\n$4\r\nEXEC\r\n"
+	assert.Equal(t, "+OK\r\n+QUEUED\r\n+OK\r\n-ERR EXEC without MULTI\r\n", roundTrip(t, rw, cmd, 4))
+	assert.Equal(t, "$1\r\ny\r\n", roundTrip(t, rw, "*2\r\n$3\r\nGET\r\n$4\r\n{a}1\r\n", 2), "discarded")
+}
+
+func TestHotKeyInvalidateByExec(t *testing.T) {
+	rs := newFakeRedis(t)
+	defer rs.Close()
+	cc := newServedClusterConfig("tx-hotkey", "", rs.Addr().String()+":1")
+	cc.CacheType = proto.CacheTypeRedis
+	cc.HotKeyThreshold, cc.HotKeyTTL, cc.HotKeyMaxBytes = 2, 60000, 1024*1024
+	p, rw := newServedTestProxy(t, cc)
+	defer p.Close()
+
+	p.lock.Lock()
+	hc := p.hotkeys[cc.Name]
+	p.lock.Unlock()
+	assert.Equal(t, "+OK\r\n", roundTrip(t, rw, "*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\nx\r\n", 1))
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, "$1\r\nx\r\n", roundTrip(t, rw, "*2\r\n$3\r\nGET\r\n$1\r\na\r\n", 2))
+		if _, ok := hc.cache.Get([]byte("a")); ok {
+			break
+		}
+	}
+	_, ok := hc.cache.Get([]byte("a"))
+	assert.True(t, ok, "hot key should be cached")
+
+	cmd := "*1\r\n$5\r\nMULTI\r\n*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\ny\r\n*1\r\n$4\r\nEXEC\r\n"
+	assert.Equal(t, "+OK\r\n+QUEUED\r\n*1\r\n+OK\r\n", roundTrip(t, rw, cmd, 4))
+	_, ok = hc.cache.Get([]byte("a"))
+	assert.False(t, ok, "the keys written by EXEC should be invalidated")
+	assert.Equal(t, "$1\r\ny\r\n", roundTrip(t, rw, "*2\r\n$3\r\nGET\r\n$1\r\na\r\n", 2))
+}